@@ -138,14 +138,24 @@ func isError(result *gjson.Result) error {
 
 
 
-	errInfo := fmt.Sprintf("[%d]%s",
-		result.Get("error.code").Int(),
-		result.Get("error.message").String())
-	err = errors.New(errInfo)
+	err = &RPCError{
+		Code:    result.Get("error.code").Int(),
+		Message: result.Get("error.message").String(),
+	}
 
 	return err
 }
 
+//RPCError 节点返回的错误，说明请求已被节点处理
+type RPCError struct {
+	Code    int64
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("[%d]%s", e.Code, e.Message)
+}
+
 
 func (c *Client) LocalSeed2Sk(seed string) (string, error) {
 	request := []interface{}{
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+//CurrencyBalance 账户一个币种的余额，金额按币种精度计算
+type CurrencyBalance struct {
+	Currency     string `json:"currency"`
+	Decimals     int32  `json:"decimals"`
+	Total        string `json:"total"`        //全部utxo
+	Confirmed    string `json:"confirmed"`    //确认数大于MinConfirms
+	Unconfirmed  string `json:"unconfirmed"`  //确认数不大于MinConfirms
+	Locked       string `json:"locked"`       //被交易单锁定
+	Spendable    string `json:"spendable"`    //已确认、未锁定、不持有票据，可用于转账
+	UnspentCount int    `json:"unspentCount"` //utxo数量
+}
+
+//AccountBalance 账户所有币种的余额
+type AccountBalance struct {
+	AccountID string             `json:"accountID"`
+	Height    uint64             `json:"height"` //计算确认数的区块高度
+	Balances  []*CurrencyBalance `json:"balances"`
+}
+
+//currencyBalanceSum 统计中的币种余额，最小单位
+type currencyBalanceSum struct {
+	total       decimal.Decimal
+	confirmed   decimal.Decimal
+	unconfirmed decimal.Decimal
+	locked      decimal.Decimal
+	spendable   decimal.Decimal
+	count       int
+}
+
+//add 统计一个utxo，height为计算确认数的区块高度
+func (sum *currencyBalanceSum) add(u *Unspent, height uint64) {
+
+	value, _ := decimal.NewFromString(u.Value)
+	sum.total = sum.total.Add(value)
+	sum.count++
+
+	isConfirmed := height > u.Height && height-u.Height > MinConfirms
+	if isConfirmed {
+		sum.confirmed = sum.confirmed.Add(value)
+	} else {
+		sum.unconfirmed = sum.unconfirmed.Add(value)
+	}
+
+	if u.Sending {
+		sum.locked = sum.locked.Add(value)
+	}
+
+	if isConfirmed && !u.Sending && len(u.Ticket) == 0 {
+		sum.spendable = sum.spendable.Add(value)
+	}
+}
+
+//currencyBalance 按币种精度转为币种余额
+func (sum *currencyBalanceSum) currencyBalance(currency string, decimals int32) *CurrencyBalance {
+	return &CurrencyBalance{
+		Currency:     currency,
+		Decimals:     decimals,
+		Total:        sum.total.Shift(-decimals).String(),
+		Confirmed:    sum.confirmed.Shift(-decimals).String(),
+		Unconfirmed:  sum.unconfirmed.Shift(-decimals).String(),
+		Locked:       sum.locked.Shift(-decimals).String(),
+		Spendable:    sum.spendable.Shift(-decimals).String(),
+		UnspentCount: sum.count,
+	}
+}
+
+//balanceHeight 计算确认数的区块高度，节点不可用时使用本地已扫描的高度
+func (wm *WalletManager) balanceHeight() uint64 {
+	height, err := wm.GetBlockHeight()
+	if err != nil {
+		height, _ = wm.Blockscanner.GetLocalBlockHead()
+		wm.Log.Warningf("get block height from node failed, use local block height: %d, unexpected error: %v", height, err)
+	}
+	return height
+}
+
+//GetAccountBalance 一次读取账户的utxo，统计每个币种的已确认、未确认、锁定和可用余额
+func (wm *WalletManager) GetAccountBalance(tk string) (*AccountBalance, error) {
+
+	unspents, err := wm.ListAccountUnspent(tk)
+	if err != nil {
+		return nil, err
+	}
+
+	height := wm.balanceHeight()
+	sums := make(map[string]*currencyBalanceSum)
+	currencies := make([]string, 0)
+
+	for _, u := range unspents {
+
+		//只持有票据的utxo没有同质化通证
+		if len(u.Currency) == 0 {
+			continue
+		}
+
+		sum := sums[u.Currency]
+		if sum == nil {
+			sum = &currencyBalanceSum{}
+			sums[u.Currency] = sum
+			currencies = append(currencies, u.Currency)
+		}
+
+		sum.add(u, height)
+	}
+
+	sort.Strings(currencies)
+
+	balance := &AccountBalance{
+		AccountID: tk,
+		Height:    height,
+		Balances:  make([]*CurrencyBalance, 0),
+	}
+
+	for _, currency := range currencies {
+		sum := sums[currency]
+
+		decimals := wm.Decimal()
+		if currency != wm.Symbol() {
+			decimals = int32(wm.TokenCoin(currency).Contract.Decimals)
+		}
+
+		balance.Balances = append(balance.Balances, sum.currencyBalance(currency, decimals))
+	}
+
+	return balance, nil
+}
+
+//GetAddressBalance 查询地址一个币种的余额，按Address索引读取地址的utxo，
+//统计全部、已确认、未确认、锁定和可用余额
+func (wm *WalletManager) GetAddressBalance(address, currency string, decimals int32, height uint64) (*CurrencyBalance, error) {
+
+	var utxo []*Unspent
+	err := wm.unspentDB.Find("Address", address, &utxo)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	sum := &currencyBalanceSum{}
+	for _, u := range utxo {
+		if u.Currency != currency {
+			continue
+		}
+		sum.add(u, height)
+	}
+
+	return sum.currencyBalance(currency, decimals), nil
+}
+
+//Balance 转为openwallet的余额，Balance为全部余额，等于ConfirmBalance与UnconfirmBalance之和，
+//ConfirmBalance为确认数大于MinConfirms的余额，UnconfirmBalance为确认数不大于MinConfirms的余额，
+//可用于转账的余额和锁定余额通过GetAddressBalance查询
+func (b *CurrencyBalance) Balance(symbol, address string) *openwallet.Balance {
+	return &openwallet.Balance{
+		Symbol:           symbol,
+		Address:          address,
+		Balance:          b.Total,
+		ConfirmBalance:   b.Confirmed,
+		UnconfirmBalance: b.Unconfirmed,
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"testing"
+)
+
+func TestWalletManager_GetAddressBalance(t *testing.T) {
+
+	wm, cleanup := testUnspentDBWalletManager(t)
+	defer cleanup()
+	bs := &SEROBlockScanner{wm: wm}
+
+	unspents := []*Unspent{
+		{Root: "r1", Height: 50, Value: "10000"},
+		{Root: "r2", Height: 95, Value: "2000"},
+		{Root: "r3", Height: 40, Value: "3000", Sending: true},
+		{Root: "r4", Height: 40, Value: "500", Ticket: "0x01"},
+		{Root: "r5", Height: 95, Value: "700", Sending: true},
+		//100 - 88 = 12，确认数不大于12
+		{Root: "r6", Height: 88, Value: "100"},
+		{Root: "r7", Height: 87, Value: "200"},
+	}
+	for i, u := range unspents {
+		u.Address = "a1"
+		u.TK = "tk"
+		u.Currency = "SERO"
+		if err := bs.SaveUnspent(u, []string{u.Root + "_nil"}); err != nil {
+			t.Fatalf("unspent %d: SaveUnspent() error = %v", i, err)
+		}
+	}
+	//同一地址的其它币种不计入
+	if err := bs.SaveUnspent(&Unspent{Root: "r8", Height: 50, Value: "900", Address: "a1", TK: "tk", Currency: "ABC"}, []string{"r8_nil"}); err != nil {
+		t.Fatalf("SaveUnspent() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		address  string
+		decimals int32
+		height   uint64
+		want     CurrencyBalance
+	}{
+		{
+			name:     "confirmation split",
+			address:  "a1",
+			decimals: 2,
+			height:   100,
+			want:     CurrencyBalance{Total: "165", Confirmed: "137", Unconfirmed: "28", Locked: "37", Spendable: "102", UnspentCount: 7},
+		},
+		{
+			name:     "all confirmed",
+			address:  "a1",
+			decimals: 2,
+			height:   200,
+			want:     CurrencyBalance{Total: "165", Confirmed: "165", Unconfirmed: "0", Locked: "37", Spendable: "123", UnspentCount: 7},
+		},
+		{
+			name:     "low height",
+			address:  "a1",
+			decimals: 2,
+			height:   10,
+			want:     CurrencyBalance{Total: "165", Confirmed: "0", Unconfirmed: "165", Locked: "37", Spendable: "0", UnspentCount: 7},
+		},
+		{
+			name:     "unknown address",
+			address:  "a2",
+			decimals: 2,
+			height:   100,
+			want:     CurrencyBalance{Total: "0", Confirmed: "0", Unconfirmed: "0", Locked: "0", Spendable: "0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := wm.GetAddressBalance(tt.address, "SERO", tt.decimals, tt.height)
+			if err != nil {
+				t.Fatalf("GetAddressBalance() error = %v", err)
+			}
+			tt.want.Currency = "SERO"
+			tt.want.Decimals = tt.decimals
+			if *got != tt.want {
+				t.Errorf("GetAddressBalance() = %+v, want %+v", *got, tt.want)
+			}
+
+			//Balance为已确认和未确认余额之和
+			obj := got.Balance("SERO", tt.address)
+			if obj.Address != tt.address || obj.Balance != tt.want.Total || obj.ConfirmBalance != tt.want.Confirmed || obj.UnconfirmBalance != tt.want.Unconfirmed {
+				t.Errorf("Balance() = %+v, want balance %s, confirm %s, unconfirm %s", *obj, tt.want.Total, tt.want.Confirmed, tt.want.Unconfirmed)
+			}
+		})
+	}
+}
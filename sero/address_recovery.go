@@ -0,0 +1,255 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultAddressGap = 20 //连续未使用的地址数达到间隔时停止扫描
+)
+
+//AddressRecovery 地址恢复结果
+type AddressRecovery struct {
+	AccountID       string                `json:"accountID"`
+	Receives        []*openwallet.Address `json:"receives"`        //已使用的收款地址
+	Changes         []*openwallet.Address `json:"changes"`         //已使用的找零地址
+	NextIndex       uint64                `json:"nextIndex"`       //下一个收款地址索引
+	NextChangeIndex uint64                `json:"nextChangeIndex"` //下一个找零地址索引
+}
+
+//UsedAddress 扫块时收到过输出的地址，utxo花费后仍保留，用于地址恢复
+type UsedAddress struct {
+	Address   string `json:"address" storm:"id"`
+	AccountID string `json:"accountID" storm:"index"`
+	Height    uint64 `json:"height"` //第一次收到输出的区块高度
+}
+
+//RecoveredAddress 地址恢复找回的收款地址，钱包中查不到时用于签名
+type RecoveredAddress struct {
+	Address   string `json:"address" storm:"id"`
+	AccountID string `json:"accountID" storm:"index"`
+	Index     uint64 `json:"index"`
+	CreateAt  int64  `json:"createAt"`
+}
+
+//saveUsedAddress 在保存utxo的事务中记录收到输出的地址，已有记录时保留第一次的高度
+func saveUsedAddress(tx storm.Node, utxo *Unspent) error {
+
+	if len(utxo.Address) == 0 {
+		return nil
+	}
+
+	var exist UsedAddress
+	err := tx.One("Address", utxo.Address, &exist)
+	if err == nil {
+		return nil
+	}
+	if err != storm.ErrNotFound {
+		return err
+	}
+
+	return tx.Save(&UsedAddress{
+		Address:   utxo.Address,
+		AccountID: utxo.TK,
+		Height:    utxo.Height,
+	})
+}
+
+//GetRecoveredAddress 查询地址恢复找回的收款地址
+func (wm *WalletManager) GetRecoveredAddress(address string) (*RecoveredAddress, error) {
+	var recovered RecoveredAddress
+	err := wm.unspentDB.One("Address", address, &recovered)
+	if err != nil {
+		return nil, err
+	}
+	return &recovered, nil
+}
+
+//ListRecoveredAddresses 查询账户通过地址恢复找回的收款地址
+func (wm *WalletManager) ListRecoveredAddresses(accountID string) ([]*RecoveredAddress, error) {
+	var list []*RecoveredAddress
+	err := wm.unspentDB.Find("AccountID", accountID, &list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return list, nil
+}
+
+//usedAddresses 本地记录中账户使用过的地址：扫块收到过输出的地址、当前未花和质押收益
+func (wm *WalletManager) usedAddresses(tk string) (map[string]bool, error) {
+
+	used := make(map[string]bool)
+
+	var history []*UsedAddress
+	err := wm.unspentDB.Find("AccountID", tk, &history)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	for _, h := range history {
+		used[h.Address] = true
+	}
+
+	//升级前扫描的utxo没有使用记录
+	unspents, err := wm.ListAccountUnspent(tk)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range unspents {
+		used[u.Address] = true
+	}
+
+	rewards, err := wm.ListStakeRewards(tk)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rewards {
+		used[r.Address] = true
+	}
+
+	return used, nil
+}
+
+//scanAddressGap 从索引0开始派生地址，连续gap个地址未使用时停止，返回已使用的地址和下一个索引
+func (wm *WalletManager) scanAddressGap(account *openwallet.AssetsAccount, used map[string]bool, gap uint64, isChange bool) ([]*openwallet.Address, uint64, error) {
+
+	found := make([]*openwallet.Address, 0)
+	nextIndex := uint64(0)
+
+	for index, unused := uint64(0), uint64(0); unused < gap; index++ {
+
+		addr, err := wm.Decoder.CreateDerivedAddress(account, index, isChange)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if !used[addr.Address] {
+			unused++
+			continue
+		}
+
+		found = append(found, addr)
+		nextIndex = index + 1
+		unused = 0
+	}
+
+	return found, nextIndex, nil
+}
+
+//RecoverAddresses 按确定性派生的地址索引重新生成账户的地址，
+//与本地已扫描的记录比对，找回已使用的收款地址和找零地址，保存收款地址记录并补回找零地址记录
+func (wm *WalletManager) RecoverAddresses(account *openwallet.AssetsAccount, gap uint64) (*AddressRecovery, error) {
+
+	if account == nil || len(account.AccountID) == 0 || len(account.PublicKey) == 0 {
+		return nil, fmt.Errorf("account tk or public key is empty")
+	}
+
+	if gap == 0 {
+		gap = DefaultAddressGap
+	}
+
+	used, err := wm.usedAddresses(account.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	receives, nextIndex, err := wm.scanAddressGap(account, used, gap, false)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, nextChangeIndex, err := wm.scanAddressGap(account, used, gap, true)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range receives {
+		if _, findErr := wm.GetRecoveredAddress(r.Address); findErr == nil {
+			continue
+		}
+		err = wm.unspentDB.Save(&RecoveredAddress{
+			Address:   r.Address,
+			AccountID: account.AccountID,
+			Index:     r.Index,
+			CreateAt:  time.Now().Unix(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range changes {
+		if _, findErr := wm.GetChangeAddress(c.Address); findErr == nil {
+			continue
+		}
+		err = wm.SaveChangeAddress(&ChangeAddress{
+			Address:   c.Address,
+			AccountID: account.AccountID,
+			Index:     c.Index,
+			CreateAt:  time.Now().Unix(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &AddressRecovery{
+		AccountID:       account.AccountID,
+		Receives:        receives,
+		Changes:         changes,
+		NextIndex:       nextIndex,
+		NextChangeIndex: nextChangeIndex,
+	}, nil
+}
+
+//startupRecoverAddresses 启动时为配置的账户恢复地址，账户公钥从TK计算，
+//结果写入日志，运维按下一个地址索引在钱包中重新创建地址
+func (wm *WalletManager) startupRecoverAddresses() {
+
+	for _, tk := range strings.Split(wm.Config.RecoverAccounts, ",") {
+
+		tk = strings.TrimSpace(tk)
+		if len(tk) == 0 {
+			continue
+		}
+
+		pk, err := wm.LocalTk2Pk(tk)
+		if err != nil {
+			wm.Log.Warningf("recover addresses of account: %s failed, unexpected error: %v", tk, err)
+			continue
+		}
+
+		account := &openwallet.AssetsAccount{
+			AccountID: tk,
+			PublicKey: pk,
+			Symbol:    wm.Symbol(),
+		}
+
+		recovery, err := wm.RecoverAddresses(account, wm.Config.AddressGap)
+		if err != nil {
+			wm.Log.Warningf("recover addresses of account: %s failed, unexpected error: %v", tk, err)
+			continue
+		}
+
+		wm.Log.Infof("recover addresses of account: %s, receives: %d, changes: %d, next index: %d, next change index: %d",
+			tk, len(recovery.Receives), len(recovery.Changes), recovery.NextIndex, recovery.NextChangeIndex)
+	}
+}
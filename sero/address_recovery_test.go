@@ -0,0 +1,71 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"testing"
+)
+
+func TestWalletManager_usedAddresses(t *testing.T) {
+
+	wm, cleanup := testUnspentDBWalletManager(t)
+	defer cleanup()
+	bs := &SEROBlockScanner{wm: wm}
+
+	unspents := []*Unspent{
+		{Root: "r1", Height: 10, Address: "spent", TK: "tk", Currency: "SERO", Value: "1"},
+		{Root: "r2", Height: 20, Address: "unspent", TK: "tk", Currency: "SERO", Value: "1"},
+		{Root: "r3", Height: 30, Address: "spent", TK: "tk", Currency: "SERO", Value: "1"},
+		{Root: "r4", Height: 30, Address: "other", TK: "tk2", Currency: "SERO", Value: "1"},
+	}
+	for _, u := range unspents {
+		if err := bs.SaveUnspent(u, []string{u.Root + "_nil"}); err != nil {
+			t.Fatalf("SaveUnspent() error = %v", err)
+		}
+	}
+
+	//花费后地址仍记录为已使用
+	for _, nilKey := range []string{"r1_nil", "r3_nil"} {
+		if err := bs.DeleteUnspent(nilKey); err != nil {
+			t.Fatalf("DeleteUnspent() error = %v", err)
+		}
+	}
+
+	used, err := wm.usedAddresses("tk")
+	if err != nil {
+		t.Fatalf("usedAddresses() error = %v", err)
+	}
+
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{address: "spent", want: true},
+		{address: "unspent", want: true},
+		{address: "other", want: false},
+	}
+	for _, tt := range tests {
+		if used[tt.address] != tt.want {
+			t.Errorf("usedAddresses() %s = %v, want %v", tt.address, used[tt.address], tt.want)
+		}
+	}
+
+	//保留第一次收到输出的高度
+	var history UsedAddress
+	if err := wm.unspentDB.One("Address", "spent", &history); err != nil || history.Height != 10 {
+		t.Errorf("used address = %+v, error = %v, want height 10", history, err)
+	}
+}
@@ -0,0 +1,353 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"github.com/asdine/storm"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+const (
+	BalanceIndexAddress = "address" //按地址索引
+	BalanceIndexAccount = "account" //按账户TK索引
+)
+
+const (
+	BalanceIndexCheckNone    = "none"    //启动时不校验余额索引
+	BalanceIndexCheckVerify  = "verify"  //启动时校验余额索引，不一致时记录日志
+	BalanceIndexCheckRebuild = "rebuild" //启动时校验余额索引，不一致时重建
+)
+
+//BalanceIndex 余额索引，累计地址或账户一个币种的utxo金额，最小单位
+type BalanceIndex struct {
+	Key      string `json:"key" storm:"id"` //类型_所有者_币种
+	Kind     string `json:"kind"`
+	Owner    string `json:"owner" storm:"index"` //地址或TK
+	Currency string `json:"currency"`
+	Value    string `json:"value"`
+	Count    int    `json:"count"` //utxo数量
+}
+
+//BalanceIndexMismatch 索引与utxo统计不一致的记录
+type BalanceIndexMismatch struct {
+	Key          string `json:"key"`
+	Indexed      string `json:"indexed"`
+	Actual       string `json:"actual"`
+	IndexedCount int    `json:"indexedCount"`
+	ActualCount  int    `json:"actualCount"`
+}
+
+//BalanceIndexReport 余额索引的校验结果
+type BalanceIndexReport struct {
+	Unspents   int                     `json:"unspents"`
+	Indexes    int                     `json:"indexes"`
+	Mismatches []*BalanceIndexMismatch `json:"mismatches"`
+	Rebuilt    bool                    `json:"rebuilt"`
+}
+
+//balanceIndexKey 余额索引的主键
+func balanceIndexKey(kind, owner, currency string) string {
+	return kind + "_" + owner + "_" + currency
+}
+
+//unspentBalanceIndexes utxo计入的余额索引，只持有票据的utxo不计入
+func unspentBalanceIndexes(utxo *Unspent) []*BalanceIndex {
+
+	list := make([]*BalanceIndex, 0, 2)
+	if len(utxo.Currency) == 0 {
+		return list
+	}
+
+	if len(utxo.Address) > 0 {
+		list = append(list, &BalanceIndex{
+			Key:      balanceIndexKey(BalanceIndexAddress, utxo.Address, utxo.Currency),
+			Kind:     BalanceIndexAddress,
+			Owner:    utxo.Address,
+			Currency: utxo.Currency,
+		})
+	}
+
+	if len(utxo.TK) > 0 {
+		list = append(list, &BalanceIndex{
+			Key:      balanceIndexKey(BalanceIndexAccount, utxo.TK, utxo.Currency),
+			Kind:     BalanceIndexAccount,
+			Owner:    utxo.TK,
+			Currency: utxo.Currency,
+		})
+	}
+
+	return list
+}
+
+//updateBalanceIndex 在事务中增加或扣减utxo计入的余额索引，add为false时扣减
+func updateBalanceIndex(tx storm.Node, utxo *Unspent, add bool) error {
+
+	value, _ := decimal.NewFromString(utxo.Value)
+
+	for _, idx := range unspentBalanceIndexes(utxo) {
+
+		var exist BalanceIndex
+		err := tx.One("Key", idx.Key, &exist)
+		if err == nil {
+			idx = &exist
+		} else if err != storm.ErrNotFound {
+			return err
+		}
+
+		balance, _ := decimal.NewFromString(idx.Value)
+		if add {
+			balance = balance.Add(value)
+			idx.Count++
+		} else {
+			balance = balance.Sub(value)
+			idx.Count--
+		}
+		idx.Value = balance.String()
+
+		if idx.Count <= 0 {
+			if err == nil {
+				if delErr := tx.DeleteStruct(idx); delErr != nil {
+					return delErr
+				}
+			}
+			continue
+		}
+
+		if saveErr := tx.Save(idx); saveErr != nil {
+			return saveErr
+		}
+	}
+
+	return nil
+}
+
+//findBalanceIndex 查询余额索引，没有记录时返回余额为0的索引
+func (wm *WalletManager) findBalanceIndex(kind, owner, currency string) (*BalanceIndex, error) {
+
+	key := balanceIndexKey(kind, owner, currency)
+
+	var idx BalanceIndex
+	err := wm.unspentDB.One("Key", key, &idx)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return &BalanceIndex{Key: key, Kind: kind, Owner: owner, Currency: currency, Value: "0"}, nil
+		}
+		return nil, err
+	}
+
+	return &idx, nil
+}
+
+//GetIndexedBalance 查询余额索引，没有记录时余额为0，最小单位
+func (wm *WalletManager) GetIndexedBalance(kind, owner, currency string) (decimal.Decimal, error) {
+
+	idx, err := wm.findBalanceIndex(kind, owner, currency)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return decimal.NewFromString(idx.Value)
+}
+
+//VerifyBalanceIndex 按utxo重新统计余额，与索引比较
+func (wm *WalletManager) VerifyBalanceIndex() (*BalanceIndexReport, error) {
+	return wm.checkBalanceIndex(false)
+}
+
+//RebuildBalanceIndex 按utxo重新统计余额，与索引比较后重建索引
+func (wm *WalletManager) RebuildBalanceIndex() (*BalanceIndexReport, error) {
+	return wm.checkBalanceIndex(true)
+}
+
+//checkBalanceIndex 在同一个事务中统计utxo并比较索引，rebuild为true时重建
+func (wm *WalletManager) checkBalanceIndex(rebuild bool) (*BalanceIndexReport, error) {
+
+	tx, err := wm.unspentDB.Begin(rebuild)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var unspents []*Unspent
+	err = tx.All(&unspents)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	var indexes []*BalanceIndex
+	err = tx.All(&indexes)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	//按utxo统计的余额
+	actual := make(map[string]*BalanceIndex)
+	for _, u := range unspents {
+		value, _ := decimal.NewFromString(u.Value)
+		for _, idx := range unspentBalanceIndexes(u) {
+			sum := actual[idx.Key]
+			if sum == nil {
+				sum = idx
+				sum.Value = "0"
+				actual[idx.Key] = sum
+			}
+			balance, _ := decimal.NewFromString(sum.Value)
+			sum.Value = balance.Add(value).String()
+			sum.Count++
+		}
+	}
+
+	indexed := make(map[string]*BalanceIndex)
+	for _, idx := range indexes {
+		indexed[idx.Key] = idx
+	}
+
+	keys := make([]string, 0, len(actual)+len(indexed))
+	for key := range actual {
+		keys = append(keys, key)
+	}
+	for key := range indexed {
+		if _, ok := actual[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	report := &BalanceIndexReport{
+		Unspents:   len(unspents),
+		Indexes:    len(indexes),
+		Mismatches: make([]*BalanceIndexMismatch, 0),
+	}
+
+	for _, key := range keys {
+		mismatch := &BalanceIndexMismatch{Key: key, Indexed: "0", Actual: "0"}
+		if idx, ok := indexed[key]; ok {
+			mismatch.Indexed = idx.Value
+			mismatch.IndexedCount = idx.Count
+		}
+		if sum, ok := actual[key]; ok {
+			mismatch.Actual = sum.Value
+			mismatch.ActualCount = sum.Count
+		}
+		indexedValue, _ := decimal.NewFromString(mismatch.Indexed)
+		actualValue, _ := decimal.NewFromString(mismatch.Actual)
+		if !indexedValue.Equal(actualValue) || mismatch.IndexedCount != mismatch.ActualCount {
+			report.Mismatches = append(report.Mismatches, mismatch)
+		}
+	}
+
+	if !rebuild {
+		return report, nil
+	}
+
+	for _, idx := range indexes {
+		if err = tx.DeleteStruct(idx); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, key := range keys {
+		if sum, ok := actual[key]; ok {
+			if err = tx.Save(sum); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	report.Rebuilt = true
+
+	return report, nil
+}
+
+//startupCheckBalanceIndex 加载配置时按balanceIndexCheck校验余额索引，扫块的数据库在openw-server中，校验在加载适配器的服务中执行
+func (wm *WalletManager) startupCheckBalanceIndex() error {
+
+	var rebuild bool
+	switch wm.Config.BalanceIndexCheck {
+	case BalanceIndexCheckVerify:
+		rebuild = false
+	case BalanceIndexCheckRebuild:
+		rebuild = true
+	default:
+		return nil
+	}
+
+	report, err := wm.VerifyBalanceIndex()
+	if err != nil {
+		return err
+	}
+
+	if len(report.Mismatches) == 0 {
+		wm.Log.Infof("balance index verified, %d unspents, %d indexes", report.Unspents, report.Indexes)
+		return nil
+	}
+
+	for _, m := range report.Mismatches {
+		wm.Log.Warningf("balance index: %s mismatched, indexed: %s (%d utxo), actual: %s (%d utxo)",
+			m.Key, m.Indexed, m.IndexedCount, m.Actual, m.ActualCount)
+	}
+
+	if !rebuild {
+		return nil
+	}
+
+	report, err = wm.RebuildBalanceIndex()
+	if err != nil {
+		return err
+	}
+
+	wm.Log.Warningf("balance index rebuilt from %d unspents", report.Unspents)
+
+	return nil
+}
+
+//initBalanceIndex 已有utxo但没有余额索引时（旧版本数据库）建立索引
+func (wm *WalletManager) initBalanceIndex() error {
+
+	var idx BalanceIndex
+	err := wm.unspentDB.Select().First(&idx)
+	if err == nil {
+		return nil
+	}
+	if err != storm.ErrNotFound {
+		return err
+	}
+
+	var utxo Unspent
+	err = wm.unspentDB.Select().First(&utxo)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	report, err := wm.RebuildBalanceIndex()
+	if err != nil {
+		return err
+	}
+
+	wm.Log.Infof("balance index built from %d unspents", report.Unspents)
+
+	return nil
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"github.com/asdine/storm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//testUnspentDBWalletManager 使用临时未花数据库的钱包管理器
+func testUnspentDBWalletManager(t *testing.T) (*WalletManager, func()) {
+	dir, err := ioutil.TempDir("", "sero_unspent")
+	if err != nil {
+		t.Fatalf("create temp dir failed, unexpected error: %v", err)
+	}
+	db, err := storm.Open(filepath.Join(dir, "unspent.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("open unspent db failed, unexpected error: %v", err)
+	}
+	wm := NewWalletManager()
+	wm.unspentDB = db
+	return wm, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+//testIndexedBalance 查询余额索引和utxo数量
+func testIndexedBalance(t *testing.T, wm *WalletManager, kind, owner, currency string) (string, int) {
+	idx, err := wm.findBalanceIndex(kind, owner, currency)
+	if err != nil {
+		t.Fatalf("findBalanceIndex() error = %v", err)
+	}
+	return idx.Value, idx.Count
+}
+
+func TestBalanceIndex_AddSub(t *testing.T) {
+
+	wm, cleanup := testUnspentDBWalletManager(t)
+	defer cleanup()
+	bs := &SEROBlockScanner{wm: wm}
+
+	steps := []struct {
+		name      string
+		save      *Unspent
+		nilKey    string
+		deleteNil string
+		wantAddr  string
+		wantCount int
+		wantAcct  string
+		wantToken string
+	}{
+		{name: "add", save: &Unspent{Root: "r1", Address: "a1", TK: "tk", Currency: "SERO", Value: "100"}, nilKey: "n1", wantAddr: "100", wantCount: 1, wantAcct: "100", wantToken: "0"},
+		{name: "add same address", save: &Unspent{Root: "r2", Address: "a1", TK: "tk", Currency: "SERO", Value: "50"}, nilKey: "n2", wantAddr: "150", wantCount: 2, wantAcct: "150", wantToken: "0"},
+		{name: "rescan does not double count", save: &Unspent{Root: "r2", Address: "a1", TK: "tk", Currency: "SERO", Value: "50"}, nilKey: "n2", wantAddr: "150", wantCount: 2, wantAcct: "150", wantToken: "0"},
+		{name: "other currency", save: &Unspent{Root: "r3", Address: "a1", TK: "tk", Currency: "ABC", Value: "7"}, nilKey: "n3", wantAddr: "150", wantCount: 2, wantAcct: "150", wantToken: "7"},
+		{name: "other address", save: &Unspent{Root: "r4", Address: "a2", TK: "tk", Currency: "SERO", Value: "30"}, nilKey: "n4", wantAddr: "150", wantCount: 2, wantAcct: "180", wantToken: "7"},
+		{name: "sub", deleteNil: "n1", wantAddr: "50", wantCount: 1, wantAcct: "80", wantToken: "7"},
+		{name: "sub unknown nil", deleteNil: "unknown", wantAddr: "50", wantCount: 1, wantAcct: "80", wantToken: "7"},
+		{name: "sub last", deleteNil: "n2", wantAddr: "0", wantCount: 0, wantAcct: "30", wantToken: "7"},
+	}
+
+	for _, s := range steps {
+		if s.save != nil {
+			utxo := *s.save
+			if err := bs.SaveUnspent(&utxo, []string{s.nilKey}); err != nil {
+				t.Fatalf("%s: SaveUnspent() error = %v", s.name, err)
+			}
+		}
+		if len(s.deleteNil) > 0 {
+			if err := bs.DeleteUnspent(s.deleteNil); err != nil {
+				t.Fatalf("%s: DeleteUnspent() error = %v", s.name, err)
+			}
+		}
+		if value, count := testIndexedBalance(t, wm, BalanceIndexAddress, "a1", "SERO"); value != s.wantAddr || count != s.wantCount {
+			t.Errorf("%s: address index = %s (%d utxo), want %s (%d utxo)", s.name, value, count, s.wantAddr, s.wantCount)
+		}
+		if value, _ := testIndexedBalance(t, wm, BalanceIndexAccount, "tk", "SERO"); value != s.wantAcct {
+			t.Errorf("%s: account index = %s, want %s", s.name, value, s.wantAcct)
+		}
+		if value, _ := testIndexedBalance(t, wm, BalanceIndexAddress, "a1", "ABC"); value != s.wantToken {
+			t.Errorf("%s: token index = %s, want %s", s.name, value, s.wantToken)
+		}
+	}
+
+	//扣减到0的索引记录被删除
+	var idx BalanceIndex
+	if err := wm.unspentDB.One("Key", balanceIndexKey(BalanceIndexAddress, "a1", "SERO"), &idx); err != storm.ErrNotFound {
+		t.Errorf("empty address index is not deleted, error = %v", err)
+	}
+
+	report, err := wm.VerifyBalanceIndex()
+	if err != nil {
+		t.Fatalf("VerifyBalanceIndex() error = %v", err)
+	}
+	if len(report.Mismatches) != 0 {
+		t.Errorf("VerifyBalanceIndex() mismatches = %d, want 0", len(report.Mismatches))
+	}
+}
+
+func TestWalletManager_RebuildBalanceIndex(t *testing.T) {
+
+	wm, cleanup := testUnspentDBWalletManager(t)
+	defer cleanup()
+
+	//直接保存utxo，不更新索引
+	unspents := []*Unspent{
+		{Root: "r1", Address: "a1", TK: "tk", Currency: "SERO", Value: "100"},
+		{Root: "r2", Address: "a1", TK: "tk", Currency: "SERO", Value: "50"},
+		{Root: "r3", Address: "a2", TK: "tk", Currency: "ABC", Value: "7"},
+	}
+	for _, u := range unspents {
+		if err := wm.unspentDB.Save(u); err != nil {
+			t.Fatalf("save unspent failed, unexpected error: %v", err)
+		}
+	}
+
+	//没有utxo的过期索引
+	stale := &BalanceIndex{Key: balanceIndexKey(BalanceIndexAddress, "a3", "SERO"), Kind: BalanceIndexAddress, Owner: "a3", Currency: "SERO", Value: "9", Count: 1}
+	if err := wm.unspentDB.Save(stale); err != nil {
+		t.Fatalf("save balance index failed, unexpected error: %v", err)
+	}
+
+	report, err := wm.VerifyBalanceIndex()
+	if err != nil {
+		t.Fatalf("VerifyBalanceIndex() error = %v", err)
+	}
+	//a1 SERO，a2 ABC，tk SERO，tk ABC，a3 SERO
+	if report.Rebuilt || report.Unspents != 3 || report.Indexes != 1 || len(report.Mismatches) != 5 {
+		t.Fatalf("VerifyBalanceIndex() = %+v, want 5 mismatches without rebuilt", report)
+	}
+
+	report, err = wm.RebuildBalanceIndex()
+	if err != nil {
+		t.Fatalf("RebuildBalanceIndex() error = %v", err)
+	}
+	if !report.Rebuilt {
+		t.Errorf("RebuildBalanceIndex() rebuilt = false")
+	}
+
+	tests := []struct {
+		kind      string
+		owner     string
+		currency  string
+		want      string
+		wantCount int
+	}{
+		{kind: BalanceIndexAddress, owner: "a1", currency: "SERO", want: "150", wantCount: 2},
+		{kind: BalanceIndexAddress, owner: "a2", currency: "ABC", want: "7", wantCount: 1},
+		{kind: BalanceIndexAccount, owner: "tk", currency: "SERO", want: "150", wantCount: 2},
+		{kind: BalanceIndexAccount, owner: "tk", currency: "ABC", want: "7", wantCount: 1},
+		{kind: BalanceIndexAddress, owner: "a3", currency: "SERO", want: "0", wantCount: 0},
+	}
+	for _, tt := range tests {
+		if value, count := testIndexedBalance(t, wm, tt.kind, tt.owner, tt.currency); value != tt.want || count != tt.wantCount {
+			t.Errorf("index %s_%s_%s = %s (%d utxo), want %s (%d utxo)", tt.kind, tt.owner, tt.currency, value, count, tt.want, tt.wantCount)
+		}
+	}
+
+	report, err = wm.VerifyBalanceIndex()
+	if err != nil {
+		t.Fatalf("VerifyBalanceIndex() error = %v", err)
+	}
+	if len(report.Mismatches) != 0 {
+		t.Errorf("VerifyBalanceIndex() after rebuild mismatches = %d, want 0", len(report.Mismatches))
+	}
+}
@@ -0,0 +1,456 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/blocktree/openwallet/common"
+	"github.com/blocktree/openwallet/crypto"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+const (
+	batchPayoutExtKey = "batchPayout" //交易单ExtParam中记录批量支付信息的字段
+)
+
+//BatchPayout 批量支付中的一笔支付
+type BatchPayout struct {
+	Address string          `json:"address"` //收款码PKr
+	Coin    openwallet.Coin `json:"coin"`    //币种，代币需要提供合约信息
+	Amount  string          `json:"amount"`  //金额
+	Memo    string          `json:"memo"`    //备注
+}
+
+//BatchPayoutItem 批量支付中每笔支付的结果
+type BatchPayoutItem struct {
+	Index    int    `json:"index"`    //在支付列表中的顺序
+	Address  string `json:"address"`  //收款码PKr
+	Currency string `json:"currency"` //币种
+	Amount   string `json:"amount"`   //金额
+	Memo     string `json:"memo"`     //备注
+	TxIndex  int    `json:"txIndex"`  //所在交易单的顺序，失败为-1
+	Error    string `json:"error"`    //失败原因
+}
+
+//BatchPayoutReport 批量支付的汇总报告
+type BatchPayoutReport struct {
+	BatchID   string             `json:"batchID"`
+	TxCount   int                `json:"txCount"`   //交易单数量
+	Succeeded int                `json:"succeeded"` //成功组建的支付数量
+	Failed    int                `json:"failed"`    //失败的支付数量
+	Amounts   map[string]string  `json:"amounts"`   //各币种支付总额
+	Fees      string             `json:"fees"`      //手续费合计
+	Items     []*BatchPayoutItem `json:"items"`
+}
+
+//batchPayoutGroup 同一币种的支付
+type batchPayoutGroup struct {
+	coin     openwallet.Coin
+	currency string
+	decimals int32
+	items    []*BatchPayoutItem
+	payments []*splitPayment
+	memos    map[string]string //地址的备注，同一地址的支付备注必须一致
+}
+
+//CreateBatchPayoutRawTransaction 创建批量支付交易单。
+//支付按币种分组，保持原有顺序，每笔交易单的输出不超过MaxTxOutputs，输入不超过MaxTxInputs。
+//无法组建的支付记录在报告中，不影响其它支付。
+func (decoder *TransactionDecoder) CreateBatchPayoutRawTransaction(
+	wrapper openwallet.WalletDAI,
+	account *openwallet.AssetsAccount,
+	payouts []*BatchPayout,
+	feeRate string) ([]*openwallet.RawTransaction, *BatchPayoutReport, error) {
+
+	if account == nil {
+		return nil, nil, fmt.Errorf("account is nil")
+	}
+
+	if len(payouts) == 0 {
+		return nil, nil, fmt.Errorf("payouts is empty")
+	}
+
+	var (
+		rawTxArray = make([]*openwallet.RawTransaction, 0)
+		groups     = make([]*batchPayoutGroup, 0)
+		groupIndex = make(map[string]*batchPayoutGroup)
+		totalFees  = decimal.Zero
+		amounts    = make(map[string]decimal.Decimal)
+		report     = &BatchPayoutReport{
+			BatchID: common.Bytes2Hex(crypto.SHA256([]byte(fmt.Sprintf("%s_batch_%d", account.AccountID, time.Now().UnixNano())))),
+			Amounts: make(map[string]string),
+			Items:   make([]*BatchPayoutItem, 0),
+		}
+	)
+
+	//验证每笔支付，按币种分组
+	for i, p := range payouts {
+
+		item := &BatchPayoutItem{
+			Index:   i,
+			Address: p.Address,
+			Amount:  p.Amount,
+			Memo:    p.Memo,
+			TxIndex: -1,
+		}
+		report.Items = append(report.Items, item)
+
+		var (
+			currency string
+			decimals int32
+		)
+		if p.Coin.IsContract {
+			currency = p.Coin.Contract.Address
+			decimals = decoder.wm.CoinDecimals(p.Coin)
+		} else {
+			currency = decoder.wm.Symbol()
+			decimals = decoder.wm.Decimal()
+		}
+		item.Currency = currency
+
+		if err := validateBatchPayout(p); err != nil {
+			item.Error = err.Error()
+			continue
+		}
+
+		amount, _ := decimal.NewFromString(p.Amount)
+
+		group := groupIndex[currency]
+		if group == nil {
+			coin := p.Coin
+			coin.Symbol = decoder.wm.Symbol()
+			group = &batchPayoutGroup{
+				coin:     coin,
+				currency: currency,
+				decimals: decimals,
+				memos:    make(map[string]string),
+			}
+			groupIndex[currency] = group
+			groups = append(groups, group)
+		}
+
+		//同一地址的支付可能合并到一笔交易单中，备注必须一致
+		if memo, exist := group.memos[p.Address]; exist && memo != p.Memo {
+			item.Error = fmt.Sprintf("memo conflicts with other payment to address: %s", p.Address)
+			continue
+		}
+		group.memos[p.Address] = p.Memo
+
+		group.items = append(group.items, item)
+		group.payments = append(group.payments, &splitPayment{Address: p.Address, Amount: amount, Memo: p.Memo})
+	}
+
+	//获取当前最大高度
+	currentHeight, err := decoder.wm.GetBlockHeight()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mainUnspents, err := decoder.wm.ListUnspent(account.AccountID, decoder.wm.Symbol(), 0, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+	feeUnspents := decoder.availableUnspents(currentHeight, mainUnspents)
+
+	for _, group := range groups {
+
+		var available []*Unspent
+		if group.coin.IsContract {
+			unspents, listErr := decoder.wm.ListUnspent(account.AccountID, group.currency, 0, -1)
+			if listErr != nil {
+				decoder.releaseBatchBuiltTxs(rawTxArray)
+				return nil, nil, listErr
+			}
+			available = decoder.availableUnspents(currentHeight, unspents)
+		} else {
+			//SERO支付和手续费共用utxo
+			available = feeUnspents
+		}
+
+		template := &openwallet.RawTransaction{
+			Coin:    group.coin,
+			Account: account,
+			FeeRate: feeRate,
+		}
+
+		var chunks []*batchChunk
+		chunks, _, feeUnspents = decoder.planBatchGroup(template, group.decimals, available, feeUnspents, group.items, group.payments)
+
+		for _, chunk := range chunks {
+
+			selection := chunk.selection
+			planTx, buildErr := decoder.buildPlanRawTransaction(wrapper, template, group.currency, group.decimals,
+				selection.feesRate, selection.fees, selection.gas, selection.used, selection.feeUsed, chunk.payments)
+			if buildErr != nil {
+				decoder.releaseBatchBuiltTxs(rawTxArray)
+				return nil, nil, buildErr
+			}
+
+			txIndex := len(rawTxArray)
+			planTx.Sid = fmt.Sprintf("%s_%d", report.BatchID, txIndex)
+			indexes := make([]int, 0, len(chunk.items))
+			for i, item := range chunk.items {
+				item.TxIndex = txIndex
+				indexes = append(indexes, item.Index)
+				amounts[group.currency] = amounts[group.currency].Add(chunk.payments[i].Amount)
+			}
+			extErr := planTx.SetExtParam(batchPayoutExtKey, map[string]interface{}{
+				"batchID": report.BatchID,
+				"txIndex": txIndex,
+				"indexes": indexes,
+			})
+			if extErr != nil {
+				decoder.releaseBatchBuiltTxs(rawTxArray)
+				return nil, nil, extErr
+			}
+
+			//按Sid记录交易单，预留输入utxo，重复创建时返回原交易单
+			_, saveErr := decoder.wm.SaveBuiltTx(planTx)
+			if saveErr == nil {
+				saveErr = decoder.wm.SaveRawTxExt(planTx)
+			}
+			if saveErr != nil {
+				decoder.releaseBatchBuiltTxs(rawTxArray)
+				return nil, nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "save transaction of sid: %s failed, %v", planTx.Sid, saveErr)
+			}
+
+			rawTxArray = append(rawTxArray, planTx)
+			totalFees = totalFees.Add(selection.fees)
+		}
+	}
+
+	for _, item := range report.Items {
+		if item.TxIndex >= 0 {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+	for currency, amount := range amounts {
+		report.Amounts[currency] = amount.String()
+	}
+	report.TxCount = len(rawTxArray)
+	report.Fees = totalFees.String()
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("Batch Payout: %s", report.BatchID)
+	decoder.wm.Log.Std.Notice("From Account: %s", account.AccountID)
+	decoder.wm.Log.Std.Notice("Transactions: %d", report.TxCount)
+	decoder.wm.Log.Std.Notice("Succeeded: %d", report.Succeeded)
+	decoder.wm.Log.Std.Notice("Failed: %d", report.Failed)
+	decoder.wm.Log.Std.Notice("Fees: %s", report.Fees)
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	return rawTxArray, report, nil
+}
+
+//batchChunk 一笔批量支付交易单包含的支付和选择的utxo
+type batchChunk struct {
+	items     []*BatchPayoutItem
+	payments  []*splitPayment
+	selection *batchSelection
+}
+
+//planBatchGroup 把同一币种的支付拆分为多笔交易单，每笔输出不超过MaxTxOutputs，输入不超过MaxTxInputs。
+//无法组建的支付记录失败原因后跳过，返回剩余可用的utxo
+func (decoder *TransactionDecoder) planBatchGroup(
+	template *openwallet.RawTransaction,
+	decimals int32,
+	available, feeUnspents []*Unspent,
+	items []*BatchPayoutItem,
+	payments []*splitPayment) ([]*batchChunk, []*Unspent, []*Unspent) {
+
+	chunks := make([]*batchChunk, 0)
+	isContract := template.Coin.IsContract
+
+	for len(payments) > 0 {
+
+		n := len(payments)
+		if n > MaxTxOutputs-2 {
+			//预留找零输出
+			n = MaxTxOutputs - 2
+		}
+
+		for n > 0 {
+
+			selection, selectErr := decoder.selectBatchUnspents(template, decimals, available, feeUnspents, payments[:n])
+			if selectErr != nil {
+				if n > 1 {
+					//减少支付数量再试
+					n = n / 2
+					continue
+				}
+				//单笔支付也无法组建，记录失败，继续后面的支付
+				items[0].Error = selectErr.Error()
+				items = items[1:]
+				payments = payments[1:]
+				break
+			}
+
+			chunks = append(chunks, &batchChunk{
+				items:     items[:n],
+				payments:  payments[:n],
+				selection: selection,
+			})
+
+			//移除已使用的utxo
+			available = available[len(selection.used):]
+			if isContract {
+				feeUnspents = feeUnspents[len(selection.feeUsed):]
+			} else {
+				//SERO支付和手续费共用utxo
+				feeUnspents = available
+			}
+
+			items = items[n:]
+			payments = payments[n:]
+			break
+		}
+	}
+
+	return chunks, available, feeUnspents
+}
+
+//releaseBatchBuiltTxs 批量支付创建失败，释放已记录的交易单预留的utxo
+func (decoder *TransactionDecoder) releaseBatchBuiltTxs(rawTxArray []*openwallet.RawTransaction) {
+	for _, rawTx := range rawTxArray {
+		if err := decoder.wm.ReleaseBuiltTx(rawTx.Sid); err != nil {
+			decoder.wm.Log.Warningf("release built transaction sid: %s failed, unexpected error: %v", rawTx.Sid, err)
+		}
+	}
+}
+
+//validateBatchPayout 验证支付的地址、金额和备注
+func validateBatchPayout(p *BatchPayout) error {
+
+	if err := validatePKr(p.Address); err != nil {
+		return openwallet.Errorf(openwallet.ErrAdressDecodeFailed, "address: %s is not a valid PKr, %v", p.Address, err)
+	}
+
+	amount, err := decimal.NewFromString(p.Amount)
+	if err != nil || amount.LessThanOrEqual(decimal.Zero) {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid amount: %s to address: %s", p.Amount, p.Address)
+	}
+
+	if p.Coin.IsContract && len(p.Coin.Contract.Address) == 0 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "contract address is empty")
+	}
+
+	if err := ValidateMemo(p.Memo); err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "address: %s memo is invalid, %v", p.Address, err)
+	}
+
+	return nil
+}
+
+//batchSelection 一笔批量支付交易单选择的utxo和手续费
+type batchSelection struct {
+	used     []*Unspent
+	feeUsed  []*Unspent
+	fees     decimal.Decimal
+	feesRate decimal.Decimal
+	gas      int64
+}
+
+//selectBatchUnspents 为一组支付选择utxo，按输入数量预估手续费，输入增加时重新预估。
+//available和feeUnspents按金额从大到小排序，只从前面取，不修改原列表。
+func (decoder *TransactionDecoder) selectBatchUnspents(
+	template *openwallet.RawTransaction,
+	decimals int32,
+	available, feeUnspents []*Unspent,
+	payments []*splitPayment) (*batchSelection, error) {
+
+	var (
+		totalSend  = decimal.Zero
+		ins        = 1
+		outs       = len(payments) + 1
+		currencies = 1
+		isContract = template.Coin.IsContract
+	)
+
+	for _, p := range payments {
+		totalSend = totalSend.Add(p.Amount)
+	}
+
+	if isContract {
+		ins = ins + 1
+		outs = outs + 1
+		currencies = currencies + 1
+	}
+
+	feesRate, _ := decimal.NewFromString(template.FeeRate)
+
+	for {
+
+		fees, rate, gas, err := decoder.wm.EstimateTxFee(feesRate, NewTxGasParam(ins, outs, currencies))
+		if err != nil {
+			return nil, err
+		}
+
+		selection := &batchSelection{
+			fees:     fees,
+			feesRate: rate,
+			gas:      gas,
+		}
+
+		need := totalSend
+		if !isContract {
+			need = need.Add(fees)
+		}
+
+		selection.used, err = pickUnspents(available, need, decimals)
+		if err != nil {
+			return nil, err
+		}
+
+		if isContract {
+			selection.feeUsed, _, err = decoder.takeUnspentsSatisfyAmount(feeUnspents, fees, decoder.wm.Decimal())
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		total := len(selection.used) + len(selection.feeUsed)
+		if total > MaxTxInputs {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "The transaction is use max inputs over: %d", MaxTxInputs)
+		}
+
+		if total <= ins {
+			return selection, nil
+		}
+		ins = total
+	}
+}
+
+//pickUnspents 从列表前面取出满足金额的utxo，不超过MaxTxInputs
+func pickUnspents(unspents []*Unspent, amount decimal.Decimal, decimals int32) ([]*Unspent, error) {
+
+	total := decimal.Zero
+	for i, u := range unspents {
+		if i >= MaxTxInputs {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "The transaction is use max inputs over: %d", MaxTxInputs)
+		}
+		ua, _ := decimal.NewFromString(u.Value)
+		total = total.Add(ua.Shift(-decimals))
+		if total.GreaterThanOrEqual(amount) {
+			return unspents[:i+1], nil
+		}
+	}
+
+	return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The balance: %s is not enough to pay: %s", total.String(), amount.String())
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestTransactionDecoder_planBatchGroup(t *testing.T) {
+
+	decoder := NewTransactionDecoder(testGasWalletManager(GasEstimateModeFixed, 25000, 0, "0"))
+
+	repeat := func(amount string, count int) []string {
+		list := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			list = append(list, amount)
+		}
+		return list
+	}
+
+	tests := []struct {
+		name       string
+		available  int
+		feeInputs  int
+		isContract bool
+		payments   []string
+		wantChunks int
+		wantFailed []int
+	}{
+		{name: "single chunk", available: 50, payments: repeat("1", 10), wantChunks: 1},
+		{name: "over max outputs", available: 200, payments: repeat("1", 120), wantChunks: 3},
+		{name: "over max inputs", available: 450, payments: repeat("150", 3), wantChunks: 2, wantFailed: []int{2}},
+		{name: "insufficient payment skipped", available: 10, payments: []string{"1", "20", "1"}, wantChunks: 2, wantFailed: []int{1}},
+		{name: "token", available: 100, feeInputs: 5, isContract: true, payments: repeat("1", 60), wantChunks: 2},
+		{name: "token without fee", available: 100, isContract: true, payments: repeat("1", 3), wantChunks: 0, wantFailed: []int{0, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			template := &openwallet.RawTransaction{
+				Coin:    openwallet.Coin{IsContract: tt.isContract},
+				FeeRate: "0.000000001",
+			}
+			available := testSplitUnspents("coin", tt.available, "1000")
+			feeUnspents := testSplitUnspents("fee", tt.feeInputs, "1000000000000000000")
+			if !tt.isContract {
+				feeUnspents = available
+			}
+
+			items := make([]*BatchPayoutItem, 0)
+			payments := make([]*splitPayment, 0)
+			for i, amount := range tt.payments {
+				value, _ := decimal.NewFromString(amount)
+				addr := fmt.Sprintf("to_%d", i)
+				items = append(items, &BatchPayoutItem{Index: i, Address: addr, Amount: amount, TxIndex: -1})
+				payments = append(payments, &splitPayment{Address: addr, Amount: value})
+			}
+
+			chunks, _, _ := decoder.planBatchGroup(template, 3, available, feeUnspents, items, payments)
+			if len(chunks) != tt.wantChunks {
+				t.Fatalf("planBatchGroup() chunks = %d, want %d", len(chunks), tt.wantChunks)
+			}
+
+			used := make(map[string]bool)
+			planned := make([]int, 0)
+			for i, chunk := range chunks {
+				if len(chunk.payments) > MaxTxOutputs-2 {
+					t.Errorf("chunk %d outputs = %d, over max outputs", i, len(chunk.payments))
+				}
+				ins := len(chunk.selection.used) + len(chunk.selection.feeUsed)
+				if ins > MaxTxInputs {
+					t.Errorf("chunk %d inputs = %d, over max inputs", i, ins)
+				}
+				if tt.isContract && len(chunk.selection.feeUsed) == 0 {
+					t.Errorf("chunk %d has no fee inputs", i)
+				}
+				inputs := make([]*Unspent, 0, ins)
+				inputs = append(inputs, chunk.selection.used...)
+				inputs = append(inputs, chunk.selection.feeUsed...)
+				for _, u := range inputs {
+					if used[u.Root] {
+						t.Errorf("chunk %d reuses utxo: %s", i, u.Root)
+					}
+					used[u.Root] = true
+				}
+				for j, item := range chunk.items {
+					if chunk.payments[j].Address != item.Address {
+						t.Errorf("chunk %d payment %d does not match its item", i, j)
+					}
+					planned = append(planned, item.Index)
+				}
+			}
+
+			//成功的支付保持原有顺序
+			for i := 1; i < len(planned); i++ {
+				if planned[i] <= planned[i-1] {
+					t.Errorf("planBatchGroup() order = %v", planned)
+					break
+				}
+			}
+
+			failed := make([]int, 0)
+			for _, item := range items {
+				if len(item.Error) > 0 {
+					failed = append(failed, item.Index)
+				}
+			}
+			if fmt.Sprint(failed) != fmt.Sprint(tt.wantFailed) {
+				t.Errorf("planBatchGroup() failed = %v, want %v", failed, tt.wantFailed)
+			}
+			if len(planned)+len(failed) != len(tt.payments) {
+				t.Errorf("planBatchGroup() planned %d and failed %d, want %d payments", len(planned), len(failed), len(tt.payments))
+			}
+		})
+	}
+}
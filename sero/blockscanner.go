@@ -17,7 +17,6 @@ package sero
 
 import (
 	"fmt"
-	"github.com/blocktree/openwallet/log"
 	"github.com/blocktree/openwallet/openwallet"
 	"github.com/mr-tron/base58"
 	"github.com/sero-cash/go-sero/common/hexutil"
@@ -154,6 +153,8 @@ func (bs *SEROBlockScanner) ScanBlockTask() {
 			bs.DeleteUnscanRecord(currentHeight - 1)
 			//删除上一区块的未花记录
 			bs.DeleteUnspentByHeight(currentHeight - 1)
+			//删除上一区块的质押奖励记录
+			bs.DeleteStakeRewardByHeight(currentHeight - 1)
 			currentHeight = currentHeight - 2 //倒退2个区块重新扫描
 			if currentHeight <= 0 {
 				currentHeight = 1
@@ -209,6 +210,11 @@ func (bs *SEROBlockScanner) ScanBlockTask() {
 
 	//重扫失败区块
 	bs.RescanFailedRecord()
+
+	//跟踪已提交交易的状态
+	if err := bs.wm.TxTracker.CheckTxs(); err != nil {
+		bs.wm.Log.Std.Error("block scanner check pending transactions failed; unexpected error: %v", err)
+	}
 }
 
 //newBlockNotify 获得新区块后，通知给观测者
@@ -225,13 +231,9 @@ func (bs *SEROBlockScanner) BatchExtractTransactions(block *BlockData) error {
 		quit       = make(chan struct{})
 		done       = 0 //完成标记
 		failed     = 0
-		shouldDone = len(block.transactions) //需要完成的总数
+		shouldDone = 0 //需要完成的总数
 	)
 
-	if len(block.transactions) == 0 {
-		return nil
-	}
-
 	//查询该高度的utxo和作废码信息
 	blockInfo, err := bs.wm.GetBlocksInfo(block.BlockNumber)
 	if err != nil {
@@ -240,6 +242,10 @@ func (bs *SEROBlockScanner) BatchExtractTransactions(block *BlockData) error {
 
 	block.blockInfo = blockInfo
 
+	//没有交易的输出为质押奖励
+	rewardTxIDs := block.GetRewardTxIDs()
+	shouldDone = len(block.transactions) + len(rewardTxIDs)
+
 	//先作废已使用的utxo
 	for _, nilKey := range block.blockInfo.Nils {
 		nilErr := bs.DeleteUnspent(nilKey)
@@ -248,7 +254,11 @@ func (bs *SEROBlockScanner) BatchExtractTransactions(block *BlockData) error {
 		}
 	}
 
-	bs.wm.Log.Std.Info("block scanner ready extract transactions total: %d ", len(block.transactions))
+	if shouldDone == 0 {
+		return nil
+	}
+
+	bs.wm.Log.Std.Info("block scanner ready extract transactions total: %d, rewards total: %d ", len(block.transactions), len(rewardTxIDs))
 
 	//生产通道
 	producer := make(chan ExtractResult)
@@ -297,6 +307,17 @@ func (bs *SEROBlockScanner) BatchExtractTransactions(block *BlockData) error {
 
 			}(eblock, txid, bs.extractingCH, eProducer)
 		}
+		for _, txid := range rewardTxIDs {
+			bs.extractingCH <- struct{}{}
+			go func(mblock *BlockData, mTxid string, end chan struct{}, mProducer chan<- ExtractResult) {
+
+				//导出提出的质押奖励
+				mProducer <- bs.ExtractRewardTransaction(mblock, mTxid, bs.ScanTargetFunc)
+				//释放
+				<-end
+
+			}(eblock, txid, bs.extractingCH, eProducer)
+		}
 	}
 
 	/*	开启导出的线程	*/
@@ -404,6 +425,8 @@ func (bs *SEROBlockScanner) extractTransaction(block *BlockData, trx *gjson.Resu
 				to     = make([]string, 0)
 				txType = uint64(0)
 				coin   openwallet.Coin
+				memos  = make(map[string]string)
+				memo   = ""
 			)
 			for _, output := range extractOutput {
 				if isTokenTrasfer && token == bs.wm.Symbol() {
@@ -412,6 +435,12 @@ func (bs *SEROBlockScanner) extractTransaction(block *BlockData, trx *gjson.Resu
 				}
 				coin = output.Coin
 				to = append(to, output.Address+":"+output.Amount)
+				if output.IsMemo {
+					memos[output.Address] = output.Memo
+					if len(memo) == 0 {
+						memo = output.Memo
+					}
+				}
 			}
 
 			tx := &openwallet.Transaction{
@@ -427,6 +456,11 @@ func (bs *SEROBlockScanner) extractTransaction(block *BlockData, trx *gjson.Resu
 				Status:      openwallet.TxStatusSuccess,
 				TxType:      txType,
 			}
+			if len(memos) > 0 {
+				tx.IsMemo = true
+				tx.Memo = memo
+				tx.SetExtParam(memoExtKey, memos)
+			}
 			wxID := openwallet.GenTransactionWxID(tx)
 			tx.WxID = wxID
 
@@ -519,6 +553,25 @@ func (bs *SEROBlockScanner) extractTransaction(block *BlockData, trx *gjson.Resu
 		}
 	}
 
+	//质押交易记录指令
+	if action := parseStakeAction(trx, bs.wm.Decimal()); action != nil {
+		for _, sourceKeyExtractData := range result.extractData {
+			for _, extractData := range sourceKeyExtractData {
+				extractData.Transaction.SetExtParam(stakeExtKey, action)
+			}
+		}
+	}
+
+	//合约调用交易记录调用的合约和数据
+	if call := parseContractCall(trx); call != nil {
+		call.To = hexToBase58(call.To)
+		for _, sourceKeyExtractData := range result.extractData {
+			for _, extractData := range sourceKeyExtractData {
+				extractData.Transaction.SetExtParam(contractCallExtKey, call)
+			}
+		}
+	}
+
 	result.Success = success
 }
 
@@ -539,10 +592,7 @@ func (bs *SEROBlockScanner) extractTxInput(block *BlockData, trx *gjson.Result,
 
 	hex, _ := hexutil.Decode(trx.Get("Tx.From").String())
 	address := base58.Encode(hex)
-	sourceKey, ok := scanTargetFunc(openwallet.ScanTarget{
-		Address:          address,
-		Symbol:           bs.wm.Symbol(),
-		BalanceModelType: openwallet.BalanceModelTypeAddress})
+	sourceKey, _, ok := bs.scanTarget(address, scanTargetFunc)
 	if ok {
 		bs.wm.Log.Infof("scanTargetFunc found: %s", sourceKey)
 		//组装一个SERO手续费作为输入
@@ -585,12 +635,12 @@ func (bs *SEROBlockScanner) extractTxInput(block *BlockData, trx *gjson.Result,
 					bs.wm.Log.Debugf("txDB Address: %s", txDB.Coin.Contract.Address)
 					bs.wm.Log.Debugf("txDB ContractID: %s", txDB.Coin.Contract.ContractID)
 					currency := ""
+					inputDecimals := int32(0)
 					if txDB.Coin.IsContract {
+						//交易单记录的代币输入为最小单位，按登记的精度计算
 						currency = txDB.Coin.Contract.Address
-						contractID := openwallet.GenContractID(txDB.Coin.Symbol, txDB.Coin.Contract.Address)
-						txDB.Coin.Contract.ContractID = contractID
-						txDB.Coin.ContractID = contractID
-						txDB.Coin.IsContract = true
+						txDB.Coin = bs.wm.TokenCoin(currency)
+						inputDecimals = int32(txDB.Coin.Contract.Decimals)
 						isTokenTrasfer = true
 					} else {
 						currency = txDB.Coin.Symbol
@@ -603,7 +653,8 @@ func (bs *SEROBlockScanner) extractTxInput(block *BlockData, trx *gjson.Result,
 							input := openwallet.TxInput{}
 							input.Coin = txDB.Coin
 							input.TxID = txDB.TxID
-							input.Amount = fv[1]
+							inputAmount, _ := decimal.NewFromString(fv[1])
+							input.Amount = inputAmount.Shift(-inputDecimals).String()
 							input.Address = fv[0]
 							input.Index = index
 							input.Sid = openwallet.GenTxOutPutSID(txid, bs.wm.Symbol(), txDB.Coin.ContractID, index)
@@ -668,10 +719,15 @@ func (bs *SEROBlockScanner) extractTxOutput(block *BlockData, trx *gjson.Result,
 			continue
 		}
 
-		sourceKey, ok := scanTargetFunc(openwallet.ScanTarget{
-			Address:          address,
-			Symbol:           bs.wm.Symbol(),
-			BalanceModelType: openwallet.BalanceModelTypeAddress})
+		sourceKey, isChange, ok := bs.scanTarget(address, scanTargetFunc)
+		if !ok {
+			//观察账户按派生的地址识别
+			sourceKey, isChange, ok = bs.scanWatchAccounts(address)
+		}
+		if !ok {
+			//观察账户其它收款码的匿名输出按TK解密识别
+			sourceKey, ok = bs.scanWatchAccountsByTK(out)
+		}
 		if ok {
 			bs.wm.Log.Infof("scanTargetFunc found: %s", sourceKey)
 			tkBytes, err := base58.Decode(sourceKey)
@@ -689,67 +745,93 @@ func (bs *SEROBlockScanner) extractTxOutput(block *BlockData, trx *gjson.Result,
 				return nil, isTokenTrasfer, fmt.Errorf("decode output failed")
 			}
 
-			currency, err := bs.wm.LocalIdToCurrency(tdOut.Asset.Tkn.Currency)
-			if err != nil {
-				return nil, isTokenTrasfer, err
+			//新增utxo
+			utxo := &Unspent{
+				Height:  block.BlockNumber,
+				Root:    out.Root,
+				Address: address,
+				TK:      sourceKey,
 			}
 
-			amount, _ := decimal.NewFromString(tdOut.Asset.Tkn.Value)
-			value := amount
-			outPut := openwallet.TxOutPut{}
-			contractId := openwallet.GenContractID(bs.wm.Symbol(), currency)
-			//资产为主链币，计算精度
-			if currency == bs.wm.Symbol() {
-				amount = amount.Shift(-bs.wm.Decimal())
-				outPut.Coin = openwallet.Coin{
-					Symbol:     bs.wm.Symbol(),
-					IsContract: false,
+			//同质化通证
+			if tdOut.Asset.Tkn != nil {
+				currency, err := bs.wm.LocalIdToCurrency(tdOut.Asset.Tkn.Currency)
+				if err != nil {
+					return nil, isTokenTrasfer, err
 				}
-			} else {
-				isTokenTrasfer = true
-				outPut.Coin = openwallet.Coin{
-					Symbol:     bs.wm.Symbol(),
-					IsContract: true,
-					ContractID: contractId,
-					Contract: openwallet.SmartContract{
-						ContractID: contractId,
-						Address:    currency,
+
+				amount, _ := decimal.NewFromString(tdOut.Asset.Tkn.Value)
+				value := amount
+				outPut := openwallet.TxOutPut{}
+				contractId := openwallet.GenContractID(bs.wm.Symbol(), currency)
+				//资产为主链币，计算精度
+				if currency == bs.wm.Symbol() {
+					amount = amount.Shift(-bs.wm.Decimal())
+					outPut.Coin = openwallet.Coin{
 						Symbol:     bs.wm.Symbol(),
-					},
+						IsContract: false,
+					}
+				} else {
+					//代币按登记的精度计算
+					isTokenTrasfer = true
+					outPut.Coin = bs.wm.TokenCoin(currency)
+					amount = amount.Shift(-int32(outPut.Coin.Contract.Decimals))
 				}
-			}
-			outPut.TxID = txid
-			outPut.Amount = amount.String()
-			outPut.Address = address
-			outPut.Index = uint64(i)
-			outPut.Sid = openwallet.GenTxOutPutSID(txid, bs.wm.Symbol(), contractId, uint64(i))
-			outPut.CreateAt = createAt
-			outPut.BlockHeight = block.BlockNumber
-			outPut.BlockHash = block.BlockHash
-
-			sourceKeyExtractOutput := tokenExtractOutput[currency]
-			if sourceKeyExtractOutput == nil {
-				sourceKeyExtractOutput = make(ExtractOutput)
-			}
+				outPut.TxID = txid
+				outPut.Amount = amount.String()
+				outPut.Address = address
+				outPut.Index = uint64(i)
+				outPut.Sid = openwallet.GenTxOutPutSID(txid, bs.wm.Symbol(), contractId, uint64(i))
+				outPut.CreateAt = createAt
+				outPut.BlockHeight = block.BlockNumber
+				outPut.BlockHash = block.BlockHash
+				if isChange {
+					outPut.SetExtParam("isChange", true)
+				}
+				if memo := DecodeMemo(tdOut.Memo); len(memo) > 0 {
+					outPut.IsMemo = true
+					outPut.Memo = memo
+					outPut.SetExtParam(memoExtKey, memo)
+				}
+
+				appendExtractOutput(tokenExtractOutput, currency, sourceKey, &outPut)
 
-			extractOutput := sourceKeyExtractOutput[sourceKey]
-			if extractOutput == nil {
-				extractOutput = make([]*openwallet.TxOutPut, 0)
+				utxo.Currency = currency
+				utxo.Value = value.String()
 			}
 
-			extractOutput = append(extractOutput, &outPut)
+			//票据
+			if tdOut.Asset.Tkt != nil {
+				category, err := bs.wm.LocalIdToCurrency(tdOut.Asset.Tkt.Category)
+				if err != nil {
+					return nil, isTokenTrasfer, err
+				}
+
+				isTokenTrasfer = true
+				outPut := openwallet.TxOutPut{}
+				outPut.Coin = NewTicketCoin(bs.wm.Symbol(), category)
+				outPut.TxID = txid
+				outPut.Amount = "1"
+				outPut.Address = address
+				outPut.Index = uint64(i)
+				outPut.Sid = openwallet.GenTxOutPutSID(txid, bs.wm.Symbol(), outPut.Coin.ContractID, uint64(i))
+				outPut.CreateAt = createAt
+				outPut.BlockHeight = block.BlockNumber
+				outPut.BlockHash = block.BlockHash
+				outPut.SetExtParam(ticketExtKey, tdOut.Asset.Tkt.Value)
+				if isChange {
+					outPut.SetExtParam("isChange", true)
+				}
+				if memo := DecodeMemo(tdOut.Memo); len(memo) > 0 {
+					outPut.IsMemo = true
+					outPut.Memo = memo
+					outPut.SetExtParam(memoExtKey, memo)
+				}
 
-			sourceKeyExtractOutput[sourceKey] = extractOutput
-			tokenExtractOutput[currency] = sourceKeyExtractOutput
+				appendExtractOutput(tokenExtractOutput, ticketExtractKey(category), sourceKey, &outPut)
 
-			//新增utxo
-			utxo := &Unspent{
-				Height:   block.BlockNumber,
-				Root:     out.Root,
-				Address:  address,
-				TK:       sourceKey,
-				Currency: currency,
-				Value:    value.String(),
+				utxo.Category = category
+				utxo.Ticket = strings.ToLower(tdOut.Asset.Tkt.Value)
 			}
 
 			//保存新的utxo记录
@@ -764,6 +846,43 @@ func (bs *SEROBlockScanner) extractTxOutput(block *BlockData, trx *gjson.Result,
 	return tokenExtractOutput, isTokenTrasfer, nil
 }
 
+//appendExtractOutput 按币种和账户归集提取的输出
+func appendExtractOutput(tokenExtractOutput map[string]ExtractOutput, token, sourceKey string, outPut *openwallet.TxOutPut) {
+
+	sourceKeyExtractOutput := tokenExtractOutput[token]
+	if sourceKeyExtractOutput == nil {
+		sourceKeyExtractOutput = make(ExtractOutput)
+	}
+
+	extractOutput := sourceKeyExtractOutput[sourceKey]
+	if extractOutput == nil {
+		extractOutput = make([]*openwallet.TxOutPut, 0)
+	}
+
+	extractOutput = append(extractOutput, outPut)
+
+	sourceKeyExtractOutput[sourceKey] = extractOutput
+	tokenExtractOutput[token] = sourceKeyExtractOutput
+}
+
+//scanTarget 查找地址所属的账户，找零地址不在钱包地址库时，通过本地的找零地址记录查找
+func (bs *SEROBlockScanner) scanTarget(address string, scanTargetFunc openwallet.BlockScanTargetFunc) (string, bool, bool) {
+
+	change, _ := bs.wm.GetChangeAddress(address)
+	isChange := change != nil
+
+	sourceKey, ok := scanTargetFunc(openwallet.ScanTarget{
+		Address:          address,
+		Symbol:           bs.wm.Symbol(),
+		BalanceModelType: openwallet.BalanceModelTypeAddress})
+	if !ok && isChange {
+		sourceKey = change.AccountID
+		ok = true
+	}
+
+	return sourceKey, isChange, ok
+}
+
 //newExtractDataNotify 发送通知
 func (bs *SEROBlockScanner) newExtractDataNotify(height uint64, tokenExtractData map[string]ExtractData) error {
 
@@ -868,28 +987,15 @@ func (bs *SEROBlockScanner) GetBalanceByAddress(address ...string) ([]*openwalle
 
 	addrBalanceArr := make([]*openwallet.Balance, 0)
 
+	//按同一高度计算所有地址的确认数
+	height := bs.wm.balanceHeight()
+
 	for _, addr := range address {
-		//log.Infof("GetTokenBalanceByAddress: %s", addr)
-		utxo, err := bs.wm.ListUnspentByAddress(addr, bs.wm.Symbol(), 0, -1)
+		b, err := bs.wm.GetAddressBalance(addr, bs.wm.Symbol(), bs.wm.Decimal(), height)
 		if err != nil {
-			log.Debugf("ListUnspentByAddress failed, err: %v", err)
-			//return nil, nil
+			return nil, fmt.Errorf("get address: %s balance failed, %v", addr, err)
 		}
-
-		obj := &openwallet.Balance{}
-		tb := decimal.Zero
-		for _, u := range utxo {
-
-			b, _ := decimal.NewFromString(u.Value)
-			tb = tb.Add(b.Shift(-bs.wm.Decimal()))
-
-		}
-
-		obj.Symbol = bs.wm.Symbol()
-		obj.Address = addr
-		obj.ConfirmBalance = tb.String()
-		obj.Balance = obj.ConfirmBalance
-		obj.UnconfirmBalance = "0"
+		obj := b.Balance(bs.wm.Symbol(), addr)
 
 		addrBalanceArr = append(addrBalanceArr, obj)
 	}
@@ -17,6 +17,7 @@ package sero
 
 import (
 	"errors"
+	"github.com/asdine/storm"
 	"github.com/asdine/storm/q"
 )
 
@@ -130,11 +131,31 @@ func (bs *SEROBlockScanner) SaveUnspent(utxo *Unspent, nilKeys []string) error {
 
 	defer tx.Rollback()
 
+	//重复扫描时先扣减旧记录计入的余额
+	var exist Unspent
+	err = tx.One("Root", utxo.Root, &exist)
+	if err == nil {
+		err = updateBalanceIndex(tx, &exist, false)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = tx.Save(utxo)
 	if err != nil {
 		return err
 	}
 
+	err = updateBalanceIndex(tx, utxo, true)
+	if err != nil {
+		return err
+	}
+
+	err = saveUsedAddress(tx, utxo)
+	if err != nil {
+		return err
+	}
+
 	//nil与utxo关联，保存
 	for _, nilkey := range nilKeys {
 		err = tx.Set(NilKeyBucket, nilkey, utxo.Root)
@@ -183,6 +204,11 @@ func (bs *SEROBlockScanner) DeleteUnspent(nilKey string) error {
 		return err
 	}
 
+	err = updateBalanceIndex(tx, &utxo, false)
+	if err != nil {
+		return err
+	}
+
 	//bs.wm.Log.Infof("delete utxo = %s", root)
 
 	//删除utxo与nil的关联记录
@@ -210,18 +236,40 @@ func (bs *SEROBlockScanner) DeleteUnspentByHeight(height uint64) error {
 	defer tx.Rollback()
 
 	var list []*Unspent
-	err = db.Find("Height", height, &list)
+	err = tx.Find("Height", height, &list)
 	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil
+		}
 		return err
 	}
 
 	for _, u := range list {
-		tx.DeleteStruct(u)
+		err = tx.DeleteStruct(u)
+		if err != nil {
+			return err
+		}
+		err = updateBalanceIndex(tx, u, false)
+		if err != nil {
+			return err
+		}
 	}
 
 	return tx.Commit()
 }
 
+// GetUnspent 查询未花记录
+func (wm *WalletManager) GetUnspent(root string) (*Unspent, error) {
+
+	var utxo Unspent
+	err := wm.unspentDB.One("Root", root, &utxo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &utxo, nil
+}
+
 // LockUnspent 锁定发送中utxo
 func (wm *WalletManager) LockUnspent(root string) error {
 
@@ -0,0 +1,395 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+	"time"
+)
+
+const (
+	BuiltTxStatusBuilt     = "built"     //已创建，输入utxo已预留
+	BuiltTxStatusSubmitted = "submitted" //已广播
+	BuiltTxStatusExpired   = "expired"   //超时未广播，输入utxo已释放，相同Sid不能重新创建
+	BuiltTxStatusInvalid   = "invalid"   //输入utxo已被其它交易花费，交易单已无效，剩余的输入utxo已释放
+)
+
+var (
+	//ErrBuiltTxExists Sid已创建过交易单
+	ErrBuiltTxExists = fmt.Errorf("transaction sid has been built")
+)
+
+//BuiltTx 按业务订单号Sid记录已创建的交易单，重复创建时返回原交易单
+type BuiltTx struct {
+	Sid         string   `json:"sid" storm:"id"`
+	AccountID   string   `json:"accountID" storm:"index"`
+	Roots       []string `json:"roots"` //预留的输入utxo
+	RawTx       string   `json:"rawTx"` //交易单json
+	TxID        string   `json:"txid"`
+	Status      string   `json:"status" storm:"index"`
+	BuildHeight uint64   `json:"buildHeight"`
+	CreateAt    int64    `json:"createAt"`
+	Reason      string   `json:"reason"` //过期或无效的原因
+}
+
+//GetBuiltTx 查询Sid已创建的交易单记录
+func (wm *WalletManager) GetBuiltTx(sid string) (*BuiltTx, error) {
+	var built BuiltTx
+	err := wm.unspentDB.One("Sid", sid, &built)
+	if err != nil {
+		return nil, err
+	}
+	return &built, nil
+}
+
+//ListBuiltTx 查询指定状态的交易单记录
+func (wm *WalletManager) ListBuiltTx(status string) ([]*BuiltTx, error) {
+	var list []*BuiltTx
+	err := wm.unspentDB.Find("Status", status, &list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return list, nil
+}
+
+//SaveBuiltTx 记录已创建的交易单，并预留输入utxo
+func (wm *WalletManager) SaveBuiltTx(rawTx *openwallet.RawTransaction) (*BuiltTx, error) {
+
+	if len(rawTx.Sid) == 0 {
+		return nil, fmt.Errorf("transaction sid is empty")
+	}
+
+	currentHeight, err := wm.GetBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	built := &BuiltTx{
+		Sid:         rawTx.Sid,
+		AccountID:   rawTx.Account.AccountID,
+		Roots:       make([]string, 0),
+		RawTx:       string(data),
+		Status:      BuiltTxStatusBuilt,
+		BuildHeight: currentHeight,
+		CreateAt:    time.Now().Unix(),
+	}
+
+	for _, keySignature := range rawTx.Signatures[rawTx.Account.AccountID] {
+		built.Roots = append(built.Roots, keySignature.Message)
+	}
+
+	tx, err := wm.unspentDB.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	//并发创建相同Sid时，后保存的返回ErrBuiltTxExists，由调用方返回先保存的交易单
+	var exist BuiltTx
+	if findErr := tx.One("Sid", built.Sid, &exist); findErr == nil {
+		return &exist, ErrBuiltTxExists
+	}
+
+	for _, root := range built.Roots {
+		var utxo Unspent
+		err = tx.One("Root", root, &utxo)
+		if err != nil {
+			return nil, fmt.Errorf("utxo: %s not found, %v", root, err)
+		}
+		if utxo.Sending {
+			return nil, fmt.Errorf("utxo: %s is locked by other transaction", root)
+		}
+		utxo.Sending = true
+		err = tx.Save(&utxo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Save(built)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return built, nil
+}
+
+//SubmitBuiltTx 交易单已广播，记录txid
+func (wm *WalletManager) SubmitBuiltTx(rawTx *openwallet.RawTransaction, txid string) error {
+
+	if len(rawTx.Sid) == 0 {
+		return nil
+	}
+
+	built, err := wm.GetBuiltTx(rawTx.Sid)
+	if err != nil {
+		//不是按Sid创建的交易单
+		return nil
+	}
+
+	data, err := json.Marshal(rawTx)
+	if err != nil {
+		return err
+	}
+
+	built.RawTx = string(data)
+	built.TxID = txid
+	built.Status = BuiltTxStatusSubmitted
+
+	return wm.unspentDB.Save(built)
+}
+
+//ReleaseBuiltTx 删除未广播或已过期的交易单记录，未广播的交易单释放预留的输入utxo，
+//过期的交易单输入已在过期时释放，删除后相同Sid可以重新创建
+func (wm *WalletManager) ReleaseBuiltTx(sid string) error {
+
+	tx, err := wm.unspentDB.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var built BuiltTx
+	err = tx.One("Sid", sid, &built)
+	if err != nil {
+		return err
+	}
+
+	switch built.Status {
+	case BuiltTxStatusBuilt:
+		err = releaseBuiltTxInputs(tx, &built)
+		if err != nil {
+			return err
+		}
+	case BuiltTxStatusExpired:
+	default:
+		return fmt.Errorf("transaction sid: %s is %s, only built or expired transaction can be released", sid, built.Status)
+	}
+
+	err = tx.DeleteStruct(&built)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//releaseBuiltTxInputs 释放交易单预留的输入utxo，已被花费的utxo无需释放
+func releaseBuiltTxInputs(tx storm.Node, built *BuiltTx) error {
+	for _, root := range built.Roots {
+		var utxo Unspent
+		if findErr := tx.One("Root", root, &utxo); findErr != nil {
+			if findErr != storm.ErrNotFound {
+				return findErr
+			}
+			continue
+		}
+		utxo.Sending = false
+		err := tx.Save(&utxo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//ExpireBuiltTxs 超过BuiltTxTimeout个区块仍未广播的交易单标记为过期并释放输入utxo，记录永久保留，相同Sid不能重新创建。
+//未广播的交易单只有部分输入已被花费时，说明输入被其它交易使用，交易单已无效，释放剩余的输入
+func (wm *WalletManager) ExpireBuiltTxs(currentHeight uint64) error {
+
+	list, err := wm.ListBuiltTx(BuiltTxStatusBuilt)
+	if err != nil {
+		return err
+	}
+
+	for _, built := range list {
+
+		invalid, checkErr := wm.invalidateBuiltTx(built.Sid)
+		if checkErr != nil {
+			wm.Log.Warningf("check built transaction sid: %s failed, unexpected error: %v", built.Sid, checkErr)
+			continue
+		}
+		if invalid || wm.Config.BuiltTxTimeout == 0 {
+			continue
+		}
+
+		if currentHeight <= built.BuildHeight+wm.Config.BuiltTxTimeout {
+			continue
+		}
+
+		reason := fmt.Sprintf("not submitted after %d blocks", wm.Config.BuiltTxTimeout)
+		if expireErr := wm.expireBuiltTx(built.Sid, reason); expireErr != nil {
+			wm.Log.Warningf("expire built transaction sid: %s failed, unexpected error: %v", built.Sid, expireErr)
+			continue
+		}
+		wm.Log.Warningf("built transaction sid: %s is %s, mark it expired and release its inputs", built.Sid, reason)
+	}
+
+	return nil
+}
+
+//expireBuiltTx 未广播的交易单标记为过期，释放输入utxo
+func (wm *WalletManager) expireBuiltTx(sid, reason string) error {
+
+	tx, err := wm.unspentDB.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var built BuiltTx
+	err = tx.One("Sid", sid, &built)
+	if err != nil {
+		return err
+	}
+
+	if built.Status != BuiltTxStatusBuilt {
+		return nil
+	}
+
+	err = releaseBuiltTxInputs(tx, &built)
+	if err != nil {
+		return err
+	}
+
+	built.Status = BuiltTxStatusExpired
+	built.Reason = reason
+	err = tx.Save(&built)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//invalidateBuiltTx 未广播的交易单部分输入已被花费时，标记为无效并释放剩余的输入utxo。
+//一笔交易会花费全部输入，全部输入都已花费可能是交易单在别处广播，不做处理。
+//过期的交易单输入已释放，可能被其它交易单预留，不再处理
+func (wm *WalletManager) invalidateBuiltTx(sid string) (bool, error) {
+
+	tx, err := wm.unspentDB.Begin(true)
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	var built BuiltTx
+	err = tx.One("Sid", sid, &built)
+	if err != nil {
+		return false, err
+	}
+
+	if built.Status != BuiltTxStatusBuilt {
+		return false, nil
+	}
+
+	spent := make([]string, 0)
+	remains := make([]*Unspent, 0)
+	for _, root := range built.Roots {
+		var utxo Unspent
+		if findErr := tx.One("Root", root, &utxo); findErr != nil {
+			if findErr != storm.ErrNotFound {
+				return false, findErr
+			}
+			spent = append(spent, root)
+			continue
+		}
+		remains = append(remains, &utxo)
+	}
+
+	if len(spent) == 0 || len(remains) == 0 {
+		return false, nil
+	}
+
+	for _, utxo := range remains {
+		utxo.Sending = false
+		err = tx.Save(utxo)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	built.Status = BuiltTxStatusInvalid
+	built.Reason = fmt.Sprintf("inputs: %v have been spent by other transaction", spent)
+	err = tx.Save(&built)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	wm.Log.Warningf("built transaction sid: %s is invalid, %s, release the other inputs", sid, built.Reason)
+
+	return true, nil
+}
+
+//restoreBuiltTx 交易单的Sid已创建过，恢复为原交易单，已广播的交易单带有txid
+func (decoder *TransactionDecoder) restoreBuiltTx(rawTx *openwallet.RawTransaction) (bool, error) {
+
+	if len(rawTx.Sid) == 0 {
+		return false, nil
+	}
+
+	built, err := decoder.wm.GetBuiltTx(rawTx.Sid)
+	if err != nil {
+		return false, nil
+	}
+
+	if built.AccountID != rawTx.Account.AccountID {
+		return false, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction sid: %s is used by other account", rawTx.Sid)
+	}
+
+	//过期或无效的Sid不能重新创建，避免同一订单重复支付
+	if built.Status == BuiltTxStatusExpired || built.Status == BuiltTxStatusInvalid {
+		return false, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction sid: %s is %s, %s", rawTx.Sid, built.Status, built.Reason)
+	}
+
+	var restored openwallet.RawTransaction
+	err = json.Unmarshal([]byte(built.RawTx), &restored)
+	if err != nil {
+		return false, err
+	}
+
+	*rawTx = restored
+	if built.Status == BuiltTxStatusSubmitted {
+		rawTx.TxID = built.TxID
+		rawTx.IsSubmit = true
+	}
+
+	decoder.wm.Log.Infof("transaction sid: %s has been built, return the existing transaction, status: %s", built.Sid, built.Status)
+
+	return true, nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/blocktree/sero-adapter/client"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//testBlockNumberServer 模拟节点的sero_blockNumber，区块高度为100
+func testBlockNumberServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":"1","result":"0x64"}`))
+	}))
+}
+
+//testBuiltRawTx 花费指定utxo的交易单
+func testBuiltRawTx(sid string, roots ...string) *openwallet.RawTransaction {
+	account := &openwallet.AssetsAccount{AccountID: "tk"}
+	signatures := make([]*openwallet.KeySignature, 0)
+	for _, root := range roots {
+		signatures = append(signatures, &openwallet.KeySignature{Message: root})
+	}
+	return &openwallet.RawTransaction{
+		Sid:        sid,
+		Account:    account,
+		Signatures: map[string][]*openwallet.KeySignature{account.AccountID: signatures},
+	}
+}
+
+func TestWalletManager_ExpireBuiltTxs(t *testing.T) {
+
+	wm, cleanup := testUnspentDBWalletManager(t)
+	defer cleanup()
+	server := testBlockNumberServer()
+	defer server.Close()
+	wm.WalletClient = client.NewClient(server.URL, false)
+	wm.Config.BuiltTxTimeout = 10
+
+	for _, root := range []string{"r1", "r2"} {
+		if err := wm.unspentDB.Save(&Unspent{Root: root, TK: "tk", Currency: "SERO", Value: "1"}); err != nil {
+			t.Fatalf("save unspent failed, unexpected error: %v", err)
+		}
+	}
+
+	if _, err := wm.SaveBuiltTx(testBuiltRawTx("sid1", "r1", "r2")); err != nil {
+		t.Fatalf("SaveBuiltTx() error = %v", err)
+	}
+
+	//预留的utxo不能再被其它交易单使用
+	if _, err := wm.SaveBuiltTx(testBuiltRawTx("sid2", "r1")); err == nil {
+		t.Fatalf("SaveBuiltTx() with locked input error = nil, want error")
+	}
+
+	//未超时不过期
+	if err := wm.ExpireBuiltTxs(110); err != nil {
+		t.Fatalf("ExpireBuiltTxs() error = %v", err)
+	}
+	if built, _ := wm.GetBuiltTx("sid1"); built == nil || built.Status != BuiltTxStatusBuilt {
+		t.Fatalf("built transaction = %+v, want status built", built)
+	}
+
+	if err := wm.ExpireBuiltTxs(111); err != nil {
+		t.Fatalf("ExpireBuiltTxs() error = %v", err)
+	}
+	built, err := wm.GetBuiltTx("sid1")
+	if err != nil || built.Status != BuiltTxStatusExpired {
+		t.Fatalf("built transaction = %+v, error = %v, want status expired", built, err)
+	}
+	for _, root := range []string{"r1", "r2"} {
+		utxo, err := wm.GetUnspent(root)
+		if err != nil || utxo.Sending {
+			t.Errorf("utxo %s = %+v, error = %v, want released", root, utxo, err)
+		}
+	}
+
+	//释放的utxo可以重新选择
+	if _, err := wm.SaveBuiltTx(testBuiltRawTx("sid2", "r1")); err != nil {
+		t.Fatalf("SaveBuiltTx() with released input error = %v", err)
+	}
+
+	//过期的交易单记录可以删除，不影响其它交易单预留的utxo
+	if err := wm.ReleaseBuiltTx("sid1"); err != nil {
+		t.Fatalf("ReleaseBuiltTx() expired error = %v", err)
+	}
+	if _, err := wm.GetBuiltTx("sid1"); err == nil {
+		t.Errorf("expired built transaction is not deleted")
+	}
+	if utxo, _ := wm.GetUnspent("r1"); utxo == nil || !utxo.Sending {
+		t.Errorf("utxo r1 reserved by sid2 = %+v, want locked", utxo)
+	}
+
+	//已广播的交易单不能释放
+	if err := wm.SubmitBuiltTx(testBuiltRawTx("sid2", "r1"), "0x01"); err != nil {
+		t.Fatalf("SubmitBuiltTx() error = %v", err)
+	}
+	if err := wm.ReleaseBuiltTx("sid2"); err == nil {
+		t.Errorf("ReleaseBuiltTx() submitted error = nil, want error")
+	}
+}
@@ -0,0 +1,305 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/openwallet"
+	"time"
+)
+
+const (
+	ChangeAddressModeInput = "input" //使用第一个输入utxo的地址找零
+	ChangeAddressModeFresh = "fresh" //每笔交易单从账户公钥生成新的找零地址
+	ChangeAddressModeFixed = "fixed" //账户使用固定的找零地址
+)
+
+//ChangeAddress 找零地址记录，扫描器通过它识别找零输出
+type ChangeAddress struct {
+	Address   string `json:"address" storm:"id"`
+	AccountID string `json:"accountID" storm:"index"`
+	Index     uint64 `json:"index"`
+	Fixed     bool   `json:"fixed"`
+	CreateAt  int64  `json:"createAt"`
+}
+
+//SaveChangeAddress 保存找零地址
+func (wm *WalletManager) SaveChangeAddress(change *ChangeAddress) error {
+	if change == nil {
+		return fmt.Errorf("the change address to save is nil")
+	}
+	return wm.unspentDB.Save(change)
+}
+
+//GetChangeAddress 查询找零地址记录
+func (wm *WalletManager) GetChangeAddress(address string) (*ChangeAddress, error) {
+	var change ChangeAddress
+	err := wm.unspentDB.One("Address", address, &change)
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+//GetFixChangeAddress 查询账户的固定找零地址
+func (wm *WalletManager) GetFixChangeAddress(accountID string) (*ChangeAddress, error) {
+	var change ChangeAddress
+	err := wm.unspentDB.Select(
+		q.And(
+			q.Eq("AccountID", accountID),
+			q.Eq("Fixed", true),
+		)).First(&change)
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+//SetFixChangeAddress 设置账户的固定找零地址，地址必须属于该账户
+func (wm *WalletManager) SetFixChangeAddress(accountID, address string) error {
+
+	db := wm.unspentDB
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var list []*ChangeAddress
+	err = tx.Find("AccountID", accountID, &list)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	change := &ChangeAddress{
+		Address:   address,
+		AccountID: accountID,
+		Index:     uint64(len(list)),
+		CreateAt:  time.Now().Unix(),
+	}
+
+	for _, c := range list {
+		if c.Address == address {
+			change = c
+			continue
+		}
+		if c.Fixed {
+			c.Fixed = false
+			err = tx.Save(c)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	change.Fixed = true
+	err = tx.Save(change)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//CreateChangeAddress 从账户公钥生成新的找零地址，并记录下来
+func (wm *WalletManager) CreateChangeAddress(account *openwallet.AssetsAccount) (*ChangeAddress, error) {
+
+	if account == nil || len(account.PublicKey) == 0 {
+		return nil, fmt.Errorf("account public key is empty")
+	}
+
+	//恢复的找零地址索引可能不连续，使用最大索引的下一个
+	var (
+		index uint64
+		last  ChangeAddress
+	)
+	err := wm.unspentDB.Select(q.Eq("AccountID", account.AccountID)).OrderBy("Index").Reverse().First(&last)
+	if err == nil {
+		index = last.Index + 1
+	} else if err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	addr, err := wm.Decoder.CreateDerivedAddress(account, index, true)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &ChangeAddress{
+		Address:   addr.Address,
+		AccountID: account.AccountID,
+		Index:     index,
+		CreateAt:  time.Now().Unix(),
+	}
+
+	err = wm.SaveChangeAddress(change)
+	if err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+//getChangeAddress 获取交易单的找零地址。
+//交易单指定了Change则使用它，否则按配置的ChangeAddressMode选择。
+func (decoder *TransactionDecoder) getChangeAddress(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, usedUTXO []*Unspent) (string, error) {
+
+	wm := decoder.wm
+	account := rawTx.Account
+
+	//交易单指定了找零地址，必须属于付款账户
+	if rawTx.Change != nil && len(rawTx.Change.Address) > 0 {
+		change, err := decoder.verifyChangeAddress(wrapper, account, rawTx.Change)
+		if err != nil {
+			return "", err
+		}
+		if _, findErr := wm.GetChangeAddress(change.Address); findErr != nil {
+			//记录下来，扫描器才能识别找零输出，签名时也能找到地址
+			err = wm.SaveChangeAddress(change)
+			if err != nil {
+				return "", err
+			}
+		}
+		return change.Address, nil
+	}
+
+	switch wm.Config.ChangeAddressMode {
+	case ChangeAddressModeFresh:
+		change, err := wm.CreateChangeAddress(account)
+		if err != nil {
+			return "", err
+		}
+		return change.Address, nil
+	case ChangeAddressModeFixed:
+		change, err := wm.GetFixChangeAddress(account.AccountID)
+		if err != nil {
+			//首次使用，生成账户的固定找零地址
+			change, err = wm.CreateChangeAddress(account)
+			if err != nil {
+				return "", err
+			}
+			err = wm.SetFixChangeAddress(account.AccountID, change.Address)
+			if err != nil {
+				return "", err
+			}
+		}
+		return change.Address, nil
+	default:
+		if len(usedUTXO) == 0 {
+			return "", fmt.Errorf("transaction inputs is empty")
+		}
+		return usedUTXO[0].Address, nil
+	}
+}
+
+//verifyChangeAddress 验证交易单指定的找零地址属于付款账户：钱包中该账户的地址、已记录的找零地址，
+//或者按地址索引从账户TK重新派生得到的收款或找零地址，不属于账户的地址拒绝使用
+func (decoder *TransactionDecoder) verifyChangeAddress(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, address *openwallet.Address) (*ChangeAddress, error) {
+
+	wm := decoder.wm
+
+	if addr, err := wrapper.GetAddress(address.Address); err == nil && addr.AccountID == account.AccountID {
+		return &ChangeAddress{
+			Address:   addr.Address,
+			AccountID: account.AccountID,
+			Index:     addr.Index,
+			CreateAt:  time.Now().Unix(),
+		}, nil
+	}
+
+	if change, err := wm.GetChangeAddress(address.Address); err == nil {
+		if change.AccountID != account.AccountID {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "change address: %s does not belong to account: %s", address.Address, account.AccountID)
+		}
+		return change, nil
+	}
+
+	for _, isChange := range []bool{true, false} {
+		derived, err := wm.Decoder.CreateDerivedAddress(account, address.Index, isChange)
+		if err != nil {
+			return nil, err
+		}
+		if derived.Address == address.Address {
+			return &ChangeAddress{
+				Address:   address.Address,
+				AccountID: account.AccountID,
+				Index:     address.Index,
+				CreateAt:  time.Now().Unix(),
+			}, nil
+		}
+	}
+
+	return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "change address: %s is not derived from account: %s at index: %d", address.Address, account.AccountID, address.Index)
+}
+
+//getSignAddress 查询签名输入utxo的地址。找零地址只记录在找零地址表中，钱包查不到时按找零记录
+//或地址恢复找回的收款地址记录生成，签名使用账户的HDPath
+func (decoder *TransactionDecoder) getSignAddress(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, address string) (*openwallet.Address, error) {
+
+	addr, err := wrapper.GetAddress(address)
+	if err == nil {
+		return addr, nil
+	}
+
+	change, findErr := decoder.wm.GetChangeAddress(address)
+	if findErr != nil || change.AccountID != account.AccountID {
+
+		//地址恢复找回的收款地址
+		recovered, recoverErr := decoder.wm.GetRecoveredAddress(address)
+		if recoverErr != nil || recovered.AccountID != account.AccountID {
+			return nil, err
+		}
+
+		return &openwallet.Address{
+			AccountID: account.AccountID,
+			Address:   recovered.Address,
+			PublicKey: account.PublicKey,
+			Symbol:    account.Symbol,
+			Index:     recovered.Index,
+			HDPath:    account.HDPath,
+		}, nil
+	}
+
+	return &openwallet.Address{
+		AccountID: account.AccountID,
+		Address:   change.Address,
+		PublicKey: account.PublicKey,
+		Symbol:    account.Symbol,
+		Index:     change.Index,
+		HDPath:    account.HDPath,
+		IsChange:  true,
+	}, nil
+}
+
+//setRawTransactionChange 记录交易单的找零地址
+func (decoder *TransactionDecoder) setRawTransactionChange(rawTx *openwallet.RawTransaction, changeAddress string) {
+	if rawTx.Change != nil && rawTx.Change.Address == changeAddress {
+		rawTx.Change.IsChange = true
+		return
+	}
+	rawTx.Change = &openwallet.Address{
+		AccountID: rawTx.Account.AccountID,
+		Address:   changeAddress,
+		PublicKey: rawTx.Account.PublicKey,
+		Symbol:    rawTx.Account.Symbol,
+		HDPath:    rawTx.Account.HDPath,
+		IsChange:  true,
+	}
+}
@@ -12,6 +12,7 @@ const (
 	Symbol    = "SERO"
 	CurveType = owcrypt.ECC_CURVE_SECP256K1
 	MaxTxInputs = 200
+	MaxTxOutputs = 50
 	MinConfirms = uint64(12)
 )
 
@@ -41,6 +42,48 @@ type WalletConfig struct {
 	FixGas int64
 	//数据目录
 	DataDir string
+	//触发合并utxo的数量
+	ConsolidateMinCount int64
+	//粉尘utxo的金额阈值，低于此金额的utxo视为粉尘
+	ConsolidateDustAmount string
+	//触发合并的粉尘utxo数量
+	ConsolidateDustCount int64
+	//每次合并utxo最多消耗的手续费，0为不限制
+	ConsolidateMaxFees string
+	//合并的utxo要求的确认数
+	ConsolidateConfirms uint64
+	//找零地址模式：input，fresh，fixed
+	ChangeAddressMode string
+	//汽油预估模式：fixed，local，node
+	GasEstimateMode string
+	//本地模型的基础汽油，默认为节点对普通交易收取的固有汽油params.TxGas
+	GasBase int64
+	//本地模型每个输入的汽油，以下按数量增加的汽油是预留的经验值，节点对普通交易只收取固有汽油
+	GasPerInput int64
+	//本地模型每个输出的汽油
+	GasPerOutput int64
+	//本地模型每个匿名输出额外的汽油
+	GasPerZOutput int64
+	//本地模型每个币种的汽油
+	GasPerCurrency int64
+	//预估汽油的安全余量比例
+	GasSafetyMargin string
+	//预估汽油的上限，0为不限制
+	MaxGas int64
+	//已提交的交易超过此区块数后节点仍返回交易不存在，认为已被拒绝，交易池中的交易不会被释放
+	PendingTxTimeout uint64
+	//已提交的交易达到此确认数后认为已确认
+	TxConfirms uint64
+	//按Sid创建的交易单超过此区块数仍未广播，标记为过期并释放输入，0为不过期
+	BuiltTxTimeout uint64
+	//登记的代币，格式为：币种:精度[:名称]，多个代币用逗号分隔
+	Tokens string
+	//启动时校验余额索引：none，verify，rebuild
+	BalanceIndexCheck string
+	//启动时恢复地址的账户TK，逗号分隔
+	RecoverAccounts string
+	//地址恢复时连续未使用的地址数，也是观察账户预先派生的地址数
+	AddressGap uint64
 }
 
 func NewConfig(symbol string) *WalletConfig {
@@ -65,6 +108,31 @@ func NewConfig(symbol string) *WalletConfig {
 	c.dbPath = filepath.Join("data", strings.ToLower(c.Symbol), "db")
 	//钱包服务API
 	c.ServerAPI = ""
+	//合并utxo的默认设置
+	c.ConsolidateMinCount = 100
+	c.ConsolidateDustAmount = "0"
+	c.ConsolidateDustCount = 50
+	c.ConsolidateMaxFees = "0"
+	c.ConsolidateConfirms = MinConfirms
+	//默认使用第一个输入utxo的地址找零
+	c.ChangeAddressMode = ChangeAddressModeInput
+	//汽油预估的默认设置
+	c.GasEstimateMode = GasEstimateModeFixed
+	c.GasBase = 25000
+	c.GasPerInput = 500
+	c.GasPerOutput = 500
+	c.GasPerZOutput = 1000
+	c.GasPerCurrency = 1000
+	c.GasSafetyMargin = "0.1"
+	c.MaxGas = 0
+	//已提交交易的默认等待区块数
+	c.PendingTxTimeout = 60
+	c.TxConfirms = MinConfirms
+	c.BuiltTxTimeout = 240
+	c.Tokens = ""
+	c.BalanceIndexCheck = BalanceIndexCheckVerify
+	c.RecoverAccounts = ""
+	c.AddressGap = DefaultAddressGap
 
 	//创建目录
 	//file.MkdirAll(c.dbPath)
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+//ConsolidationPlan 合并utxo计划
+type ConsolidationPlan struct {
+	AccountID    string                       //账户ID
+	Currency     string                       //币种
+	UnspentCount int                          //可合并的utxo数量
+	DustCount    int                          //粉尘utxo数量
+	Triggered    bool                         //是否达到合并条件
+	Fees         decimal.Decimal              //合并总手续费
+	RawTxs       []*openwallet.RawTransaction //合并交易单
+}
+
+//CreateConsolidationRawTransaction 创建合并utxo交易单。
+//账户指定币种的utxo数量达到ConsolidateMinCount，或粉尘utxo数量达到ConsolidateDustCount时，
+//把utxo从小到大合并到账户自己的地址，每MaxTxInputs个utxo合并为一个输出，总手续费不超过ConsolidateMaxFees。
+func (decoder *TransactionDecoder) CreateConsolidationRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, coin openwallet.Coin, feeRate string) (*ConsolidationPlan, error) {
+
+	var (
+		accountID    = account.AccountID
+		currency     = ""
+		coinDecimals = int32(0)
+		feeUnspents  = make([]*Unspent, 0)
+		config       = decoder.wm.Config
+	)
+
+	if coin.IsContract {
+		currency = coin.Contract.Address
+		coinDecimals = decoder.wm.CoinDecimals(coin)
+	} else {
+		currency = coin.Symbol
+		coinDecimals = decoder.wm.Decimal()
+	}
+
+	plan := &ConsolidationPlan{
+		AccountID: accountID,
+		Currency:  currency,
+		Fees:      decimal.Zero,
+		RawTxs:    make([]*openwallet.RawTransaction, 0),
+	}
+
+	dustAmount, _ := decimal.NewFromString(config.ConsolidateDustAmount)
+	maxFees, _ := decimal.NewFromString(config.ConsolidateMaxFees)
+
+	confirms := config.ConsolidateConfirms
+	if confirms < MinConfirms {
+		confirms = MinConfirms
+	}
+
+	//每笔合并交易最多MaxTxInputs个输入，合并到一个输出
+	gasParam := NewTxGasParam(MaxTxInputs, 1, 1)
+	if coin.IsContract {
+		gasParam = NewTxGasParam(MaxTxInputs, 2, 2)
+	}
+
+	feesRate, _ := decimal.NewFromString(feeRate)
+	fees, feesRate, gas, err := decoder.wm.EstimateTxFee(feesRate, gasParam)
+	if err != nil {
+		return nil, err
+	}
+
+	//获取当前最大高度
+	currentHeight, err := decoder.wm.GetBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	unspents, err := decoder.wm.ListUnspent(accountID, currency, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	//排除未确认、锁定和持有票据的utxo
+	available := make([]*Unspent, 0)
+	for _, u := range decoder.availableUnspents(currentHeight, unspents) {
+		if currentHeight-u.Height <= confirms {
+			continue
+		}
+		ua, _ := decimal.NewFromString(u.Value)
+		if ua.Shift(-coinDecimals).LessThan(dustAmount) {
+			plan.DustCount++
+		}
+		available = append(available, u)
+	}
+
+	plan.UnspentCount = len(available)
+
+	if int64(plan.UnspentCount) < config.ConsolidateMinCount && int64(plan.DustCount) < config.ConsolidateDustCount {
+		return plan, nil
+	}
+
+	//少于2个utxo无需合并
+	if plan.UnspentCount < 2 {
+		return plan, nil
+	}
+
+	plan.Triggered = true
+
+	//从小到大合并，优先消除粉尘utxo
+	sort.SliceStable(available, func(i, j int) bool {
+		vi, _ := decimal.NewFromString(available[i].Value)
+		vj, _ := decimal.NewFromString(available[j].Value)
+		return vi.LessThan(vj)
+	})
+
+	if coin.IsContract {
+		mainUnspents, err := decoder.wm.ListUnspent(accountID, coin.Symbol, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		feeUnspents = decoder.availableUnspents(currentHeight, mainUnspents)
+	}
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:    coin,
+		Account: account,
+	}
+
+	for len(available) > 1 {
+
+		var (
+			usedUTXO   = make([]*Unspent, 0)
+			feeUTXO    = make([]*Unspent, 0)
+			chunkValue = decimal.Zero
+		)
+
+		//超过手续费预算，停止合并
+		if maxFees.GreaterThan(decimal.Zero) && plan.Fees.Add(fees).GreaterThan(maxFees) {
+			decoder.wm.Log.Warningf("consolidate fees over budget: %s", maxFees.String())
+			break
+		}
+
+		limit := MaxTxInputs
+		if coin.IsContract {
+			feeUTXO, feeUnspents, err = decoder.takeUnspentsSatisfyAmount(feeUnspents, fees, decoder.wm.Decimal())
+			if err != nil {
+				//SERO不足以支付手续费，已创建的合并交易单仍然返回
+				if len(plan.RawTxs) > 0 {
+					decoder.wm.Log.Warningf("consolidate stop: %v", err)
+					break
+				}
+				return nil, err
+			}
+			limit = MaxTxInputs - len(feeUTXO)
+		}
+
+		for len(available) > 0 && len(usedUTXO) < limit {
+			u := available[0]
+			available = available[1:]
+			ua, _ := decimal.NewFromString(u.Value)
+			chunkValue = chunkValue.Add(ua.Shift(-coinDecimals))
+			usedUTXO = append(usedUTXO, u)
+		}
+
+		if len(usedUTXO) < 2 {
+			break
+		}
+
+		if !coin.IsContract {
+			chunkValue = chunkValue.Sub(fees)
+		}
+
+		//合并金额不足以支付手续费
+		if chunkValue.LessThanOrEqual(decimal.Zero) {
+			decoder.wm.Log.Warningf("consolidate utxo value is not enough to pay fees: %s", fees.String())
+			break
+		}
+
+		merge := []*splitPayment{{Address: usedUTXO[0].Address, Amount: chunkValue}}
+
+		mergeTx, err := decoder.buildPlanRawTransaction(wrapper, rawTx, currency, coinDecimals, feesRate, fees, gas, usedUTXO, feeUTXO, merge)
+		if err != nil {
+			return nil, err
+		}
+		err = decoder.saveRawTxExt(mergeTx)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.RawTxs = append(plan.RawTxs, mergeTx)
+		plan.Fees = plan.Fees.Add(fees)
+	}
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("Consolidate Account: %s", accountID)
+	decoder.wm.Log.Std.Notice("Currency: %s", currency)
+	decoder.wm.Log.Std.Notice("UTXO Count: %d", plan.UnspentCount)
+	decoder.wm.Log.Std.Notice("Dust Count: %d", plan.DustCount)
+	decoder.wm.Log.Std.Notice("Transactions: %d", len(plan.RawTxs))
+	decoder.wm.Log.Std.Notice("Fees: %v", plan.Fees.String())
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	if len(plan.RawTxs) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "[%s] %s no consolidate transaction created", accountID, currency)
+	}
+
+	return plan, nil
+}
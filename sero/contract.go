@@ -16,9 +16,8 @@
 package sero
 
 import (
-	"github.com/blocktree/openwallet/log"
+	"fmt"
 	"github.com/blocktree/openwallet/openwallet"
-	"github.com/shopspring/decimal"
 )
 
 type ContractDecoder struct {
@@ -37,27 +36,24 @@ func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.Sma
 
 	var tokenBalanceList []*openwallet.TokenBalance
 
-	for _, addr := range address {
-		//log.Infof("GetTokenBalanceByAddress: %s", addr)
-		utxo, err := decoder.wm.ListUnspentByAddress(addr, contract.Address, 0, -1)
-		if err != nil {
-			log.Debugf("ListUnspentByAddress failed, err: %v", err)
-			//return nil, nil
+	//调用方没有提供精度时使用登记的代币信息
+	if contract.Decimals == 0 {
+		coin := decoder.wm.TokenCoin(contract.Address)
+		contract.Decimals = coin.Contract.Decimals
+		if len(contract.Name) == 0 {
+			contract.Name = coin.Contract.Name
 		}
+	}
 
-		obj := &openwallet.Balance{}
-		tb := decimal.Zero
-		for _, u := range utxo {
-
-			b, _ := decimal.NewFromString(u.Value)
-			tb = tb.Add(b.Shift(-int32(contract.Decimals)))
+	//按同一高度计算所有地址的确认数
+	height := decoder.wm.balanceHeight()
 
+	for _, addr := range address {
+		b, err := decoder.wm.GetAddressBalance(addr, contract.Address, int32(contract.Decimals), height)
+		if err != nil {
+			return nil, fmt.Errorf("get address: %s balance failed, %v", addr, err)
 		}
-		obj.Symbol = contract.Symbol
-		obj.Address = addr
-		obj.ConfirmBalance = tb.String()
-		obj.Balance = obj.ConfirmBalance
-		obj.UnconfirmBalance = "0"
+		obj := b.Balance(contract.Symbol, addr)
 
 		tokenBalance := &openwallet.TokenBalance{
 			Contract: &contract,
@@ -0,0 +1,462 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/blocktree/sero-adapter/sero_addrdec"
+	"github.com/sero-cash/go-sero/common/hexutil"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+	"strings"
+)
+
+const (
+	contractCallExtKey = "contractCall" //交易单和交易记录ExtParam中合约调用的字段
+)
+
+//ContractCall 合约调用，交易单的To只有一个接收方，即合约地址和支付的金额
+type ContractCall struct {
+	To       string `json:"to"`                 //合约地址，96字节的合约地址或20字节的合约短地址
+	Contract string `json:"contract,omitempty"` //创建交易单时节点解析的96字节合约地址
+	Data     string `json:"data"`     //调用数据，hex
+	Currency string `json:"currency"` //支付的币种
+	Amount   string `json:"amount"`   //支付的金额
+	Gas      int64  `json:"gas"`      //指定调用的汽油，为0时由节点预估
+}
+
+//parseContractAddress 解析合约地址，只接受后32字节为0的96字节合约地址和20字节的合约短地址
+func parseContractAddress(address string) (*sero_addrdec.AddressInfo, error) {
+	info, err := sero_addrdec.ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	switch info.Type {
+	case sero_addrdec.AddressTypeContract, sero_addrdec.AddressTypeShort:
+		return info, nil
+	default:
+		return nil, fmt.Errorf("address type: %s is not contract address", info.Type)
+	}
+}
+
+//ResolveContractAddress 合约地址转为96字节的合约地址，短地址通过节点查询完整地址，并确认地址上有合约代码
+func (wm *WalletManager) ResolveContractAddress(address string) (string, error) {
+
+	info, err := parseContractAddress(address)
+	if err != nil {
+		return "", err
+	}
+
+	contract := address
+	if info.Type == sero_addrdec.AddressTypeShort {
+		request := []interface{}{
+			[]string{address},
+		}
+		result, callErr := wm.WalletClient.Call("sero_getFullAddress", request)
+		if callErr != nil {
+			return "", callErr
+		}
+		contract = ""
+		for short, full := range result.Map() {
+			if strings.EqualFold(short, address) {
+				contract = full.String()
+			}
+		}
+		if full, parseErr := sero_addrdec.ParseAddress(contract); parseErr != nil || full.Type != sero_addrdec.AddressTypeContract {
+			return "", fmt.Errorf("contract short address: %s has no full address", address)
+		}
+	}
+
+	code, err := wm.GetCode(contract)
+	if err != nil {
+		return "", err
+	}
+	if len(code) == 0 {
+		return "", fmt.Errorf("contract address: %s has no code", address)
+	}
+
+	return contract, nil
+}
+
+//GetCode 查询地址上的合约代码
+func (wm *WalletManager) GetCode(address string) ([]byte, error) {
+
+	request := []interface{}{
+		address,
+		"latest",
+	}
+
+	result, err := wm.WalletClient.Call("sero_getCode", request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.String()) == 0 {
+		return nil, nil
+	}
+
+	return hexutil.Decode(result.String())
+}
+
+//contractPKr 合约调用的目标收款码，使用创建交易单时解析的合约地址
+func (call *ContractCall) contractPKr() ([]byte, error) {
+	info, err := sero_addrdec.ParseAddress(call.Contract)
+	if err != nil {
+		return nil, err
+	}
+	if info.Type != sero_addrdec.AddressTypeContract {
+		return nil, fmt.Errorf("address type: %s is not contract address", info.Type)
+	}
+	return info.Bytes, nil
+}
+
+//getRawTransactionContractCall 读取交易单ExtParam中的合约调用，没有设置返回nil
+func (decoder *TransactionDecoder) getRawTransactionContractCall(rawTx *openwallet.RawTransaction) (*ContractCall, error) {
+
+	if len(rawTx.ExtParam) == 0 {
+		return nil, nil
+	}
+
+	param := rawTx.GetExtParam().Get(contractCallExtKey)
+	if !param.Exists() {
+		return nil, nil
+	}
+
+	if len(rawTx.To) != 1 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "contract call transaction must have only one receiver")
+	}
+
+	call := &ContractCall{
+		Contract: param.Get("contract").String(),
+		Data:     param.Get("data").String(),
+		Gas:      param.Get("gas").Int(),
+	}
+
+	for addr, amount := range rawTx.To {
+		call.To = addr
+		call.Amount = amount
+	}
+
+	if rawTx.Coin.IsContract {
+		call.Currency = rawTx.Coin.Contract.Address
+	} else {
+		call.Currency = rawTx.Coin.Symbol
+	}
+
+	if _, err := parseContractAddress(call.To); err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrAdressDecodeFailed, "contract address: %s is invalid, %v", call.To, err)
+	}
+
+	if data, err := hexutil.Decode(call.Data); err != nil || len(data) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "contract call data: %s is invalid", call.Data)
+	}
+
+	amount, err := decimal.NewFromString(call.Amount)
+	if err != nil || amount.LessThan(decimal.Zero) {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "contract call amount: %s is invalid", call.Amount)
+	}
+
+	//小数位超过币种精度时，转为最小单位会被截断
+	coinDecimals := decoder.wm.Decimal()
+	if rawTx.Coin.IsContract {
+		coinDecimals = decoder.wm.CoinDecimals(rawTx.Coin)
+	}
+	if amount.Exponent() < -coinDecimals {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "contract call amount: %s has more than %d decimals", call.Amount, coinDecimals)
+	}
+
+	if call.Gas < 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "contract call gas: %d is invalid", call.Gas)
+	}
+
+	return call, nil
+}
+
+//createContractCallRawTransaction 创建合约调用交易单，支付的资产随调用指令转给合约，手续费由SERO支付
+func (decoder *TransactionDecoder) createContractCallRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, call *ContractCall) error {
+
+	var (
+		accountID    = rawTx.Account.AccountID
+		symbol       = decoder.wm.Symbol()
+		coinDecimals = decoder.wm.Decimal()
+		usedUTXO     []*Unspent
+		tokenUTXO    []*Unspent
+		feeUTXO      []*Unspent
+		fees         = decimal.Zero
+		gas          = int64(0)
+		ins          = 1
+		outs         = 1 //找零输出
+		currencies   = 1
+		txFrom       = make([]string, 0)
+	)
+
+	if rawTx.Coin.IsContract {
+		coinDecimals = decoder.wm.CoinDecimals(rawTx.Coin)
+	}
+
+	amount, _ := decimal.NewFromString(call.Amount)
+	value := amount.Shift(coinDecimals)
+
+	//短地址解析为完整的合约地址，并确认目标地址上有合约代码
+	contract, err := decoder.wm.ResolveContractAddress(call.To)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "contract address: %s is invalid, %v", call.To, err)
+	}
+	call.Contract = contract
+
+	//获取当前最大高度
+	currentHeight, err := decoder.wm.GetBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	mainUnspents, err := decoder.wm.ListUnspent(accountID, symbol, 0, -1)
+	if err != nil {
+		return err
+	}
+	mainAvailable := decoder.availableUnspents(currentHeight, mainUnspents)
+
+	var tokenAvailable []*Unspent
+	if call.Currency != symbol && amount.GreaterThan(decimal.Zero) {
+		tokenUnspents, listErr := decoder.wm.ListUnspent(accountID, call.Currency, 0, -1)
+		if listErr != nil {
+			return listErr
+		}
+		tokenAvailable = decoder.availableUnspents(currentHeight, tokenUnspents)
+
+		//代币还需要代币找零
+		ins = ins + 1
+		outs = outs + 1
+		currencies = currencies + 1
+	}
+
+	//节点预估合约调用的汽油
+	callGas := call.Gas
+	if callGas == 0 {
+		from := ""
+		if len(mainAvailable) > 0 {
+			from = mainAvailable[0].Address
+		}
+		callGas, err = decoder.wm.EstimateContractGas(from, call.Contract, call.Currency, value, call.Data)
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "node estimate contract call gas failed, %v", err)
+		}
+	}
+
+	feesRate, _ := decimal.NewFromString(rawTx.FeeRate)
+
+	//按输入数量预估手续费，选择的utxo超过预估的输入数量时，重新预估并选择utxo
+	for {
+		fees, feesRate, gas, err = decoder.wm.EstimateContractTxFee(feesRate, callGas, NewTxGasParam(ins, outs, currencies))
+		if err != nil {
+			return err
+		}
+
+		need := fees
+		if call.Currency == symbol {
+			need = need.Add(amount)
+		}
+		feeUTXO, err = pickUnspents(mainAvailable, need, decoder.wm.Decimal())
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "[%s] %s balance is not enough to pay: %s(utxo meet 12 confirmations)", accountID, symbol, need.String())
+		}
+
+		tokenUTXO = nil
+		if tokenAvailable != nil {
+			tokenUTXO, err = pickUnspents(tokenAvailable, amount, coinDecimals)
+			if err != nil {
+				return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "[%s] %s balance is not enough to pay: %s(utxo meet 12 confirmations)", accountID, call.Currency, amount.String())
+			}
+		}
+
+		usedUTXO = append(append([]*Unspent{}, tokenUTXO...), feeUTXO...)
+		if len(usedUTXO) > MaxTxInputs {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "The transaction is use max inputs over: %d", MaxTxInputs)
+		}
+
+		if len(usedUTXO) <= ins {
+			break
+		}
+		ins = len(usedUTXO)
+	}
+
+	for _, u := range tokenUTXO {
+		txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, u.Value))
+	}
+	for _, u := range feeUTXO {
+		ua, _ := decimal.NewFromString(u.Value)
+		txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, ua.Shift(-decoder.wm.Decimal()).String()))
+	}
+
+	//找零地址
+	changeAddress, err := decoder.getChangeAddress(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	currencyID, err := decoder.wm.LocalCurrencyToId(call.Currency)
+	if err != nil {
+		return err
+	}
+
+	contractPKr, err := call.contractPKr()
+	if err != nil {
+		return err
+	}
+
+	cmds := &TxCmds{
+		Contract: &ContractCmd{
+			Asset: Asset{
+				Tkn: &Token{
+					Currency: currencyID,
+					Value:    value.String(),
+				},
+			},
+			To:   hexutil.Encode(contractPKr),
+			Data: call.Data,
+		},
+	}
+
+	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
+	rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("From Account: %s", accountID)
+	decoder.wm.Log.Std.Notice("Contract Address: %s", call.To)
+	decoder.wm.Log.Std.Notice("Call Data: %s", call.Data)
+	decoder.wm.Log.Std.Notice("Pay: %s %s", amount.String(), call.Currency)
+	decoder.wm.Log.Std.Notice("Call Gas: %v", callGas)
+	decoder.wm.Log.Std.Notice("Fees: %v", fees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Gas: %v", gas)
+	decoder.wm.Log.Std.Notice("Change Address: %v", changeAddress)
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	txStruct, err := decoder.wm.GenTxParamWithCmds(changeAddress, accountID, decoder.wm.Decimal(), feesRate, gas, usedUTXO, []Out_O{}, cmds)
+	if err != nil {
+		return err
+	}
+
+	rawTx.RawHex = txStruct.Raw
+
+	//记录交易结构概要，用于验证签名后的交易
+	err = decoder.setRawTransactionOutline(rawTx, txStruct, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	//装配签名
+	err = decoder.setRawTransactionSignatures(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	//记录找零输出
+	decoder.setRawTransactionChange(rawTx, changeAddress)
+
+	call.Gas = callGas
+	rawTx.SetExtParam(contractCallExtKey, call)
+
+	accountTotalSent := decimal.Zero.Sub(amount)
+	if rawTx.Coin.IsContract {
+		accountTotalSent = accountTotalSent.Shift(coinDecimals)
+	} else {
+		accountTotalSent = accountTotalSent.Sub(fees)
+	}
+
+	rawTx.IsBuilt = true
+	rawTx.TxAmount = accountTotalSent.StringFixed(decoder.wm.Decimal())
+	rawTx.TxFrom = txFrom
+	if rawTx.Coin.IsContract {
+		rawTx.TxTo = []string{fmt.Sprintf("%s:%s", call.To, value.String())}
+	} else {
+		rawTx.TxTo = []string{fmt.Sprintf("%s:%s", call.To, amount.String())}
+	}
+
+	return nil
+}
+
+//parseContractCall 解析链上交易的合约调用指令，不是合约调用返回nil，金额为最小单位，币种为Id
+func parseContractCall(trx *gjson.Result) *ContractCall {
+
+	cmd := trx.Get("Tx.Desc_Cmd.Contract")
+	if !cmd.IsObject() {
+		return nil
+	}
+
+	value, _ := parseHexOrNumber(cmd.Get("Asset.Tkn.Value"))
+
+	return &ContractCall{
+		To:       cmd.Get("To").String(),
+		Data:     cmd.Get("Data").String(),
+		Currency: cmd.Get("Asset.Tkn.Currency").String(),
+		Amount:   value.String(),
+	}
+}
+
+//verifyContractCall 签名后交易的合约调用必须与交易单一致
+func (decoder *TransactionDecoder) verifyContractCall(rawTx *openwallet.RawTransaction, signedTx *gjson.Result) error {
+
+	expected, err := decoder.getRawTransactionContractCall(rawTx)
+	if err != nil {
+		return err
+	}
+
+	actual := parseContractCall(signedTx)
+
+	if expected == nil {
+		if actual != nil {
+			return fmt.Errorf("signed transaction has unexpected contract call to: %s", actual.To)
+		}
+		return nil
+	}
+
+	if actual == nil {
+		return fmt.Errorf("signed transaction contract call is empty")
+	}
+
+	contractPKr, err := expected.contractPKr()
+	if err != nil {
+		return fmt.Errorf("transaction contract address: %s is not resolved, %v", expected.To, err)
+	}
+	if !strings.EqualFold(actual.To, hexutil.Encode(contractPKr)) {
+		return fmt.Errorf("signed transaction contract address: %s is not equal to: %s", hexToBase58(actual.To), expected.To)
+	}
+
+	if !strings.EqualFold(actual.Data, expected.Data) {
+		return fmt.Errorf("signed transaction contract call data is not equal to transaction")
+	}
+
+	currencyID, err := decoder.wm.LocalCurrencyToId(expected.Currency)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual.Currency, currencyID) {
+		return fmt.Errorf("signed transaction contract call currency: %s is not: %s", actual.Currency, expected.Currency)
+	}
+
+	coinDecimals := decoder.wm.Decimal()
+	if rawTx.Coin.IsContract {
+		coinDecimals = decoder.wm.CoinDecimals(rawTx.Coin)
+	}
+	amount, _ := decimal.NewFromString(expected.Amount)
+	actualValue, _ := decimal.NewFromString(actual.Amount)
+	if !actualValue.Equal(amount.Shift(coinDecimals)) {
+		return fmt.Errorf("signed transaction contract call value: %s is not equal to: %s", actualValue.String(), amount.Shift(coinDecimals).String())
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/hdkeystore"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/blocktree/sero-adapter/sero_addrdec"
+	"github.com/sero-cash/go-sero/common/hexutil"
+	"time"
+)
+
+const (
+	pkDestinationExtKey = "pkDestinations" //交易单ExtParam中记录PK收款地址派生的PKr，PKr -> PK
+)
+
+//PKDestination 收款地址为PK时，本地派生的一次性收款码PKr
+type PKDestination struct {
+	PKr       string `json:"pkr" storm:"id"`
+	PK        string `json:"pk" storm:"index"`
+	AccountID string `json:"accountID"` //付款账户
+	CreateAt  int64  `json:"createAt"`
+}
+
+//IsValidAddress 地址是否为有效的SERO地址（PKr、PK、合约地址或合约短地址）
+func (wm *WalletManager) IsValidAddress(address string) bool {
+	return sero_addrdec.IsValidAddress(address)
+}
+
+//validatePKr 验证收款地址是有效的收款码PKr
+func validatePKr(address string) error {
+
+	info, err := sero_addrdec.ParseAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if info.Type == sero_addrdec.AddressTypePK {
+		return fmt.Errorf("address is a PK, only single-currency transfer derives PKr for it, please use a PKr")
+	}
+
+	if info.Type != sero_addrdec.AddressTypePKr {
+		return fmt.Errorf("address type: %s can not receive transfer, PKr is required", info.Type)
+	}
+
+	return nil
+}
+
+//validateRawTransactionTo 创建交易单前验证收款地址，合约调用的收款地址由合约调用验证
+func (decoder *TransactionDecoder) validateRawTransactionTo(rawTx *openwallet.RawTransaction) error {
+
+	if len(rawTx.ExtParam) > 0 && rawTx.GetExtParam().Get(contractCallExtKey).Exists() {
+		return nil
+	}
+
+	for addr := range rawTx.To {
+		if err := validatePKr(addr); err != nil {
+			return openwallet.Errorf(openwallet.ErrAdressDecodeFailed, "receiver address: %s is invalid, %v", addr, err)
+		}
+	}
+
+	return nil
+}
+
+//SavePKDestination 记录PK派生的收款码
+func (wm *WalletManager) SavePKDestination(dest *PKDestination) error {
+	if dest == nil {
+		return fmt.Errorf("the pk destination to save is nil")
+	}
+	return wm.unspentDB.Save(dest)
+}
+
+//GetPKDestination 按收款码查询派生它的PK，没有记录返回nil
+func (wm *WalletManager) GetPKDestination(pkr string) (*PKDestination, error) {
+	var dest PKDestination
+	err := wm.unspentDB.One("PKr", pkr, &dest)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &dest, nil
+}
+
+//DerivePKr 用随机rnd从PK派生一次性收款码，节点同时检查PK是否为有效的曲线点
+func (wm *WalletManager) DerivePKr(pk string) (string, error) {
+
+	rnd, err := hdkeystore.GenerateSeed(32)
+	if err != nil {
+		return "", err
+	}
+
+	pkr, err := wm.LocalPk2Pkr(pk, hexutil.Encode(rnd))
+	if err != nil {
+		return "", err
+	}
+
+	if !sero_addrdec.IsValidPKr(pkr) {
+		return "", fmt.Errorf("derived PKr: %s is invalid", pkr)
+	}
+
+	return pkr, nil
+}
+
+//resolvePKDestinations 收款地址为PK时派生一次性收款码替换它，备注随地址替换，
+//PKr与PK的对应关系记录到交易单ExtParam，并返回待保存的记录，交易单创建成功后再调用savePKDestinations保存，合约调用不处理
+func (decoder *TransactionDecoder) resolvePKDestinations(rawTx *openwallet.RawTransaction) ([]*PKDestination, error) {
+
+	if len(rawTx.ExtParam) > 0 && rawTx.GetExtParam().Get(contractCallExtKey).Exists() {
+		return nil, nil
+	}
+
+	pkDestinations := decoder.getRawTransactionPKDestinations(rawTx)
+	memos := make(map[string]string)
+	memoParam := rawTx.GetExtParam().Get(memoExtKey)
+	if memoParam.IsObject() {
+		for addr, memo := range memoParam.Map() {
+			memos[addr] = memo.String()
+		}
+	}
+
+	to := make(map[string]string, len(rawTx.To))
+	resolved := make([]*PKDestination, 0)
+	for addr, amount := range rawTx.To {
+		info, err := sero_addrdec.ParseAddress(addr)
+		if err != nil || info.Type != sero_addrdec.AddressTypePK {
+			to[addr] = amount
+			continue
+		}
+
+		pkr, err := decoder.wm.DerivePKr(addr)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrAdressDecodeFailed, "receiver PK: %s can not derive PKr, %v", addr, err)
+		}
+
+		to[pkr] = amount
+		if memo, ok := memos[addr]; ok {
+			delete(memos, addr)
+			memos[pkr] = memo
+		}
+		pkDestinations[pkr] = addr
+		resolved = append(resolved, &PKDestination{
+			PKr:       pkr,
+			PK:        addr,
+			AccountID: rawTx.Account.AccountID,
+			CreateAt:  time.Now().Unix(),
+		})
+
+		decoder.wm.Log.Infof("receiver PK: %s use derived PKr: %s", addr, pkr)
+	}
+
+	if len(resolved) == 0 {
+		return nil, nil
+	}
+
+	rawTx.To = to
+	if memoParam.IsObject() {
+		decoder.setRawTransactionMemos(rawTx, memos)
+	}
+
+	err := rawTx.SetExtParam(pkDestinationExtKey, pkDestinations)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolved, nil
+}
+
+//savePKDestinations 交易单创建成功后保存PK派生的收款码记录
+func (decoder *TransactionDecoder) savePKDestinations(destinations []*PKDestination) error {
+	for _, dest := range destinations {
+		err := decoder.wm.SavePKDestination(dest)
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "save pk destination: %s failed, %v", dest.PKr, err)
+		}
+	}
+	return nil
+}
+
+//getRawTransactionPKDestinations 读取交易单中PKr与PK的对应关系
+func (decoder *TransactionDecoder) getRawTransactionPKDestinations(rawTx *openwallet.RawTransaction) map[string]string {
+
+	pkDestinations := make(map[string]string)
+	if len(rawTx.ExtParam) == 0 {
+		return pkDestinations
+	}
+
+	for pkr, pk := range rawTx.GetExtParam().Get(pkDestinationExtKey).Map() {
+		pkDestinations[pkr] = pk.String()
+	}
+
+	return pkDestinations
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/blocktree/sero-adapter/client"
+	"github.com/blocktree/sero-adapter/sero_addrdec"
+	"github.com/mr-tron/base58"
+	"github.com/sero-cash/go-sero/common/hexutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//testPK 测试收款码对应的PK
+func testPK() string {
+	pkr, _ := base58.Decode(testPKr1)
+	return base58.Encode(pkr[:sero_addrdec.PKLength])
+}
+
+//testPk2PkrServer 模拟节点的local_pk2Pkr，收款码为PK拼接rnd，invalid为true时返回合约地址，
+//local_tk2Pk返回testPK
+func testPk2PkrServer(invalid bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Method string   `json:"method"`
+			Params []string `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Method == "local_tk2Pk" {
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":"1","result":"%s"}`, testPK())
+			return
+		}
+		pk, _ := base58.Decode(body.Params[0])
+		rnd, _ := hexutil.Decode(body.Params[1])
+		if invalid {
+			rnd = make([]byte, len(rnd))
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":"1","result":"%s"}`, base58.Encode(append(pk, rnd...)))
+	}))
+}
+
+func TestValidatePKr(t *testing.T) {
+	pkr, _ := base58.Decode(testPKr1)
+	contract := base58.Encode(append(append([]byte{}, pkr[:sero_addrdec.PKLength]...), make([]byte, sero_addrdec.PKrLength-sero_addrdec.PKLength)...))
+
+	tests := []struct {
+		name    string
+		address string
+		wantErr bool
+	}{
+		{name: "pkr", address: testPKr1},
+		{name: "pk", address: testPK(), wantErr: true},
+		{name: "contract", address: contract, wantErr: true},
+		{name: "short address", address: "0x5a9f1b2c3d4e5f60718293a4b5c6d7e8f9012345", wantErr: true},
+		{name: "invalid", address: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePKr(tt.address); (err != nil) != tt.wantErr {
+				t.Errorf("validatePKr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransactionDecoder_resolvePKDestinations(t *testing.T) {
+
+	pk := testPK()
+
+	tests := []struct {
+		name         string
+		to           map[string]string
+		memos        map[string]string
+		contractCall bool
+		invalidPKr   bool
+		wantResolved int
+		wantErr      bool
+	}{
+		{name: "pkr unchanged", to: map[string]string{testPKr1: "1"}},
+		{name: "pk", to: map[string]string{pk: "1", testPKr1: "2"}, memos: map[string]string{pk: "a", testPKr1: "b"}, wantResolved: 1},
+		{name: "contract call unchanged", to: map[string]string{pk: "1"}, contractCall: true},
+		{name: "derived pkr invalid", to: map[string]string{pk: "1"}, invalidPKr: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			wm, cleanup := testUnspentDBWalletManager(t)
+			defer cleanup()
+			server := testPk2PkrServer(tt.invalidPKr)
+			defer server.Close()
+			wm.WalletClient = client.NewClient(server.URL, false)
+			decoder := NewTransactionDecoder(wm)
+
+			rawTx := &openwallet.RawTransaction{
+				Account: &openwallet.AssetsAccount{AccountID: "tk"},
+				To:      make(map[string]string),
+			}
+			for addr, amount := range tt.to {
+				rawTx.To[addr] = amount
+			}
+			if len(tt.memos) > 0 {
+				rawTx.SetExtParam(memoExtKey, tt.memos)
+			}
+			if tt.contractCall {
+				rawTx.SetExtParam(contractCallExtKey, map[string]string{"contract": "0x01"})
+			}
+
+			resolved, err := decoder.resolvePKDestinations(rawTx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolvePKDestinations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(resolved) != tt.wantResolved {
+				t.Fatalf("resolvePKDestinations() resolved = %d, want %d", len(resolved), tt.wantResolved)
+			}
+
+			//交易单创建成功前不保存记录
+			for _, dest := range resolved {
+				if saved, _ := wm.GetPKDestination(dest.PKr); saved != nil {
+					t.Errorf("resolvePKDestinations() saved %s before the transaction is created", dest.PKr)
+				}
+			}
+			err = decoder.savePKDestinations(resolved)
+			if err != nil {
+				t.Fatalf("savePKDestinations() error = %v", err)
+			}
+
+			if len(rawTx.To) != len(tt.to) {
+				t.Fatalf("resolvePKDestinations() to = %v, want %d receivers", rawTx.To, len(tt.to))
+			}
+
+			pkDestinations := decoder.getRawTransactionPKDestinations(rawTx)
+			if len(pkDestinations) != tt.wantResolved {
+				t.Fatalf("resolvePKDestinations() mapping = %v, want %d", pkDestinations, tt.wantResolved)
+			}
+
+			memos := rawTx.GetExtParam().Get(memoExtKey)
+			for pkr, fromPK := range pkDestinations {
+				if fromPK != pk || !sero_addrdec.IsValidPKr(pkr) {
+					t.Errorf("resolvePKDestinations() mapping %s -> %s", pkr, fromPK)
+				}
+				if rawTx.To[pkr] != tt.to[pk] {
+					t.Errorf("resolvePKDestinations() amount of %s = %s, want %s", pkr, rawTx.To[pkr], tt.to[pk])
+				}
+				if memo := memos.Get(pkr).String(); memo != tt.memos[pk] {
+					t.Errorf("resolvePKDestinations() memo of %s = %s, want %s", pkr, memo, tt.memos[pk])
+				}
+				dest, err := wm.GetPKDestination(pkr)
+				if err != nil || dest == nil || dest.PK != pk || dest.AccountID != "tk" {
+					t.Errorf("GetPKDestination() = %+v, error = %v", dest, err)
+				}
+			}
+
+			//收款码和其他地址保持不变
+			for addr, amount := range tt.to {
+				if addr == pk && tt.wantResolved > 0 {
+					if _, ok := rawTx.To[pk]; ok {
+						t.Errorf("resolvePKDestinations() PK is still a receiver")
+					}
+					continue
+				}
+				if rawTx.To[addr] != amount {
+					t.Errorf("resolvePKDestinations() amount of %s = %s, want %s", addr, rawTx.To[addr], amount)
+				}
+			}
+		})
+	}
+}
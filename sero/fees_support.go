@@ -0,0 +1,138 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//selectFeeUnspents 选择支付手续费的SERO utxo，可以组合多个utxo，按手续费utxo的数量重新预估手续费。
+//ins为手续费以外的输入数量，失败时也返回预估的手续费。
+func (decoder *TransactionDecoder) selectFeeUnspents(
+	feesRate decimal.Decimal,
+	ins, outs, currencies int,
+	feeUnspents []*Unspent) ([]*Unspent, decimal.Decimal, decimal.Decimal, int64, error) {
+
+	var (
+		feeUsed []*Unspent
+		fees    decimal.Decimal
+		rate    decimal.Decimal
+		gas     int64
+		err     error
+		feeIns  = 1
+	)
+
+	for {
+		fees, rate, gas, err = decoder.wm.EstimateTxFee(feesRate, NewTxGasParam(ins+feeIns, outs, currencies))
+		if err != nil {
+			return nil, fees, rate, gas, err
+		}
+		feeUsed, _, err = decoder.takeUnspentsSatisfyAmount(feeUnspents, fees, decoder.wm.Decimal())
+		if err != nil {
+			return nil, fees, rate, gas, err
+		}
+		if ins+len(feeUsed) > MaxTxInputs {
+			return nil, fees, rate, gas, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "The transaction is use max inputs over: %d", MaxTxInputs)
+		}
+		if len(feeUsed) <= feeIns {
+			return feeUsed, fees, rate, gas, nil
+		}
+		feeIns = len(feeUsed)
+	}
+}
+
+//isInsufficientFees 是否手续费不足的错误
+func isInsufficientFees(err error) bool {
+	owErr, ok := err.(*openwallet.Error)
+	return ok && owErr.Code() == openwallet.ErrInsufficientFees
+}
+
+//waitingFeesSupport 账户是否有未确认的SERO utxo足够支付手续费，有则等待确认，不再创建手续费支持交易单
+func (decoder *TransactionDecoder) waitingFeesSupport(accountID string, currentHeight uint64, fees decimal.Decimal) bool {
+
+	unspents, err := decoder.wm.ListUnspent(accountID, decoder.wm.Symbol(), 0, -1)
+	if err != nil {
+		return false
+	}
+
+	waiting := make([]*Unspent, 0)
+	for _, u := range unspents {
+		if !u.Sending && currentHeight-u.Height <= MinConfirms {
+			waiting = append(waiting, u)
+		}
+	}
+
+	return summaryBalance(waiting, decoder.wm.Decimal()).GreaterThanOrEqual(fees)
+}
+
+//createFeesSupportRawTransaction 通过手续费支持账户向汇总账户的地址转入SERO，utxo确认后再汇总代币
+func (decoder *TransactionDecoder) createFeesSupportRawTransaction(
+	wrapper openwallet.WalletDAI,
+	sumRawTx *openwallet.SummaryRawTransaction,
+	supportAddress string,
+	fees decimal.Decimal) (*openwallet.RawTransactionWithError, error) {
+
+	feesSupportAccount, err := wrapper.GetAssetsAccountInfo(sumRawTx.FeesSupportAccount.AccountID)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrAccountNotFound, "can not find fees support account")
+	}
+
+	if feesSupportAccount.AccountID == sumRawTx.Account.AccountID {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "fees support account can not be the summary account")
+	}
+
+	supportAmount := fees
+	feesSupportScale, _ := decimal.NewFromString(sumRawTx.FeesSupportAccount.FeesSupportScale)
+	fixSupportAmount, _ := decimal.NewFromString(sumRawTx.FeesSupportAccount.FixSupportAmount)
+
+	//优先采用固定支持数量，其次按手续费倍率，默认支持数量为手续费
+	if fixSupportAmount.GreaterThan(decimal.Zero) {
+		supportAmount = fixSupportAmount
+	} else if feesSupportScale.GreaterThan(decimal.Zero) {
+		supportAmount = feesSupportScale.Mul(fees)
+	}
+
+	if supportAmount.LessThan(fees) {
+		supportAmount = fees
+	}
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("Fees Support Account: %s", feesSupportAccount.AccountID)
+	decoder.wm.Log.Std.Notice("Summary Account: %s", sumRawTx.Account.AccountID)
+	decoder.wm.Log.Std.Notice("Support Address: %s", supportAddress)
+	decoder.wm.Log.Std.Notice("Mini Support Amount: %s", fees.String())
+	decoder.wm.Log.Std.Notice("Support Amount: %s", supportAmount.String())
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	rawTx := &openwallet.RawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     decoder.wm.Symbol(),
+			IsContract: false,
+		},
+		Account:  feesSupportAccount,
+		FeeRate:  sumRawTx.FeeRate,
+		To:       map[string]string{supportAddress: supportAmount.StringFixed(decoder.wm.Decimal())},
+		Required: 1,
+	}
+
+	createErr := decoder.CreateRawTransaction(wrapper, rawTx)
+
+	return &openwallet.RawTransactionWithError{
+		RawTx: rawTx,
+		Error: openwallet.ConvertError(createErr),
+	}, nil
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/sero-cash/go-sero/common/hexutil"
+	"github.com/shopspring/decimal"
+	"math/big"
+)
+
+const (
+	GasEstimateModeFixed = "fixed" //使用配置的固定汽油fixGas
+	GasEstimateModeLocal = "local" //使用本地模型，按输入输出数量计算
+	GasEstimateModeNode  = "node"  //使用节点sero_estimateGas预估的基础汽油，再按输入输出数量计算
+)
+
+//TxGasParam 预估汽油的交易结构参数
+type TxGasParam struct {
+	Ins        int //输入数量
+	Outs       int //公开输出数量
+	ZOuts      int //匿名输出数量，收款码PKr的输出都是匿名输出
+	Currencies int //交易涉及的币种数量
+}
+
+//NewTxGasParam 创建交易结构参数，outs为收款码PKr的输出，都按匿名输出计算
+func NewTxGasParam(ins, outs, currencies int) *TxGasParam {
+	return &TxGasParam{
+		Ins:        ins,
+		ZOuts:      outs,
+		Currencies: currencies,
+	}
+}
+
+//EstimateGas 预估交易消耗的汽油
+func (wm *WalletManager) EstimateGas(param *TxGasParam) (int64, error) {
+
+	if param == nil || wm.Config.GasEstimateMode == GasEstimateModeFixed {
+		return wm.Config.FixGas, nil
+	}
+
+	base := wm.Config.GasBase
+	if wm.Config.GasEstimateMode == GasEstimateModeNode {
+		nodeGas, err := wm.NodeEstimateGas()
+		if err != nil {
+			return 0, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "node estimate gas failed, %v", err)
+		}
+		base = nodeGas
+	}
+
+	return wm.estimateGasWithBase(base, param)
+}
+
+//NodeEstimateGas 节点预估一笔普通交易的基础汽油
+func (wm *WalletManager) NodeEstimateGas() (int64, error) {
+	request := []interface{}{
+		map[string]interface{}{},
+	}
+	result, err := wm.WalletClient.Call("sero_estimateGas", request)
+	if err != nil {
+		return 0, err
+	}
+	gas, err := hexutil.DecodeUint64(result.String())
+	if err != nil {
+		return 0, err
+	}
+	return int64(gas), nil
+}
+
+//estimateGasWithBase 在基础汽油上按输入输出数量计算，加上安全余量，超过上限时返回错误
+func (wm *WalletManager) estimateGasWithBase(base int64, param *TxGasParam) (int64, error) {
+
+	gas := decimal.New(base, 0)
+	gas = gas.Add(decimal.New(wm.Config.GasPerInput*int64(param.Ins), 0))
+	gas = gas.Add(decimal.New(wm.Config.GasPerOutput*int64(param.Outs+param.ZOuts), 0))
+	gas = gas.Add(decimal.New(wm.Config.GasPerZOutput*int64(param.ZOuts), 0))
+	gas = gas.Add(decimal.New(wm.Config.GasPerCurrency*int64(param.Currencies), 0))
+
+	//安全余量
+	margin, _ := decimal.NewFromString(wm.Config.GasSafetyMargin)
+	if margin.GreaterThan(decimal.Zero) {
+		gas = gas.Mul(decimal.New(1, 0).Add(margin))
+	}
+
+	estimate := gas.Ceil().IntPart()
+
+	//不低于固定汽油
+	if estimate < wm.Config.FixGas {
+		estimate = wm.Config.FixGas
+	}
+
+	//超过上限
+	if wm.Config.MaxGas > 0 && estimate > wm.Config.MaxGas {
+		return 0, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "estimate gas: %d is over max gas: %d", estimate, wm.Config.MaxGas)
+	}
+
+	return estimate, nil
+}
+
+//EstimateTxFee 按交易结构预估手续费，返回手续费，费率，汽油
+func (wm *WalletManager) EstimateTxFee(feeRate decimal.Decimal, param *TxGasParam) (decimal.Decimal, decimal.Decimal, int64, error) {
+
+	feeRate, err := wm.EstimateFeeRate(feeRate)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, 0, err
+	}
+
+	gas, err := wm.EstimateGas(param)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, 0, err
+	}
+
+	//fees = gasPrice * gas
+	fees := feeRate.Mul(decimal.New(gas, 0))
+
+	return fees, feeRate, gas, nil
+}
+
+//EstimateContractGas 节点预估合约调用的汽油，value为最小单位
+func (wm *WalletManager) EstimateContractGas(from, to, currency string, value decimal.Decimal, data string) (int64, error) {
+	amount, _ := new(big.Int).SetString(value.StringFixed(0), 10)
+	args := map[string]interface{}{
+		"to":       to,
+		"currency": currency,
+		"value":    hexutil.EncodeBig(amount),
+		"data":     data,
+	}
+	if len(from) > 0 {
+		args["from"] = from
+	}
+	request := []interface{}{
+		args,
+	}
+	result, err := wm.WalletClient.Call("sero_estimateGas", request)
+	if err != nil {
+		return 0, err
+	}
+	gas, err := hexutil.DecodeUint64(result.String())
+	if err != nil {
+		return 0, err
+	}
+	return int64(gas), nil
+}
+
+//EstimateContractTxFee 以合约调用的汽油为基础，按交易结构预估手续费，返回手续费，费率，汽油
+func (wm *WalletManager) EstimateContractTxFee(feeRate decimal.Decimal, callGas int64, param *TxGasParam) (decimal.Decimal, decimal.Decimal, int64, error) {
+
+	feeRate, err := wm.EstimateFeeRate(feeRate)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, 0, err
+	}
+
+	gas, err := wm.estimateGasWithBase(callGas, param)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, 0, err
+	}
+
+	//fees = gasPrice * gas
+	fees := feeRate.Mul(decimal.New(gas, 0))
+
+	return fees, feeRate, gas, nil
+}
+
+//EstimateFeeRate 费率为0时，使用节点的汽油价格
+func (wm *WalletManager) EstimateFeeRate(feeRate decimal.Decimal) (decimal.Decimal, error) {
+
+	if feeRate.GreaterThan(decimal.Zero) {
+		return feeRate, nil
+	}
+
+	gasPrice, err := wm.GasPrice()
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	feeRate = decimal.NewFromBigInt(gasPrice, 0)
+	feeRate = feeRate.Shift(-wm.Decimal())
+
+	return feeRate, nil
+}
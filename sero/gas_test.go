@@ -0,0 +1,99 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+//testGasWalletManager 使用默认本地模型参数的钱包管理器
+func testGasWalletManager(mode string, fixGas, maxGas int64, margin string) *WalletManager {
+	wm := NewWalletManager()
+	wm.Config.GasEstimateMode = mode
+	wm.Config.FixGas = fixGas
+	wm.Config.MaxGas = maxGas
+	wm.Config.GasSafetyMargin = margin
+	return wm
+}
+
+func TestWalletManager_EstimateGas(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		fixGas  int64
+		maxGas  int64
+		margin  string
+		param   *TxGasParam
+		want    int64
+		wantErr bool
+	}{
+		{name: "default is fixed", mode: NewConfig(Symbol).GasEstimateMode, fixGas: 25000, margin: "0", param: NewTxGasParam(100, 10, 2), want: 25000},
+		{name: "fixed", mode: GasEstimateModeFixed, fixGas: 25000, margin: "0", param: NewTxGasParam(100, 10, 2), want: 25000},
+		{name: "nil param", mode: GasEstimateModeLocal, fixGas: 25000, margin: "0", param: nil, want: 25000},
+		//25000 + 500*2 + 1500*2 + 1000 = 30000
+		{name: "local", mode: GasEstimateModeLocal, fixGas: 0, margin: "0", param: NewTxGasParam(2, 2, 1), want: 30000},
+		//30000 * 1.1
+		{name: "local with margin", mode: GasEstimateModeLocal, fixGas: 0, margin: "0.1", param: NewTxGasParam(2, 2, 1), want: 33000},
+		//25000 + 500 + 1500 + 1000 = 28000，不低于固定汽油
+		{name: "local floor to fixGas", mode: GasEstimateModeLocal, fixGas: 30000, margin: "0", param: NewTxGasParam(1, 1, 1), want: 30000},
+		//公开输出不加匿名输出的汽油
+		{name: "local public outputs", mode: GasEstimateModeLocal, fixGas: 0, margin: "0", param: &TxGasParam{Ins: 1, Outs: 2, Currencies: 1}, want: 27500},
+		//25000 + 500*200 + 1500*2 + 1000 = 129000，超过上限
+		{name: "local over maxGas", mode: GasEstimateModeLocal, fixGas: 25000, maxGas: 100000, margin: "0", param: NewTxGasParam(200, 2, 1), wantErr: true},
+		{name: "local under maxGas", mode: GasEstimateModeLocal, fixGas: 0, maxGas: 100000, margin: "0", param: NewTxGasParam(2, 2, 1), want: 30000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wm := testGasWalletManager(tt.mode, tt.fixGas, tt.maxGas, tt.margin)
+			got, err := wm.EstimateGas(tt.param)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EstimateGas() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("EstimateGas() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWalletManager_EstimateTxFee(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		feeRate string
+		param   *TxGasParam
+		wantGas int64
+		want    string
+	}{
+		{name: "fixed", mode: GasEstimateModeFixed, feeRate: "0.000000001", param: NewTxGasParam(1, 2, 1), wantGas: 25000, want: "0.000025"},
+		{name: "local", mode: GasEstimateModeLocal, feeRate: "0.000000001", param: NewTxGasParam(2, 2, 1), wantGas: 30000, want: "0.00003"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wm := testGasWalletManager(tt.mode, 25000, 0, "0")
+			feeRate, _ := decimal.NewFromString(tt.feeRate)
+			fees, gotRate, gas, err := wm.EstimateTxFee(feeRate, tt.param)
+			if err != nil {
+				t.Fatalf("EstimateTxFee() error = %v", err)
+			}
+			want, _ := decimal.NewFromString(tt.want)
+			if gas != tt.wantGas || !fees.Equal(want) || !gotRate.Equal(feeRate) {
+				t.Errorf("EstimateTxFee() = %v, %v, %v, want %v, %v, %v", fees, gotRate, gas, want, feeRate, tt.wantGas)
+			}
+		})
+	}
+}
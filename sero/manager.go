@@ -25,7 +25,6 @@ import (
 	"github.com/blocktree/openwallet/openwallet"
 	"github.com/blocktree/sero-adapter/client"
 	"github.com/blocktree/sero-adapter/sero_addrdec"
-	"github.com/mr-tron/base58"
 	"github.com/sero-cash/go-sero/common/hexutil"
 	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
@@ -41,6 +40,7 @@ type WalletManager struct {
 	ContractDecoder openwallet.SmartContractDecoder //智能合约解析器
 	Blockscanner    *SEROBlockScanner               //区块扫描器
 	WalletClient    *client.Client                  // 节点客户端
+	TxTracker       *TxStatusTracker                //交易状态跟踪器
 	unspentDB       *storm.DB                       //未花记录数据库
 	blockChainDB    *storm.DB                       //区块链数据库
 }
@@ -53,6 +53,7 @@ func NewWalletManager() *WalletManager {
 	wm.TxDecoder = NewTransactionDecoder(&wm)
 	wm.Log = log.NewOWLogger(wm.Symbol())
 	wm.ContractDecoder = NewContractDecoder(&wm)
+	wm.TxTracker = NewTxStatusTracker(&wm)
 
 	return &wm
 }
@@ -251,6 +252,21 @@ func (wm *WalletManager) GetTransactionByHash(txid string) (*gjson.Result, error
 	return result, nil
 }
 
+//GetTransactionReceipt 获取交易回执
+func (wm *WalletManager) GetTransactionReceipt(txid string) (*gjson.Result, error) {
+
+	request := []interface{}{
+		txid,
+	}
+
+	result, err := wm.WalletClient.Call("sero_getTransactionReceipt", request)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 func (wm *WalletManager) GetOut(root string) (*Out, error) {
 
 	request := []interface{}{
@@ -361,35 +377,41 @@ func (wm *WalletManager) ListUnspent(tk string, currency string, offset, limit i
 	return utxo, nil
 }
 
-//EstimateFee 预估手续费
-func (wm *WalletManager) EstimateFee(feeRate decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
-
-	var (
-		fees = decimal.Zero
-	)
+// ListAccountUnspent 账户所有币种的未花记录
+func (wm *WalletManager) ListAccountUnspent(tk string) ([]*Unspent, error) {
 
-	if feeRate.LessThanOrEqual(decimal.Zero) {
-		gasPrice, err := wm.GasPrice()
-		if err != nil {
-			return fees, decimal.Zero, err
-		}
+	var utxo []*Unspent
 
-		feeRate = decimal.NewFromBigInt(gasPrice, 0)
-		feeRate = feeRate.Shift(-wm.Decimal())
+	err := wm.unspentDB.Find("TK", tk, &utxo)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
 	}
 
-	//fees = gasPrice * fixGas
-	fees = feeRate.Mul(decimal.New(wm.Config.FixGas, 0))
+	return utxo, nil
+}
 
-	return fees, feeRate, nil
+//EstimateFee 预估一笔普通交易（1个输入，2个输出）的手续费
+func (wm *WalletManager) EstimateFee(feeRate decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	fees, feeRate, _, err := wm.EstimateTxFee(feeRate, NewTxGasParam(1, 2, 1))
+	return fees, feeRate, err
 }
 
 // GenTxParam 构建交易
 func (wm *WalletManager) GenTxParam(
 	from, tk string,
-	decimals int32, feesRate decimal.Decimal,
+	decimals int32, feesRate decimal.Decimal, gas int64,
 	usedUTXO []*Unspent,
 	to []Out_O) (*gjson.Result, error) {
+	return wm.GenTxParamWithCmds(from, tk, decimals, feesRate, gas, usedUTXO, to, nil)
+}
+
+// GenTxParamWithCmds 构建带指令的交易，如质押的购买股份、注册和关闭矿池
+func (wm *WalletManager) GenTxParamWithCmds(
+	from, tk string,
+	decimals int32, feesRate decimal.Decimal, gas int64,
+	usedUTXO []*Unspent,
+	to []Out_O,
+	cmds *TxCmds) (*gjson.Result, error) {
 
 	/*
 		"params": [{    //参数1：预组装交易结构
@@ -417,13 +439,20 @@ func (wm *WalletManager) GenTxParam(
 		Value    string `json:"Value"`
 	}
 
+	type Tkt struct {
+		Category string `json:"Category"`
+		Value    string `json:"Value"`
+	}
+
 	type Asset struct {
-		Tkn Tkn `json:"Tkn"`
+		Tkn *Tkn `json:"Tkn,omitempty"`
+		Tkt *Tkt `json:"Tkt,omitempty"`
 	}
 
 	type Out struct {
 		Asset Asset  `json:"Asset"`
 		PKr   string `json:"PKr"`
+		Memo  string `json:"Memo,omitempty"`
 	}
 
 	ins := make([]string, 0)
@@ -433,34 +462,64 @@ func (wm *WalletManager) GenTxParam(
 
 	outs := make([]interface{}, 0)
 	for _, output := range to {
-		pkr, _ := base58.Decode(output.Addr)
-
-		currencyID, err := wm.LocalCurrencyToId(output.Asset.Tkn.Currency)
+		pkr, err := wm.Decoder.AddressDecode(output.Addr)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("output address: %s is invalid, %v", output.Addr, err)
 		}
 
 		out := Out{
-			Asset: Asset{
-				Tkn: Tkn{
-					Currency: currencyID,
-					Value:    output.Asset.Tkn.Value,
-				},
-			},
 			PKr: hexutil.Encode(pkr),
 		}
+
+		if output.Asset.Tkn != nil {
+			currencyID, err := wm.LocalCurrencyToId(output.Asset.Tkn.Currency)
+			if err != nil {
+				return nil, err
+			}
+			out.Asset.Tkn = &Tkn{
+				Currency: currencyID,
+				Value:    output.Asset.Tkn.Value,
+			}
+		}
+
+		//票据的类别转为Id，票据hash原样传入
+		if output.Asset.Tkt != nil {
+			categoryID, err := wm.LocalCurrencyToId(output.Asset.Tkt.Category)
+			if err != nil {
+				return nil, err
+			}
+			out.Asset.Tkt = &Tkt{
+				Category: categoryID,
+				Value:    output.Asset.Tkt.Value,
+			}
+		}
+
+		if len(output.Memo) > 0 {
+			memo, err := EncodeMemo(output.Memo)
+			if err != nil {
+				return nil, err
+			}
+			out.Memo = memo
+		}
+
 		outs = append(outs, out)
 	}
 
-	fromHex, _ := base58.Decode(from)
+	fromHex, err := wm.Decoder.AddressDecode(from)
+	if err != nil {
+		return nil, fmt.Errorf("change address: %s is invalid, %v", from, err)
+	}
 	gasPrice := feesRate.Shift(wm.Decimal()).IntPart()
 	payload := map[string]interface{}{
 		"From":     hexutil.Encode(fromHex),
-		"Gas":      wm.Config.FixGas,
+		"Gas":      gas,
 		"GasPrice": gasPrice,
 		"Ins":      ins,
 		"Outs":     outs,
 	}
+	if cmds != nil {
+		payload["Cmds"] = cmds
+	}
 
 	request := []interface{}{
 		payload,
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/sero-cash/go-sero/common/hexutil"
+	"unicode/utf8"
+)
+
+const (
+	MaxMemoLength = 64     //备注最大字节数，对应Uint512
+	memoExtKey    = "memo" //交易单ExtParam中记录备注的字段
+)
+
+//ValidateMemo 检查备注是否可以编码到输出中
+func ValidateMemo(memo string) error {
+	if len(memo) > MaxMemoLength {
+		return fmt.Errorf("memo length: %d is over max length: %d", len(memo), MaxMemoLength)
+	}
+	if !utf8.ValidString(memo) {
+		return fmt.Errorf("memo is not valid utf-8 string")
+	}
+	if bytes.IndexByte([]byte(memo), 0) >= 0 {
+		return fmt.Errorf("memo can not contain zero byte")
+	}
+	return nil
+}
+
+//EncodeMemo 备注编码为64字节的hex，不足补0
+func EncodeMemo(memo string) (string, error) {
+	if err := ValidateMemo(memo); err != nil {
+		return "", err
+	}
+	var data Uint512
+	copy(data[:], memo)
+	return hexutil.Encode(data[:]), nil
+}
+
+//DecodeMemo 解码输出中的备注，去掉末尾补的0。
+//不是合法的utf-8字符串时，返回原hex。
+func DecodeMemo(memoHex string) string {
+	if len(memoHex) == 0 {
+		return ""
+	}
+	data, err := hexutil.Decode(memoHex)
+	if err != nil {
+		return ""
+	}
+	data = bytes.TrimRight(data, "\x00")
+	if len(data) == 0 {
+		return ""
+	}
+	if !utf8.Valid(data) {
+		return memoHex
+	}
+	return string(data)
+}
+
+//getRawTransactionMemos 解析交易单ExtParam中的备注。
+//memo为字符串时，所有目标地址使用同一备注；为对象时，按地址指定备注。
+func (decoder *TransactionDecoder) getRawTransactionMemos(rawTx *openwallet.RawTransaction) (map[string]string, error) {
+
+	memos := make(map[string]string)
+
+	if len(rawTx.ExtParam) == 0 {
+		return memos, nil
+	}
+
+	memoParam := rawTx.GetExtParam().Get(memoExtKey)
+	if !memoParam.Exists() {
+		return memos, nil
+	}
+
+	if memoParam.IsObject() {
+		for addr, memo := range memoParam.Map() {
+			if _, exist := rawTx.To[addr]; !exist {
+				return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "memo address: %s is not in receivers", addr)
+			}
+			memos[addr] = memo.String()
+		}
+	} else {
+		for addr := range rawTx.To {
+			memos[addr] = memoParam.String()
+		}
+	}
+
+	for addr, memo := range memos {
+		if err := ValidateMemo(memo); err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "address: %s memo is invalid, %v", addr, err)
+		}
+	}
+
+	return memos, nil
+}
+
+//setRawTransactionMemos 把各地址的备注记录到交易单ExtParam中
+func (decoder *TransactionDecoder) setRawTransactionMemos(rawTx *openwallet.RawTransaction, memos map[string]string) {
+	if len(memos) == 0 {
+		return
+	}
+	rawTx.SetExtParam(memoExtKey, memos)
+}
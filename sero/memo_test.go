@@ -0,0 +1,174 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"github.com/blocktree/openwallet/openwallet"
+	"strings"
+	"testing"
+)
+
+const (
+	testPKr1 = "7EHTPNYhKNuULtwQEgFK3NuYbf3qAGNoowRHo5BHZij3mdB7WJxZ4oRJt91HbVL88pxDmBV159MsTjiwzRMD7FgqideToxcNK63VPU7LJ9ff37kJ38Yx41cSBXgdAhFRwJy"
+)
+
+func TestValidateMemo(t *testing.T) {
+	tests := []struct {
+		name    string
+		memo    string
+		wantErr bool
+	}{
+		{name: "empty", memo: ""},
+		{name: "ascii", memo: "order-10086"},
+		{name: "utf-8", memo: "充值备注"},
+		{name: "max length", memo: strings.Repeat("a", MaxMemoLength)},
+		{name: "over max length", memo: strings.Repeat("a", MaxMemoLength+1), wantErr: true},
+		{name: "utf-8 over max length", memo: strings.Repeat("备", 22), wantErr: true},
+		{name: "invalid utf-8", memo: string([]byte{0xff, 0xfe}), wantErr: true},
+		{name: "zero byte", memo: "a\x00b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateMemo(tt.memo); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateMemo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncodeMemo(t *testing.T) {
+	tests := []struct {
+		name    string
+		memo    string
+		wantErr bool
+	}{
+		{name: "empty", memo: ""},
+		{name: "ascii", memo: "hello sero"},
+		{name: "utf-8", memo: "充值备注"},
+		{name: "max length", memo: strings.Repeat("z", MaxMemoLength)},
+		{name: "over max length", memo: strings.Repeat("z", MaxMemoLength+1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := EncodeMemo(tt.memo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EncodeMemo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			//0x + 64字节hex
+			if len(encoded) != 2+MaxMemoLength*2 {
+				t.Errorf("EncodeMemo() length = %d, want %d", len(encoded), 2+MaxMemoLength*2)
+			}
+			if got := DecodeMemo(encoded); got != tt.memo {
+				t.Errorf("DecodeMemo() = %v, want %v", got, tt.memo)
+			}
+		})
+	}
+}
+
+func TestDecodeMemo(t *testing.T) {
+	tests := []struct {
+		name    string
+		memoHex string
+		want    string
+	}{
+		{name: "empty", memoHex: "", want: ""},
+		{name: "all zero", memoHex: "0x0000", want: ""},
+		{name: "not hex", memoHex: "memo", want: ""},
+		{name: "trailing zero", memoHex: "0x6869000000", want: "hi"},
+		{name: "not utf-8", memoHex: "0xfffe00", want: "0xfffe00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DecodeMemo(tt.memoHex); got != tt.want {
+				t.Errorf("DecodeMemo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactionDecoder_getRawTransactionOutputsMemo(t *testing.T) {
+
+	decoder := tw.TxDecoder.(*TransactionDecoder)
+
+	tests := []struct {
+		name    string
+		outputs []map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "different addresses",
+			outputs: []map[string]interface{}{
+				{"address": testPKr1, "amount": "1", "memo": "a"},
+			},
+		},
+		{
+			name: "same address same memo",
+			outputs: []map[string]interface{}{
+				{"address": testPKr1, "amount": "1", "memo": "a"},
+				{"address": testPKr1, "currency": "ABC", "decimals": 6, "amount": "2", "memo": "a"},
+			},
+		},
+		{
+			name: "same address conflicting memo",
+			outputs: []map[string]interface{}{
+				{"address": testPKr1, "amount": "1", "memo": "a"},
+				{"address": testPKr1, "currency": "ABC", "decimals": 6, "amount": "2", "memo": "b"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "same address missing memo",
+			outputs: []map[string]interface{}{
+				{"address": testPKr1, "amount": "1", "memo": "a"},
+				{"address": testPKr1, "currency": "ABC", "decimals": 6, "amount": "2"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "token amount within decimals",
+			outputs: []map[string]interface{}{
+				{"address": testPKr1, "currency": "ABC", "decimals": 6, "amount": "0.000001"},
+			},
+		},
+		{
+			name: "token amount over decimals",
+			outputs: []map[string]interface{}{
+				{"address": testPKr1, "currency": "ABC", "decimals": 6, "amount": "0.0000001"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "sero amount over decimals",
+			outputs: []map[string]interface{}{
+				{"address": testPKr1, "amount": "0.0000000000000000001"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawTx := &openwallet.RawTransaction{}
+			rawTx.SetExtParam(outputsExtKey, tt.outputs)
+			_, err := decoder.getRawTransactionOutputs(rawTx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getRawTransactionOutputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -66,6 +66,27 @@ func (block *BlockData) GetOutputInfoByTxID(txid string) []Out {
 	return output
 }
 
+//GetRewardTxIDs 查找blockinfo中不属于区块交易的输出，这些输出是质押奖励
+func (block *BlockData) GetRewardTxIDs() []string {
+	txids := make([]string, 0)
+	if block.blockInfo == nil {
+		return txids
+	}
+	exist := make(map[string]bool)
+	for _, txid := range block.transactions {
+		exist[txid] = true
+	}
+	for _, info := range block.blockInfo.Outs {
+		id := info.State.TxHash
+		if exist[id] {
+			continue
+		}
+		exist[id] = true
+		txids = append(txids, id)
+	}
+	return txids
+}
+
 //BlockHeader 区块链头
 func (b *BlockData) BlockHeader(symbol string) *openwallet.BlockHeader {
 
@@ -107,6 +128,8 @@ type Unspent struct {
 	Address  string `json:"address" storm:"index"`
 	TK       string `json:"tk" storm:"index"`
 	Sending  bool   `json:"sending"`
+	Category string `json:"category"`             //票据类别，没有票据为空
+	Ticket   string `json:"ticket" storm:"index"` //票据hash，没有票据为空
 }
 
 // NewUnspent 未花
@@ -120,6 +143,8 @@ func NewUnspent(json *gjson.Result) *Unspent {
 	obj.TK = gjson.Get(json.Raw, "tk").String()
 	obj.Sending = gjson.Get(json.Raw, "sending").Bool()
 	obj.Height = gjson.Get(json.Raw, "height").Uint()
+	obj.Category = gjson.Get(json.Raw, "category").String()
+	obj.Ticket = gjson.Get(json.Raw, "ticket").String()
 
 	return obj
 }
@@ -155,6 +180,39 @@ type Out_O struct {
 	Memo  string
 }
 
+//TxCmds 交易指令，对应flight_genTxParam的Cmds，地址和Id为hex
+type TxCmds struct {
+	BuyShare   *BuyShareCmd   `json:"BuyShare,omitempty"`
+	RegistPool *RegistPoolCmd `json:"RegistPool,omitempty"`
+	ClosePool  *ClosePoolCmd  `json:"ClosePool,omitempty"`
+	Contract   *ContractCmd   `json:"Contract,omitempty"`
+}
+
+//BuyShareCmd 购买股份，Value为支付的SERO，最小单位
+type BuyShareCmd struct {
+	Value string  `json:"Value"`
+	Vote  string  `json:"Vote"`
+	Pool  *string `json:"Pool,omitempty"`
+}
+
+//RegistPoolCmd 注册矿池，Value为抵押的SERO，最小单位，FeeRate为万分比
+type RegistPoolCmd struct {
+	Value   string `json:"Value"`
+	Vote    string `json:"Vote"`
+	FeeRate uint32 `json:"FeeRate"`
+}
+
+//ClosePoolCmd 关闭矿池
+type ClosePoolCmd struct {
+}
+
+//ContractCmd 合约调用，Asset为支付给合约的资产，Currency为币种Id
+type ContractCmd struct {
+	Asset Asset  `json:"Asset"`
+	To    string `json:"To"`
+	Data  string `json:"Data"`
+}
+
 type Out_Z struct {
 	AssetCM string
 	OutCM   string
@@ -184,7 +242,8 @@ type Block struct {
 }
 
 type Asset struct {
-	Tkn *Token `rlp:"nil"`
+	Tkn *Token  `rlp:"nil"`
+	Tkt *Ticket `rlp:"nil"`
 }
 
 type Token struct {
@@ -192,6 +251,12 @@ type Token struct {
 	Value    string
 }
 
+//Ticket 票据资产（非同质化通证），Category为类别，Value为票据的唯一hash
+type Ticket struct {
+	Category string
+	Value    string
+}
+
 type Uint256 [32]byte
 type Uint512 [64]byte
 type Uint128 [16]byte
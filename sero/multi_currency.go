@@ -0,0 +1,330 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+const (
+	outputsExtKey = "outputs" //交易单ExtParam中多币种输出列表的字段
+)
+
+//TxOutputParam 多币种交易的一个输出
+type TxOutputParam struct {
+	Address  string `json:"address"`  //收款码PKr
+	Currency string `json:"currency"` //币种，主币为SERO，代币为合约地址
+	Decimals int32  `json:"decimals"` //币种精度，主币和已登记的代币可不填
+	Amount   string `json:"amount"`   //金额
+	Memo     string `json:"memo"`     //备注
+}
+
+//currencyPayment 同一币种的支付合计
+type currencyPayment struct {
+	currency string
+	decimals int32
+	amount   decimal.Decimal
+	used     []*Unspent
+	balance  decimal.Decimal
+}
+
+//getRawTransactionOutputs 读取交易单ExtParam中的多币种输出列表，没有设置返回nil
+func (decoder *TransactionDecoder) getRawTransactionOutputs(rawTx *openwallet.RawTransaction) ([]*TxOutputParam, error) {
+
+	if len(rawTx.ExtParam) == 0 {
+		return nil, nil
+	}
+
+	param := rawTx.GetExtParam().Get(outputsExtKey)
+	if !param.Exists() {
+		return nil, nil
+	}
+
+	if !param.IsArray() || len(param.Array()) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "outputs is empty")
+	}
+
+	outputs := make([]*TxOutputParam, 0)
+	memos := make(map[string]string)
+	for i, o := range param.Array() {
+
+		output := &TxOutputParam{
+			Address:  o.Get("address").String(),
+			Currency: o.Get("currency").String(),
+			Decimals: int32(o.Get("decimals").Int()),
+			Amount:   o.Get("amount").String(),
+			Memo:     o.Get("memo").String(),
+		}
+
+		if len(output.Currency) == 0 || strings.EqualFold(output.Currency, decoder.wm.Symbol()) {
+			output.Currency = decoder.wm.Symbol()
+			output.Decimals = decoder.wm.Decimal()
+		} else if !o.Get("decimals").Exists() {
+			//没有设置精度时使用登记的精度
+			token, err := decoder.wm.GetTokenInfo(output.Currency)
+			if err != nil {
+				return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "output: %d currency: %s decimals is empty and not registered", i, output.Currency)
+			}
+			output.Decimals = token.Decimals
+		}
+
+		if err := validatePKr(output.Address); err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrAdressDecodeFailed, "output: %d address: %s is not a valid PKr, %v", i, output.Address, err)
+		}
+
+		amount, err := decimal.NewFromString(output.Amount)
+		if err != nil || amount.LessThanOrEqual(decimal.Zero) {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "output: %d amount: %s is invalid", i, output.Amount)
+		}
+
+		//小数位超过币种精度时，转为最小单位会被截断
+		if amount.Exponent() < -output.Decimals {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "output: %d amount: %s has more than %d decimals", i, output.Amount, output.Decimals)
+		}
+
+		if err := ValidateMemo(output.Memo); err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "output: %d memo is invalid, %v", i, err)
+		}
+
+		//交易单按地址记录备注，同一地址的多个输出备注必须相同
+		if memo, exist := memos[output.Address]; exist && memo != output.Memo {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "output: %d memo conflicts with other output to address: %s", i, output.Address)
+		}
+		memos[output.Address] = output.Memo
+
+		outputs = append(outputs, output)
+	}
+
+	if len(outputs) > MaxTxOutputs-1 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "The transaction outputs over: %d", MaxTxOutputs-1)
+	}
+
+	return outputs, nil
+}
+
+//createMultiCurrencyRawTransaction 创建多币种交易单，每个币种分别选择utxo并找零，手续费由SERO支付
+func (decoder *TransactionDecoder) createMultiCurrencyRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, outputs []*TxOutputParam) error {
+
+	var (
+		accountID    = rawTx.Account.AccountID
+		outputAddrs  = make([]Out_O, 0)
+		payments     = make([]*currencyPayment, 0)
+		paymentIndex = make(map[string]*currencyPayment)
+		destinations = make([]string, 0)
+		memos        = make(map[string]string)
+		to           = make(map[string]string)
+		txFrom       = make([]string, 0)
+		txTo         = make([]string, 0)
+		feesRate     = decimal.Zero
+		symbol       = decoder.wm.Symbol()
+	)
+
+	//交易单的币种，用于记录To和TxAmount
+	coinCurrency := rawTx.Coin.Symbol
+	if rawTx.Coin.IsContract {
+		coinCurrency = rawTx.Coin.Contract.Address
+	}
+
+	//手续费总是SERO支付
+	paymentIndex[symbol] = &currencyPayment{currency: symbol, decimals: decoder.wm.Decimal()}
+	payments = append(payments, paymentIndex[symbol])
+
+	accountTotalSent := decimal.Zero
+	for _, o := range outputs {
+
+		amount, _ := decimal.NewFromString(o.Amount)
+
+		payment := paymentIndex[o.Currency]
+		if payment == nil {
+			payment = &currencyPayment{currency: o.Currency, decimals: o.Decimals}
+			paymentIndex[o.Currency] = payment
+			payments = append(payments, payment)
+		}
+		payment.amount = payment.amount.Add(amount)
+
+		outputAddrs = append(outputAddrs, Out_O{
+			Asset: Asset{
+				Tkn: &Token{
+					Currency: o.Currency,
+					Value:    amount.Shift(o.Decimals).String(),
+				},
+			},
+			Addr: o.Address,
+			Memo: o.Memo,
+		})
+		destinations = append(destinations, fmt.Sprintf("%s(%s %s)", o.Address, amount.String(), o.Currency))
+		if len(o.Memo) > 0 {
+			memos[o.Address] = o.Memo
+		}
+
+		if o.Currency == symbol {
+			txTo = append(txTo, fmt.Sprintf("%s:%s", o.Address, amount.String()))
+		} else {
+			txTo = append(txTo, fmt.Sprintf("%s:%s", o.Address, amount.Shift(o.Decimals)))
+		}
+
+		if o.Currency == coinCurrency {
+			sent, _ := decimal.NewFromString(to[o.Address])
+			to[o.Address] = sent.Add(amount).String()
+
+			//计算账户的实际转账amount
+			addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", accountID, "Address", o.Address)
+			if findErr != nil || len(addresses) == 0 {
+				accountTotalSent = accountTotalSent.Add(amount)
+			}
+		}
+	}
+
+	feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
+
+	//获取当前最大高度
+	currentHeight, err := decoder.wm.GetBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	available := make(map[string][]*Unspent)
+	for _, p := range payments {
+		unspents, listErr := decoder.wm.ListUnspent(accountID, p.currency, 0, -1)
+		if listErr != nil {
+			return listErr
+		}
+		available[p.currency] = decoder.availableUnspents(currentHeight, unspents)
+	}
+
+	var (
+		fees     = decimal.Zero
+		gas      = int64(0)
+		usedUTXO []*Unspent
+		ins      = len(payments)
+		outs     = len(outputs) + len(payments) //每个币种一个找零输出
+	)
+
+	//按输入数量预估手续费，选择的utxo超过预估的输入数量时，重新预估并选择utxo
+	for {
+
+		fees, feesRate, gas, err = decoder.wm.EstimateTxFee(feesRate, NewTxGasParam(ins, outs, len(payments)))
+		if err != nil {
+			return err
+		}
+
+		usedUTXO = make([]*Unspent, 0)
+		for _, p := range payments {
+			need := p.amount
+			if p.currency == symbol {
+				need = need.Add(fees)
+			}
+			p.used, err = pickUnspents(available[p.currency], need, p.decimals)
+			if err != nil {
+				return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "[%s] %s balance is not enough to pay: %s(utxo meet 12 confirmations)", accountID, p.currency, need.String())
+			}
+			p.balance = decimal.Zero
+			for _, u := range p.used {
+				ua, _ := decimal.NewFromString(u.Value)
+				p.balance = p.balance.Add(ua.Shift(-p.decimals))
+			}
+			usedUTXO = append(usedUTXO, p.used...)
+		}
+
+		if len(usedUTXO) > MaxTxInputs {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "The transaction is use max inputs over: %d", MaxTxInputs)
+		}
+
+		if len(usedUTXO) <= ins {
+			break
+		}
+		ins = len(usedUTXO)
+	}
+
+	rawTx.To = to
+
+	//找零地址
+	changeAddress, err := decoder.getChangeAddress(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
+	rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("From Account: %s", accountID)
+	decoder.wm.Log.Std.Notice("To Address: %s", strings.Join(destinations, ", "))
+	for _, p := range payments {
+		change := p.balance.Sub(p.amount)
+		if p.currency == symbol {
+			change = change.Sub(fees)
+		}
+		decoder.wm.Log.Std.Notice("[%s] Use: %v, Receive: %v, Change: %v", p.currency, p.balance.String(), p.amount.String(), change.String())
+	}
+	decoder.wm.Log.Std.Notice("Fees: %v", fees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Gas: %v", gas)
+	decoder.wm.Log.Std.Notice("Change Address: %v", changeAddress)
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	txStruct, err := decoder.wm.GenTxParam(changeAddress, accountID, decoder.wm.Decimal(), feesRate, gas, usedUTXO, outputAddrs)
+	if err != nil {
+		return err
+	}
+
+	rawTx.RawHex = txStruct.Raw
+
+	//记录交易结构概要，用于验证签名后的交易
+	err = decoder.setRawTransactionOutline(rawTx, txStruct, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	//装配签名
+	err = decoder.setRawTransactionSignatures(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range payments {
+		for _, u := range p.used {
+			if p.currency == symbol {
+				ua, _ := decimal.NewFromString(u.Value)
+				txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, ua.Shift(-p.decimals).String()))
+			} else {
+				txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, u.Value))
+			}
+		}
+	}
+
+	if coinCurrency == symbol {
+		accountTotalSent = accountTotalSent.Add(fees)
+	}
+	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
+	if rawTx.Coin.IsContract {
+		accountTotalSent = accountTotalSent.Shift(decoder.wm.CoinDecimals(rawTx.Coin))
+	}
+
+	decoder.setRawTransactionMemos(rawTx, memos)
+
+	//记录找零输出
+	decoder.setRawTransactionChange(rawTx, changeAddress)
+
+	rawTx.IsBuilt = true
+	rawTx.TxAmount = accountTotalSent.StringFixed(decoder.wm.Decimal())
+	rawTx.TxFrom = txFrom
+	rawTx.TxTo = txTo
+
+	return nil
+}
@@ -0,0 +1,281 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/blocktree/sero-adapter/client"
+	"github.com/tidwall/gjson"
+	"strings"
+	"time"
+)
+
+const (
+	PendingTxStatusPending   = "pending"   //已提交，等待上链
+	PendingTxStatusIncluded  = "included"  //已上链，确认数不足
+	PendingTxStatusConfirmed = "confirmed" //已达到确认数
+	PendingTxStatusFailed    = "failed"    //已上链，但执行失败
+	PendingTxStatusRejected  = "rejected"  //已确定被拒绝（丢弃），输入utxo已释放
+)
+
+//PendingTx 已提交的交易记录，广播前锁定输入utxo
+type PendingTx struct {
+	TxID          string   `json:"txid" storm:"id"`
+	Sid           string   `json:"sid" storm:"index"`
+	AccountID     string   `json:"accountID" storm:"index"`
+	Roots         []string `json:"roots"`
+	Status        string   `json:"status" storm:"index"`
+	SubmitHeight  uint64   `json:"submitHeight"`
+	SubmitAt      int64    `json:"submitAt"`
+	IncludeHeight uint64   `json:"includeHeight"`
+	Reason        string   `json:"reason"`
+}
+
+var (
+	//rejectedErrorCodes 节点拒绝请求的JSON-RPC错误码（解析失败、参数无效）
+	rejectedErrorCodes = []int64{-32700, -32602}
+
+	//rejectedErrorMessages 交易池或交易验证拒绝交易的错误信息
+	rejectedErrorMessages = []string{
+		"invalid",
+		"verify",
+		"underpriced",
+		"insufficient",
+		"exceeds",
+		"too low",
+		"oversized",
+		"negative",
+		"rejected",
+	}
+
+	//knownTxErrorMessages 交易已在交易池或区块中的错误信息
+	knownTxErrorMessages = []string{
+		"known transaction",
+		"already known",
+		"already exists",
+		"duplicate",
+	}
+)
+
+//IsRejectedError 节点明确拒绝了交易才认为被拒绝，超时等网络错误或无法识别的节点错误无法确定交易是否被接受，
+//交易已存在的错误不是拒绝
+func IsRejectedError(err error) bool {
+	rpcErr, ok := err.(*client.RPCError)
+	if !ok || IsKnownTxError(err) {
+		return false
+	}
+	for _, code := range rejectedErrorCodes {
+		if rpcErr.Code == code {
+			return true
+		}
+	}
+	return containsAny(rpcErr.Message, rejectedErrorMessages)
+}
+
+//IsKnownTxError 节点返回交易已存在，说明交易已被提交过，按已广播处理
+func IsKnownTxError(err error) bool {
+	rpcErr, ok := err.(*client.RPCError)
+	if !ok {
+		return false
+	}
+	return containsAny(rpcErr.Message, knownTxErrorMessages)
+}
+
+//containsAny 错误信息是否包含任一关键字，不区分大小写
+func containsAny(message string, keywords []string) bool {
+	message = strings.ToLower(message)
+	for _, keyword := range keywords {
+		if strings.Contains(message, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+//IsTxNotExistError flight_getTx明确返回交易不存在，区块和交易池中都没有此交易
+func IsTxNotExistError(err error) bool {
+	rpcErr, ok := err.(*client.RPCError)
+	if !ok {
+		return false
+	}
+	return strings.Contains(strings.ToLower(rpcErr.Message), "not exist")
+}
+
+//GetPendingTx 查询已提交的交易记录
+func (wm *WalletManager) GetPendingTx(txid string) (*PendingTx, error) {
+	var pending PendingTx
+	err := wm.unspentDB.One("TxID", txid, &pending)
+	if err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+//ListPendingTx 查询指定状态的交易记录
+func (wm *WalletManager) ListPendingTx(status string) ([]*PendingTx, error) {
+	var list []*PendingTx
+	err := wm.unspentDB.Find("Status", status, &list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return list, nil
+}
+
+//AddPendingTx 广播前记录交易，并锁定输入utxo
+func (wm *WalletManager) AddPendingTx(rawTx *openwallet.RawTransaction) (*PendingTx, error) {
+
+	txid := gjson.Get(rawTx.RawHex, "Hash").String()
+	if len(txid) == 0 {
+		return nil, fmt.Errorf("transaction hash is empty")
+	}
+
+	//重复提交同一笔交易，继续使用原记录
+	if pending, err := wm.GetPendingTx(txid); err == nil {
+		if pending.Status == PendingTxStatusRejected {
+			return nil, fmt.Errorf("transaction: %s has been rejected, %s", txid, pending.Reason)
+		}
+		return pending, nil
+	}
+
+	currentHeight, err := wm.GetBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	//Sid创建时预留的utxo，过期的交易单输入已释放，需要重新锁定
+	reserved := make(map[string]bool)
+	if len(rawTx.Sid) > 0 {
+		if built, findErr := wm.GetBuiltTx(rawTx.Sid); findErr == nil && built.Status == BuiltTxStatusBuilt {
+			for _, root := range built.Roots {
+				reserved[root] = true
+			}
+		}
+	}
+
+	pending := &PendingTx{
+		TxID:         txid,
+		Sid:          rawTx.Sid,
+		AccountID:    rawTx.Account.AccountID,
+		Roots:        make([]string, 0),
+		Status:       PendingTxStatusPending,
+		SubmitHeight: currentHeight,
+		SubmitAt:     time.Now().Unix(),
+	}
+
+	for _, keySignature := range rawTx.Signatures[rawTx.Account.AccountID] {
+		pending.Roots = append(pending.Roots, keySignature.Message)
+	}
+
+	tx, err := wm.unspentDB.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	for _, root := range pending.Roots {
+		var utxo Unspent
+		err = tx.One("Root", root, &utxo)
+		if err != nil {
+			return nil, fmt.Errorf("utxo: %s not found, %v", root, err)
+		}
+		if utxo.Sending && !reserved[root] {
+			return nil, fmt.Errorf("utxo: %s is locked by other transaction", root)
+		}
+		utxo.Sending = true
+		err = tx.Save(&utxo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Save(pending)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	wm.TxTracker.notify(pending, "", 0)
+
+	return pending, nil
+}
+
+//ReleasePendingTx 交易被拒绝，释放输入utxo
+func (wm *WalletManager) ReleasePendingTx(txid, reason string) error {
+
+	tx, err := wm.unspentDB.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var pending PendingTx
+	err = tx.One("TxID", txid, &pending)
+	if err != nil {
+		return err
+	}
+
+	if pending.Status != PendingTxStatusPending {
+		return nil
+	}
+
+	for _, root := range pending.Roots {
+		var utxo Unspent
+		//utxo已被作废，无需释放
+		if findErr := tx.One("Root", root, &utxo); findErr != nil {
+			continue
+		}
+		utxo.Sending = false
+		err = tx.Save(&utxo)
+		if err != nil {
+			return err
+		}
+	}
+
+	pending.Status = PendingTxStatusRejected
+	pending.Reason = reason
+	err = tx.Save(&pending)
+	if err != nil {
+		return err
+	}
+
+	//交易被拒绝，同一Sid可以重新创建交易单
+	if len(pending.Sid) > 0 {
+		var built BuiltTx
+		if findErr := tx.One("Sid", pending.Sid, &built); findErr == nil && built.TxID == pending.TxID {
+			err = tx.DeleteStruct(&built)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	wm.TxTracker.notify(&pending, PendingTxStatusPending, 0)
+
+	return nil
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"errors"
+	"github.com/blocktree/sero-adapter/client"
+	"testing"
+)
+
+func TestIsRejectedError(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantRejected bool
+		wantKnown    bool
+	}{
+		{"network error", errors.New("connection refused"), false, false},
+		{"invalid params", &client.RPCError{Code: -32602, Message: "missing value for required argument 0"}, true, false},
+		{"underpriced", &client.RPCError{Code: -32000, Message: "transaction underpriced"}, true, false},
+		{"verify failed", &client.RPCError{Code: -32000, Message: "tx verify failed"}, true, false},
+		{"known transaction", &client.RPCError{Code: -32000, Message: "known transaction: 0x01"}, false, true},
+		{"already known", &client.RPCError{Code: -32000, Message: "Already Known"}, false, true},
+		{"unknown node error", &client.RPCError{Code: -32000, Message: "internal error"}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRejectedError(tt.err); got != tt.wantRejected {
+				t.Errorf("IsRejectedError() = %v, want %v", got, tt.wantRejected)
+			}
+			if got := IsKnownTxError(tt.err); got != tt.wantKnown {
+				t.Errorf("IsKnownTxError() = %v, want %v", got, tt.wantKnown)
+			}
+		})
+	}
+}
@@ -74,6 +74,27 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	wm.Config.FixGas, _ = c.Int64("fixGas")
 	wm.WalletClient = client.NewClient(wm.Config.ServerAPI, false)
 	wm.Config.DataDir = c.String("dataDir")
+	wm.Config.ConsolidateMinCount = c.DefaultInt64("consolidateMinCount", wm.Config.ConsolidateMinCount)
+	wm.Config.ConsolidateDustAmount = c.DefaultString("consolidateDustAmount", wm.Config.ConsolidateDustAmount)
+	wm.Config.ConsolidateDustCount = c.DefaultInt64("consolidateDustCount", wm.Config.ConsolidateDustCount)
+	wm.Config.ConsolidateMaxFees = c.DefaultString("consolidateMaxFees", wm.Config.ConsolidateMaxFees)
+	wm.Config.ConsolidateConfirms = uint64(c.DefaultInt64("consolidateConfirms", int64(wm.Config.ConsolidateConfirms)))
+	wm.Config.ChangeAddressMode = c.DefaultString("changeAddressMode", wm.Config.ChangeAddressMode)
+	wm.Config.GasEstimateMode = c.DefaultString("gasEstimateMode", wm.Config.GasEstimateMode)
+	wm.Config.GasBase = c.DefaultInt64("gasBase", wm.Config.GasBase)
+	wm.Config.GasPerInput = c.DefaultInt64("gasPerInput", wm.Config.GasPerInput)
+	wm.Config.GasPerOutput = c.DefaultInt64("gasPerOutput", wm.Config.GasPerOutput)
+	wm.Config.GasPerZOutput = c.DefaultInt64("gasPerZOutput", wm.Config.GasPerZOutput)
+	wm.Config.GasPerCurrency = c.DefaultInt64("gasPerCurrency", wm.Config.GasPerCurrency)
+	wm.Config.GasSafetyMargin = c.DefaultString("gasSafetyMargin", wm.Config.GasSafetyMargin)
+	wm.Config.MaxGas = c.DefaultInt64("maxGas", wm.Config.MaxGas)
+	wm.Config.PendingTxTimeout = uint64(c.DefaultInt64("pendingTxTimeout", int64(wm.Config.PendingTxTimeout)))
+	wm.Config.TxConfirms = uint64(c.DefaultInt64("txConfirms", int64(wm.Config.TxConfirms)))
+	wm.Config.BuiltTxTimeout = uint64(c.DefaultInt64("builtTxTimeout", int64(wm.Config.BuiltTxTimeout)))
+	wm.Config.Tokens = c.DefaultString("tokens", wm.Config.Tokens)
+	wm.Config.BalanceIndexCheck = c.DefaultString("balanceIndexCheck", wm.Config.BalanceIndexCheck)
+	wm.Config.RecoverAccounts = c.DefaultString("recoverAccounts", wm.Config.RecoverAccounts)
+	wm.Config.AddressGap = uint64(c.DefaultInt64("addressGap", int64(wm.Config.AddressGap)))
 
 	//数据文件夹
 	wm.Config.makeDataDir()
@@ -101,6 +122,28 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	wm.unspentDB = unspentdb
 	wm.blockChainDB = blockchaindb
 	wm.Decoder.Client = wm.WalletClient
+	wm.Decoder.RegisterWatchOnly = wm.registerWatchOnlyAccount
+
+	//登记配置的代币
+	err = wm.loadTokenRegistry()
+	if err != nil {
+		return err
+	}
+
+	//旧版本数据库建立余额索引
+	err = wm.initBalanceIndex()
+	if err != nil {
+		return err
+	}
+
+	//校验余额索引
+	err = wm.startupCheckBalanceIndex()
+	if err != nil {
+		return err
+	}
+
+	//恢复配置账户的地址
+	wm.startupRecoverAddresses()
 
 	return nil
 }
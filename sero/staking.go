@@ -0,0 +1,655 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/blocktree/sero-adapter/sero_addrdec"
+	"github.com/mr-tron/base58"
+	"github.com/sero-cash/go-sero/common/hexutil"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+	"strings"
+)
+
+const (
+	StakeCmdBuyShare   = "buyShare"   //购买股份
+	StakeCmdRegistPool = "registPool" //注册矿池
+	StakeCmdClosePool  = "closePool"  //关闭矿池
+	StakeCmdReward     = "reward"     //质押奖励，扫块时标记
+
+	stakeExtKey = "stake" //交易单和交易记录ExtParam中质押指令的字段
+
+	stakePoolFeeRateBase = 10000 //矿池费率的单位为万分比
+)
+
+//StakeAction 质押指令，金额为SERO
+type StakeAction struct {
+	Cmd     string `json:"cmd"`
+	Value   string `json:"value,omitempty"`   //购买股份支付或注册矿池抵押的SERO
+	Pool    string `json:"pool,omitempty"`    //指定的矿池Id，不指定由系统分配
+	Vote    string `json:"vote,omitempty"`    //投票地址PKr，购买股份指定了矿池时可不填，由矿池投票
+	FeeRate string `json:"feeRate,omitempty"` //矿池费率，0到1之间
+}
+
+//StakeShare 账户购买的股份，金额为SERO
+type StakeShare struct {
+	ID        string `json:"id"`
+	Address   string `json:"address"` //购买者PKr
+	Vote      string `json:"vote"`    //投票地址PKr
+	Pool      string `json:"pool"`
+	Price     string `json:"price"`     //股份单价
+	Total     uint64 `json:"total"`     //股份总数
+	Remaining uint64 `json:"remaining"` //剩余未投票的股份
+	Missed    uint64 `json:"missed"`    //错过投票的股份
+	Expired   uint64 `json:"expired"`   //过期的股份
+	Profit    string `json:"profit"`    //已获得的收益
+	Status    uint64 `json:"status"`
+	At        uint64 `json:"at"` //购买的区块高度
+}
+
+//StakePool 矿池信息，金额为SERO
+type StakePool struct {
+	ID          string `json:"id"`
+	Owner       string `json:"owner"`
+	Fee         string `json:"fee"` //矿池费率，0到1之间
+	ShareNum    uint64 `json:"shareNum"`
+	ChoicedNum  uint64 `json:"choicedNum"`
+	MissedNum   uint64 `json:"missedNum"`
+	WishVoteNum uint64 `json:"wishVoteNum"`
+	Income      string `json:"income"`
+	Closed      bool   `json:"closed"`
+}
+
+//StakeReward 扫块记录的质押奖励输出
+type StakeReward struct {
+	Sid       string `json:"sid" storm:"id"`
+	AccountID string `json:"accountID" storm:"index"`
+	Address   string `json:"address"`
+	TxID      string `json:"txid"`
+	Height    uint64 `json:"height" storm:"index"`
+	Value     string `json:"value"` //SERO
+}
+
+//StakeReport 账户的质押汇总
+type StakeReport struct {
+	AccountID   string        `json:"accountID"`
+	SharePrice  string        `json:"sharePrice"`
+	Shares      []*StakeShare `json:"shares"`
+	Total       uint64        `json:"total"`
+	Remaining   uint64        `json:"remaining"`
+	Missed      uint64        `json:"missed"`
+	Expired     uint64        `json:"expired"`
+	Profit      string        `json:"profit"`  //节点统计的股份收益
+	Rewards     string        `json:"rewards"` //扫块记录的奖励合计
+	RewardCount int           `json:"rewardCount"`
+}
+
+//parseUint 解析节点返回的hex或十进制整数
+func parseUint(result gjson.Result) uint64 {
+	num, err := parseHexOrNumber(result)
+	if err != nil {
+		return 0
+	}
+	return uint64(num.IntPart())
+}
+
+//NewStakeShare 解析节点返回的股份
+func NewStakeShare(json *gjson.Result, decimals int32) *StakeShare {
+	obj := &StakeShare{}
+	obj.ID = json.Get("id").String()
+	obj.Address = hexToBase58(json.Get("addr").String())
+	obj.Vote = hexToBase58(json.Get("voteAddr").String())
+	obj.Pool = json.Get("pool").String()
+	price, _ := parseHexOrNumber(json.Get("price"))
+	obj.Price = price.Shift(-decimals).String()
+	obj.Total = parseUint(json.Get("total"))
+	obj.Remaining = parseUint(json.Get("remaining"))
+	obj.Missed = parseUint(json.Get("missed"))
+	obj.Expired = parseUint(json.Get("expired"))
+	profit, _ := parseHexOrNumber(json.Get("profit"))
+	obj.Profit = profit.Shift(-decimals).String()
+	obj.Status = parseUint(json.Get("status"))
+	obj.At = parseUint(json.Get("at"))
+	return obj
+}
+
+//NewStakePool 解析节点返回的矿池
+func NewStakePool(json *gjson.Result, decimals int32) *StakePool {
+	obj := &StakePool{}
+	obj.ID = json.Get("id").String()
+	obj.Owner = hexToBase58(json.Get("own").String())
+	fee, _ := parseHexOrNumber(json.Get("fee"))
+	obj.Fee = fee.Div(decimal.New(stakePoolFeeRateBase, 0)).String()
+	obj.ShareNum = parseUint(json.Get("shareNum"))
+	obj.ChoicedNum = parseUint(json.Get("choicedNum"))
+	obj.MissedNum = parseUint(json.Get("missedNum"))
+	obj.WishVoteNum = parseUint(json.Get("wishVoteNum"))
+	income, _ := parseHexOrNumber(json.Get("income"))
+	obj.Income = income.Shift(-decimals).String()
+	obj.Closed = json.Get("closed").Bool()
+	return obj
+}
+
+//GetSharePrice 查询当前股份单价，单位SERO
+func (wm *WalletManager) GetSharePrice() (decimal.Decimal, error) {
+
+	result, err := wm.WalletClient.Call("stake_sharePrice", []interface{}{})
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	price, err := parseHexOrNumber(*result)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return price.Shift(-wm.Decimal()), nil
+}
+
+//ListStakeShares 查询账户购买的股份
+func (wm *WalletManager) ListStakeShares(tk string) ([]*StakeShare, error) {
+
+	pk, err := wm.LocalTk2Pk(tk)
+	if err != nil {
+		return nil, err
+	}
+
+	request := []interface{}{
+		pk,
+	}
+
+	result, err := wm.WalletClient.Call("stake_myShare", request)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([]*StakeShare, 0)
+	for _, s := range result.Array() {
+		shares = append(shares, NewStakeShare(&s, wm.Decimal()))
+	}
+
+	return shares, nil
+}
+
+//GetStakePool 查询矿池信息
+func (wm *WalletManager) GetStakePool(poolID string) (*StakePool, error) {
+
+	request := []interface{}{
+		poolID,
+	}
+
+	result, err := wm.WalletClient.Call("stake_getStakePool", request)
+	if err != nil {
+		return nil, err
+	}
+
+	if !result.IsObject() {
+		return nil, fmt.Errorf("stake pool: %s not found", poolID)
+	}
+
+	return NewStakePool(result, wm.Decimal()), nil
+}
+
+//SaveStakeReward 记录质押奖励输出
+func (wm *WalletManager) SaveStakeReward(reward *StakeReward) error {
+	return wm.unspentDB.Save(reward)
+}
+
+//ListStakeRewards 查询账户的质押奖励记录
+func (wm *WalletManager) ListStakeRewards(tk string) ([]*StakeReward, error) {
+	var list []*StakeReward
+	err := wm.unspentDB.Find("AccountID", tk, &list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return list, nil
+}
+
+//DeleteStakeRewardByHeight 删除高度的质押奖励记录，用于回滚分叉的区块
+func (bs *SEROBlockScanner) DeleteStakeRewardByHeight(height uint64) error {
+
+	var list []*StakeReward
+	err := bs.wm.unspentDB.Find("Height", height, &list)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	for _, r := range list {
+		if deleteErr := bs.wm.unspentDB.DeleteStruct(r); deleteErr != nil {
+			return deleteErr
+		}
+	}
+
+	return nil
+}
+
+//ExtractRewardTransaction 提取质押奖励输出，奖励没有交易输入和手续费
+func (bs *SEROBlockScanner) ExtractRewardTransaction(block *BlockData, txid string, scanTargetFunc openwallet.BlockScanTargetFunc) ExtractResult {
+	var (
+		result = ExtractResult{
+			BlockHash:   block.BlockHash,
+			BlockHeight: block.BlockNumber,
+			TxID:        txid,
+			extractData: make(map[string]ExtractData),
+			BlockTime:   int64(block.Timestamp),
+		}
+	)
+
+	trx := gjson.Parse(fmt.Sprintf(`{"Hash":"%s"}`, txid))
+
+	bs.extractTransaction(block, &trx, &result, scanTargetFunc)
+	if !result.Success {
+		return result
+	}
+
+	action := &StakeAction{Cmd: StakeCmdReward}
+	for token, sourceKeyExtractData := range result.extractData {
+		for sourceKey, extractData := range sourceKeyExtractData {
+			extractData.Transaction.SetExtParam(stakeExtKey, action)
+			if token != bs.wm.Symbol() {
+				continue
+			}
+			for _, output := range extractData.TxOutputs {
+				reward := &StakeReward{
+					Sid:       output.Sid,
+					AccountID: sourceKey,
+					Address:   output.Address,
+					TxID:      output.TxID,
+					Height:    output.BlockHeight,
+					Value:     output.Amount,
+				}
+				if err := bs.wm.SaveStakeReward(reward); err != nil {
+					bs.wm.Log.Std.Info("block scanner save stake reward failed; unexpected error: %v", err)
+					result.Success = false
+					return result
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+//GetStakeReport 汇总账户的股份数量、过期情况和收益，奖励记录在扫块的数据库中，需要在运行扫块的服务中调用
+func (wm *WalletManager) GetStakeReport(tk string) (*StakeReport, error) {
+
+	report, err := wm.GetStakeShareReport(tk)
+	if err != nil {
+		return nil, err
+	}
+
+	rewards, err := wm.ListStakeRewards(tk)
+	if err != nil {
+		return nil, err
+	}
+
+	rewardTotal := decimal.Zero
+	for _, r := range rewards {
+		v, _ := decimal.NewFromString(r.Value)
+		rewardTotal = rewardTotal.Add(v)
+	}
+	report.Rewards = rewardTotal.String()
+	report.RewardCount = len(rewards)
+
+	return report, nil
+}
+
+//GetStakeShareReport 只按节点数据汇总账户的股份数量、过期情况和收益，不包含扫块记录的奖励
+func (wm *WalletManager) GetStakeShareReport(tk string) (*StakeReport, error) {
+
+	price, err := wm.GetSharePrice()
+	if err != nil {
+		return nil, err
+	}
+
+	shares, err := wm.ListStakeShares(tk)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &StakeReport{
+		AccountID:  tk,
+		SharePrice: price.String(),
+		Shares:     shares,
+	}
+
+	profit := decimal.Zero
+	for _, s := range shares {
+		report.Total += s.Total
+		report.Remaining += s.Remaining
+		report.Missed += s.Missed
+		report.Expired += s.Expired
+		p, _ := decimal.NewFromString(s.Profit)
+		profit = profit.Add(p)
+	}
+	report.Profit = profit.String()
+
+	return report, nil
+}
+
+//parseStakeAction 解析链上交易的质押指令，不是质押交易返回nil
+func parseStakeAction(trx *gjson.Result, decimals int32) *StakeAction {
+
+	cmd := trx.Get("Tx.Desc_Cmd")
+	if !cmd.IsObject() {
+		return nil
+	}
+
+	if buyShare := cmd.Get("BuyShare"); buyShare.IsObject() {
+		value, _ := parseHexOrNumber(buyShare.Get("Value"))
+		return &StakeAction{
+			Cmd:   StakeCmdBuyShare,
+			Value: value.Shift(-decimals).String(),
+			Pool:  buyShare.Get("Pool").String(),
+			Vote:  hexToBase58(buyShare.Get("Vote").String()),
+		}
+	}
+
+	if registPool := cmd.Get("RegistPool"); registPool.IsObject() {
+		value, _ := parseHexOrNumber(registPool.Get("Value"))
+		feeRate, _ := parseHexOrNumber(registPool.Get("FeeRate"))
+		return &StakeAction{
+			Cmd:     StakeCmdRegistPool,
+			Value:   value.Shift(-decimals).String(),
+			Vote:    hexToBase58(registPool.Get("Vote").String()),
+			FeeRate: feeRate.Div(decimal.New(stakePoolFeeRateBase, 0)).String(),
+		}
+	}
+
+	if cmd.Get("ClosePool").Exists() && cmd.Get("ClosePool").Type != gjson.Null {
+		return &StakeAction{
+			Cmd: StakeCmdClosePool,
+		}
+	}
+
+	return nil
+}
+
+//getRawTransactionStake 读取交易单ExtParam中的质押指令，没有设置返回nil
+func (decoder *TransactionDecoder) getRawTransactionStake(rawTx *openwallet.RawTransaction) (*StakeAction, error) {
+
+	if len(rawTx.ExtParam) == 0 {
+		return nil, nil
+	}
+
+	param := rawTx.GetExtParam().Get(stakeExtKey)
+	if !param.Exists() {
+		return nil, nil
+	}
+
+	action := &StakeAction{
+		Cmd:     param.Get("cmd").String(),
+		Value:   param.Get("value").String(),
+		Pool:    param.Get("pool").String(),
+		Vote:    param.Get("vote").String(),
+		FeeRate: param.Get("feeRate").String(),
+	}
+
+	if rawTx.Coin.IsContract {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "stake transaction only support %s", decoder.wm.Symbol())
+	}
+
+	switch action.Cmd {
+	case StakeCmdBuyShare, StakeCmdRegistPool:
+		value, err := decimal.NewFromString(action.Value)
+		if err != nil || value.LessThanOrEqual(decimal.Zero) {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "stake value: %s is invalid", action.Value)
+		}
+	case StakeCmdClosePool:
+		action.Value = ""
+	default:
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "stake cmd: %s is not supported", action.Cmd)
+	}
+
+	//找零地址每笔交易都不同，不能作为投票地址，未指定矿池时必须指定投票地址
+	switch action.Cmd {
+	case StakeCmdBuyShare:
+		if len(action.Pool) == 0 && len(action.Vote) == 0 {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "stake buyShare requires pool or vote address")
+		}
+	case StakeCmdRegistPool:
+		if len(action.Vote) == 0 {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "stake registPool requires vote address")
+		}
+	}
+
+	if len(action.Vote) > 0 {
+		if err := validatePKr(action.Vote); err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrAdressDecodeFailed, "stake vote address: %s is not a valid PKr, %v", action.Vote, err)
+		}
+	}
+
+	if len(action.Pool) > 0 {
+		if _, err := hexutil.Decode(action.Pool); err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "stake pool: %s is invalid", action.Pool)
+		}
+	}
+
+	if action.Cmd == StakeCmdRegistPool {
+		feeRate, err := decimal.NewFromString(action.FeeRate)
+		if err != nil || feeRate.LessThan(decimal.Zero) || feeRate.GreaterThan(decimal.New(1, 0)) {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "stake pool fee rate: %s is invalid", action.FeeRate)
+		}
+	}
+
+	return action, nil
+}
+
+//newStakeCmds 质押指令转为交易指令，没有投票地址时使用空的PKr，由矿池投票
+func (decoder *TransactionDecoder) newStakeCmds(action *StakeAction) (*TxCmds, error) {
+
+	votePKr := make([]byte, sero_addrdec.PKrLength)
+	if len(action.Vote) > 0 {
+		pkr, err := base58.Decode(action.Vote)
+		if err != nil {
+			return nil, err
+		}
+		votePKr = pkr
+	}
+
+	value, _ := decimal.NewFromString(action.Value)
+	cmds := &TxCmds{}
+
+	switch action.Cmd {
+	case StakeCmdBuyShare:
+		cmds.BuyShare = &BuyShareCmd{
+			Value: value.Shift(decoder.wm.Decimal()).String(),
+			Vote:  hexutil.Encode(votePKr),
+		}
+		if len(action.Pool) > 0 {
+			pool := action.Pool
+			cmds.BuyShare.Pool = &pool
+		}
+	case StakeCmdRegistPool:
+		feeRate, _ := decimal.NewFromString(action.FeeRate)
+		cmds.RegistPool = &RegistPoolCmd{
+			Value:   value.Shift(decoder.wm.Decimal()).String(),
+			Vote:    hexutil.Encode(votePKr),
+			FeeRate: uint32(feeRate.Mul(decimal.New(stakePoolFeeRateBase, 0)).IntPart()),
+		}
+	case StakeCmdClosePool:
+		cmds.ClosePool = &ClosePoolCmd{}
+	}
+
+	return cmds, nil
+}
+
+//createStakeRawTransaction 创建质押交易单，购买股份和注册矿池的SERO与手续费一起由账户utxo支付，没有接收输出
+func (decoder *TransactionDecoder) createStakeRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, action *StakeAction) error {
+
+	var (
+		accountID = rawTx.Account.AccountID
+		usedUTXO  []*Unspent
+		fees      = decimal.Zero
+		gas       = int64(0)
+		ins       = 1
+		balance   = decimal.Zero
+		txFrom    = make([]string, 0)
+	)
+
+	value, _ := decimal.NewFromString(action.Value)
+
+	//获取当前最大高度
+	currentHeight, err := decoder.wm.GetBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	unspents, err := decoder.wm.ListUnspent(accountID, decoder.wm.Symbol(), 0, -1)
+	if err != nil {
+		return err
+	}
+	available := decoder.availableUnspents(currentHeight, unspents)
+
+	feesRate, _ := decimal.NewFromString(rawTx.FeeRate)
+
+	//按输入数量预估手续费，选择的utxo超过预估的输入数量时，重新预估并选择utxo
+	for {
+		fees, feesRate, gas, err = decoder.wm.EstimateTxFee(feesRate, NewTxGasParam(ins, 1, 1))
+		if err != nil {
+			return err
+		}
+
+		usedUTXO, err = pickUnspents(available, value.Add(fees), decoder.wm.Decimal())
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "[%s] %s balance is not enough to pay: %s(utxo meet 12 confirmations)", accountID, decoder.wm.Symbol(), value.Add(fees).String())
+		}
+
+		if len(usedUTXO) > MaxTxInputs {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "The transaction is use max inputs over: %d", MaxTxInputs)
+		}
+
+		if len(usedUTXO) <= ins {
+			break
+		}
+		ins = len(usedUTXO)
+	}
+
+	for _, u := range usedUTXO {
+		ua, _ := decimal.NewFromString(u.Value)
+		ua = ua.Shift(-decoder.wm.Decimal())
+		balance = balance.Add(ua)
+		txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, ua.String()))
+	}
+
+	rawTx.To = make(map[string]string)
+
+	//找零地址
+	changeAddress, err := decoder.getChangeAddress(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	cmds, err := decoder.newStakeCmds(action)
+	if err != nil {
+		return err
+	}
+
+	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
+	rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("From Account: %s", accountID)
+	decoder.wm.Log.Std.Notice("Stake Cmd: %s", action.Cmd)
+	decoder.wm.Log.Std.Notice("Stake Value: %s", value.String())
+	decoder.wm.Log.Std.Notice("Stake Pool: %s", action.Pool)
+	decoder.wm.Log.Std.Notice("Vote Address: %s", action.Vote)
+	decoder.wm.Log.Std.Notice("Use: %v", balance.String())
+	decoder.wm.Log.Std.Notice("Fees: %v", fees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Gas: %v", gas)
+	decoder.wm.Log.Std.Notice("Change: %v", balance.Sub(value).Sub(fees).String())
+	decoder.wm.Log.Std.Notice("Change Address: %v", changeAddress)
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	txStruct, err := decoder.wm.GenTxParamWithCmds(changeAddress, accountID, decoder.wm.Decimal(), feesRate, gas, usedUTXO, []Out_O{}, cmds)
+	if err != nil {
+		return err
+	}
+
+	rawTx.RawHex = txStruct.Raw
+
+	//记录交易结构概要，用于验证签名后的交易
+	err = decoder.setRawTransactionOutline(rawTx, txStruct, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	//装配签名
+	err = decoder.setRawTransactionSignatures(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	//记录找零输出
+	decoder.setRawTransactionChange(rawTx, changeAddress)
+
+	rawTx.SetExtParam(stakeExtKey, action)
+
+	rawTx.IsBuilt = true
+	rawTx.TxAmount = decimal.Zero.Sub(value).Sub(fees).StringFixed(decoder.wm.Decimal())
+	rawTx.TxFrom = txFrom
+	rawTx.TxTo = []string{fmt.Sprintf("%s:%s", action.Cmd, value.String())}
+
+	return nil
+}
+
+//verifyStakeCmds 签名后交易的质押指令必须与交易单一致
+func (decoder *TransactionDecoder) verifyStakeCmds(rawTx *openwallet.RawTransaction, signedTx *gjson.Result) error {
+
+	expected, err := decoder.getRawTransactionStake(rawTx)
+	if err != nil {
+		return err
+	}
+
+	actual := parseStakeAction(signedTx, decoder.wm.Decimal())
+
+	if expected == nil {
+		if actual != nil {
+			return fmt.Errorf("signed transaction has unexpected stake cmd: %s", actual.Cmd)
+		}
+		return nil
+	}
+
+	if actual == nil || actual.Cmd != expected.Cmd {
+		return fmt.Errorf("signed transaction stake cmd is not: %s", expected.Cmd)
+	}
+
+	if expected.Cmd == StakeCmdClosePool {
+		return nil
+	}
+
+	expectedValue, _ := decimal.NewFromString(expected.Value)
+	actualValue, _ := decimal.NewFromString(actual.Value)
+	if !expectedValue.Equal(actualValue) {
+		return fmt.Errorf("signed transaction stake value: %s is not equal to: %s", actual.Value, expected.Value)
+	}
+
+	if len(expected.Vote) > 0 && actual.Vote != expected.Vote {
+		return fmt.Errorf("signed transaction stake vote address: %s is not equal to: %s", actual.Vote, expected.Vote)
+	}
+
+	if len(expected.Pool) > 0 && !strings.EqualFold(actual.Pool, expected.Pool) {
+		return fmt.Errorf("signed transaction stake pool: %s is not equal to: %s", actual.Pool, expected.Pool)
+	}
+
+	return nil
+}
@@ -0,0 +1,339 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+	"sort"
+)
+
+const (
+	summaryAllExtKey        = "summaryAllCurrencies" //汇总ExtParam中开启全币种汇总的字段
+	summaryCurrenciesExtKey = "currencies"           //汇总ExtParam中各币种精度和最低转账额的字段
+)
+
+//summaryCurrency 全币种汇总中一个币种的汇总参数
+type summaryCurrency struct {
+	coin        openwallet.Coin
+	currency    string
+	decimals    int32
+	minTransfer decimal.Decimal
+	unspents    []*Unspent
+}
+
+//isSummaryAllCurrencies 汇总是否开启全币种模式
+func isSummaryAllCurrencies(sumRawTx *openwallet.SummaryRawTransaction) bool {
+	if len(sumRawTx.ExtParam) == 0 {
+		return false
+	}
+	return gjson.Get(sumRawTx.ExtParam, summaryAllExtKey).Bool()
+}
+
+//summaryCoinCurrency 汇总交易单指定的币种
+func summaryCoinCurrency(sumRawTx *openwallet.SummaryRawTransaction) string {
+	if sumRawTx.Coin.IsContract {
+		return sumRawTx.Coin.Contract.Address
+	}
+	return sumRawTx.Coin.Symbol
+}
+
+//newSummaryCurrency 创建币种的汇总参数。
+//汇总交易单的币种使用其精度和MinTransfer，其它币种从ExtParam的currencies读取，未设置的代币使用登记的精度，没有登记按最小单位计算。
+func (decoder *TransactionDecoder) newSummaryCurrency(sumRawTx *openwallet.SummaryRawTransaction, currency string) *summaryCurrency {
+
+	symbol := decoder.wm.Symbol()
+	sc := &summaryCurrency{
+		currency: currency,
+	}
+
+	if currency == symbol {
+		sc.coin = openwallet.Coin{Symbol: symbol, IsContract: false}
+		sc.decimals = decoder.wm.Decimal()
+	} else if currency == summaryCoinCurrency(sumRawTx) {
+		sc.coin = sumRawTx.Coin
+		sc.decimals = decoder.wm.CoinDecimals(sumRawTx.Coin)
+	} else {
+		//ExtParam没有设置精度时使用登记的精度
+		sc.coin = decoder.wm.TokenCoin(currency)
+		decimals := gjson.Get(sumRawTx.ExtParam, summaryCurrenciesExtKey+"."+currency+".decimals")
+		if decimals.Exists() {
+			sc.coin.Contract.Decimals = uint64(decimals.Int())
+		}
+		sc.decimals = int32(sc.coin.Contract.Decimals)
+	}
+
+	//最低转账额，优先使用ExtParam中币种的设置
+	minTransfer := gjson.Get(sumRawTx.ExtParam, summaryCurrenciesExtKey+"."+currency+".minTransfer")
+	if minTransfer.Exists() {
+		sc.minTransfer, _ = decimal.NewFromString(minTransfer.String())
+	} else if currency == summaryCoinCurrency(sumRawTx) {
+		sc.minTransfer, _ = decimal.NewFromString(sumRawTx.MinTransfer)
+	}
+
+	return sc
+}
+
+//createSummaryAllCurrencies 汇总账户所有币种的utxo，代币汇总共用SERO的手续费utxo，最后汇总剩余的SERO。
+//AddressStartIndex和AddressLimit为账户地址的范围，只汇总范围内地址的utxo。
+func (decoder *TransactionDecoder) createSummaryAllCurrencies(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
+
+	var (
+		accountID  = sumRawTx.Account.AccountID
+		symbol     = decoder.wm.Symbol()
+		rawTxArray = make([]*openwallet.RawTransactionWithError, 0)
+		groups     = make(map[string]*summaryCurrency)
+		currencies = make([]string, 0)
+		addressSet map[string]bool
+	)
+
+	//限定汇总的地址范围
+	if sumRawTx.AddressLimit > 0 {
+		addresses, err := wrapper.GetAddressList(sumRawTx.AddressStartIndex, sumRawTx.AddressLimit, "AccountID", accountID)
+		if err != nil {
+			return nil, err
+		}
+		addressSet = make(map[string]bool)
+		for _, a := range addresses {
+			addressSet[a.Address] = true
+		}
+	}
+
+	unspents, err := decoder.wm.ListAccountUnspent(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	//获取当前最大高度
+	currentHeight, err := decoder.wm.GetBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range decoder.availableUnspents(currentHeight, unspents) {
+		if addressSet != nil && !addressSet[u.Address] {
+			continue
+		}
+		if sumRawTx.Confirms > 0 && confirmations(currentHeight, u.Height) < sumRawTx.Confirms {
+			continue
+		}
+		sc, exist := groups[u.Currency]
+		if !exist {
+			sc = decoder.newSummaryCurrency(sumRawTx, u.Currency)
+			groups[u.Currency] = sc
+			if u.Currency != symbol {
+				currencies = append(currencies, u.Currency)
+			}
+		}
+		sc.unspents = append(sc.unspents, u)
+	}
+
+	//代币按名称排序，SERO最后汇总
+	sort.Strings(currencies)
+
+	feesRate, _ := decimal.NewFromString(sumRawTx.FeeRate)
+
+	var feeUnspents []*Unspent
+	if sc, exist := groups[symbol]; exist {
+		feeUnspents = sc.unspents
+	}
+
+	var (
+		lackFees       = decimal.Zero
+		supportAddress = ""
+	)
+
+	for _, currency := range currencies {
+		sc := groups[currency]
+		var sumErr error
+		rawTxArray, feeUnspents, sumErr = decoder.summaryTokenUnspents(wrapper, sumRawTx, sc, feesRate, feeUnspents, rawTxArray)
+		if sumErr != nil && sumRawTx.FeesSupportAccount != nil && isInsufficientFees(sumErr) {
+			//手续费不足，合计需要支持的手续费
+			n := len(sc.unspents)
+			if n > MaxTxInputs-1 {
+				n = MaxTxInputs - 1
+			}
+			fees, _, _, estimateErr := decoder.wm.EstimateTxFee(feesRate, NewTxGasParam(n+1, 2, 2))
+			if estimateErr == nil {
+				lackFees = lackFees.Add(fees)
+				if len(supportAddress) == 0 {
+					supportAddress = sc.unspents[0].Address
+				}
+				continue
+			}
+		}
+		if sumErr != nil {
+			rawTxArray = append(rawTxArray, &openwallet.RawTransactionWithError{
+				RawTx: &openwallet.RawTransaction{Coin: sc.coin, Account: sumRawTx.Account},
+				Error: openwallet.ConvertError(sumErr),
+			})
+		}
+	}
+
+	//有手续费账户支持，创建手续费支持交易单，到账确认后再汇总
+	if lackFees.GreaterThan(decimal.Zero) && !decoder.waitingFeesSupport(accountID, currentHeight, lackFees) {
+		supportTx, supportErr := decoder.createFeesSupportRawTransaction(wrapper, sumRawTx, supportAddress, lackFees)
+		if supportErr != nil {
+			return nil, supportErr
+		}
+		rawTxArray = append(rawTxArray, supportTx)
+	}
+
+	if sc, exist := groups[symbol]; exist {
+		sc.unspents = feeUnspents
+		var sumErr error
+		rawTxArray, sumErr = decoder.summarySymbolUnspents(wrapper, sumRawTx, sc, feesRate, rawTxArray)
+		if sumErr != nil {
+			rawTxArray = append(rawTxArray, &openwallet.RawTransactionWithError{
+				RawTx: &openwallet.RawTransaction{Coin: sc.coin, Account: sumRawTx.Account},
+				Error: openwallet.ConvertError(sumErr),
+			})
+		}
+	}
+
+	return rawTxArray, nil
+}
+
+//summaryTokenUnspents 汇总一个代币的utxo，每笔交易单的输入不超过MaxTxInputs，返回剩余的SERO手续费utxo
+func (decoder *TransactionDecoder) summaryTokenUnspents(
+	wrapper openwallet.WalletDAI,
+	sumRawTx *openwallet.SummaryRawTransaction,
+	sc *summaryCurrency,
+	feesRate decimal.Decimal,
+	feeUnspents []*Unspent,
+	rawTxArray []*openwallet.RawTransactionWithError) ([]*openwallet.RawTransactionWithError, []*Unspent, error) {
+
+	balance := summaryBalance(sc.unspents, sc.decimals)
+
+	//超过最低转账额才发送
+	if balance.LessThanOrEqual(decimal.Zero) || balance.LessThan(sc.minTransfer) {
+		return rawTxArray, feeUnspents, nil
+	}
+
+	template := &openwallet.RawTransaction{
+		Coin:    sc.coin,
+		Account: sumRawTx.Account,
+	}
+
+	unspents := sc.unspents
+	for len(unspents) > 0 {
+
+		n := len(unspents)
+		if n > MaxTxInputs-1 {
+			n = MaxTxInputs - 1
+		}
+		usedUTXO := unspents[:n]
+
+		feeUsed, fees, rate, gas, err := decoder.selectFeeUnspents(feesRate, n, 2, 2, feeUnspents)
+		if err != nil {
+			return rawTxArray, feeUnspents, err
+		}
+
+		payments := []*splitPayment{{Address: sumRawTx.SummaryAddress, Amount: summaryBalance(usedUTXO, sc.decimals)}}
+
+		rawTx, err := decoder.buildPlanRawTransaction(wrapper, template, sc.currency, sc.decimals, rate, fees, gas, usedUTXO, feeUsed, payments)
+		if err != nil {
+			return rawTxArray, feeUnspents, err
+		}
+
+		decoder.logSummaryRawTransaction(sumRawTx, sc, payments[0].Amount, fees, gas)
+
+		rawTxArray = append(rawTxArray, &openwallet.RawTransactionWithError{RawTx: rawTx})
+		feeUnspents = feeUnspents[len(feeUsed):]
+		unspents = unspents[n:]
+	}
+
+	return rawTxArray, feeUnspents, nil
+}
+
+//summarySymbolUnspents 汇总SERO的utxo，每笔交易单的输入不超过MaxTxInputs
+func (decoder *TransactionDecoder) summarySymbolUnspents(
+	wrapper openwallet.WalletDAI,
+	sumRawTx *openwallet.SummaryRawTransaction,
+	sc *summaryCurrency,
+	feesRate decimal.Decimal,
+	rawTxArray []*openwallet.RawTransactionWithError) ([]*openwallet.RawTransactionWithError, error) {
+
+	balance := summaryBalance(sc.unspents, sc.decimals)
+
+	//超过最低转账额才发送
+	if balance.LessThanOrEqual(decimal.Zero) || balance.LessThan(sc.minTransfer) {
+		return rawTxArray, nil
+	}
+
+	template := &openwallet.RawTransaction{
+		Coin:    sc.coin,
+		Account: sumRawTx.Account,
+	}
+
+	unspents := sc.unspents
+	for len(unspents) > 0 {
+
+		n := len(unspents)
+		if n > MaxTxInputs {
+			n = MaxTxInputs
+		}
+		usedUTXO := unspents[:n]
+		unspents = unspents[n:]
+
+		fees, rate, gas, err := decoder.wm.EstimateTxFee(feesRate, NewTxGasParam(n, 1, 1))
+		if err != nil {
+			return rawTxArray, err
+		}
+
+		sumAmount := summaryBalance(usedUTXO, sc.decimals).Sub(fees)
+		if sumAmount.LessThanOrEqual(decimal.Zero) {
+			continue
+		}
+
+		payments := []*splitPayment{{Address: sumRawTx.SummaryAddress, Amount: sumAmount}}
+
+		rawTx, err := decoder.buildPlanRawTransaction(wrapper, template, sc.currency, sc.decimals, rate, fees, gas, usedUTXO, nil, payments)
+		if err != nil {
+			return rawTxArray, err
+		}
+
+		decoder.logSummaryRawTransaction(sumRawTx, sc, sumAmount, fees, gas)
+
+		rawTxArray = append(rawTxArray, &openwallet.RawTransactionWithError{RawTx: rawTx})
+	}
+
+	return rawTxArray, nil
+}
+
+//logSummaryRawTransaction 打印汇总交易单的信息
+func (decoder *TransactionDecoder) logSummaryRawTransaction(sumRawTx *openwallet.SummaryRawTransaction, sc *summaryCurrency, sumAmount, fees decimal.Decimal, gas int64) {
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("From Account: %s", sumRawTx.Account.AccountID)
+	decoder.wm.Log.Std.Notice("Summary Address: %s", sumRawTx.SummaryAddress)
+	decoder.wm.Log.Std.Notice("Summary Currency: %s", sc.currency)
+	decoder.wm.Log.Std.Notice("Summary Amount: %v", sumAmount.String())
+	decoder.wm.Log.Std.Notice("Fees: %v", fees.String())
+	decoder.wm.Log.Std.Notice("Gas: %v", gas)
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+}
+
+//summaryBalance 合计utxo的金额
+func summaryBalance(unspents []*Unspent, decimals int32) decimal.Decimal {
+	balance := decimal.Zero
+	for _, u := range unspents {
+		ua, _ := decimal.NewFromString(u.Value)
+		balance = balance.Add(ua.Shift(-decimals))
+	}
+	return balance
+}
+
@@ -0,0 +1,268 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+const (
+	TicketProtocol = "tkt" //票据资产的合约协议
+
+	ticketExtKey  = "ticket"  //输出ExtParam中记录票据hash的字段
+	ticketsExtKey = "tickets" //交易单ExtParam中票据转账列表的字段
+)
+
+//TicketTransfer 票据转账
+type TicketTransfer struct {
+	Address string `json:"address"` //收款码PKr
+	Ticket  string `json:"ticket"`  //票据hash
+	Memo    string `json:"memo"`    //备注
+}
+
+//NewTicketCoin 票据类别对应的币种，票据作为合约资产，数量为1
+func NewTicketCoin(symbol, category string) openwallet.Coin {
+	contractID := openwallet.GenContractID(symbol, category)
+	return openwallet.Coin{
+		Symbol:     symbol,
+		IsContract: true,
+		ContractID: contractID,
+		Contract: openwallet.SmartContract{
+			ContractID: contractID,
+			Symbol:     symbol,
+			Address:    category,
+			Token:      category,
+			Protocol:   TicketProtocol,
+		},
+	}
+}
+
+//ticketExtractKey 扫块时票据输出按类别归集的键，与同名的币种区分
+func ticketExtractKey(category string) string {
+	return TicketProtocol + ":" + category
+}
+
+//GetUnspentByTicket 查询持有票据的utxo
+func (wm *WalletManager) GetUnspentByTicket(ticket string) (*Unspent, error) {
+	var utxo Unspent
+	err := wm.unspentDB.One("Ticket", ticket, &utxo)
+	if err != nil {
+		return nil, err
+	}
+	return &utxo, nil
+}
+
+//ListTickets 查询账户持有的票据utxo
+func (wm *WalletManager) ListTickets(tk string) ([]*Unspent, error) {
+
+	unspents, err := wm.ListAccountUnspent(tk)
+	if err != nil {
+		return nil, err
+	}
+
+	tickets := make([]*Unspent, 0)
+	for _, u := range unspents {
+		if len(u.Ticket) > 0 {
+			tickets = append(tickets, u)
+		}
+	}
+
+	return tickets, nil
+}
+
+//getRawTransactionTickets 读取交易单ExtParam中的票据转账列表，没有设置返回nil
+func (decoder *TransactionDecoder) getRawTransactionTickets(rawTx *openwallet.RawTransaction) ([]*TicketTransfer, error) {
+
+	if len(rawTx.ExtParam) == 0 {
+		return nil, nil
+	}
+
+	param := rawTx.GetExtParam().Get(ticketsExtKey)
+	if !param.Exists() {
+		return nil, nil
+	}
+
+	if !param.IsArray() || len(param.Array()) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "tickets is empty")
+	}
+
+	transfers := make([]*TicketTransfer, 0)
+	exist := make(map[string]bool)
+	for i, t := range param.Array() {
+
+		transfer := &TicketTransfer{
+			Address: t.Get("address").String(),
+			Ticket:  strings.ToLower(t.Get("ticket").String()),
+			Memo:    t.Get("memo").String(),
+		}
+
+		if err := validatePKr(transfer.Address); err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrAdressDecodeFailed, "ticket: %d address: %s is not a valid PKr, %v", i, transfer.Address, err)
+		}
+
+		if len(transfer.Ticket) == 0 {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "ticket: %d hash is empty", i)
+		}
+
+		if exist[transfer.Ticket] {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "ticket: %s is repeated", transfer.Ticket)
+		}
+		exist[transfer.Ticket] = true
+
+		if err := ValidateMemo(transfer.Memo); err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "ticket: %d memo is invalid, %v", i, err)
+		}
+
+		transfers = append(transfers, transfer)
+	}
+
+	if len(transfers) > MaxTxOutputs-1 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "The transaction outputs over: %d", MaxTxOutputs-1)
+	}
+
+	return transfers, nil
+}
+
+//createTicketRawTransaction 创建票据转账交易单，持有票据的utxo作为输入，手续费由SERO支付。
+//票据utxo中的其它资产找零到找零地址。
+func (decoder *TransactionDecoder) createTicketRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, transfers []*TicketTransfer) error {
+
+	var (
+		accountID    = rawTx.Account.AccountID
+		outputAddrs  = make([]Out_O, 0)
+		ticketUTXO   = make([]*Unspent, 0)
+		categories   = make(map[string]bool)
+		destinations = make([]string, 0)
+		memos        = make(map[string]string)
+		txFrom       = make([]string, 0)
+		txTo         = make([]string, 0)
+	)
+
+	//获取当前最大高度
+	currentHeight, err := decoder.wm.GetBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range transfers {
+
+		u, findErr := decoder.wm.GetUnspentByTicket(t.Ticket)
+		if findErr != nil || u.TK != accountID {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "ticket: %s is not owned by account: %s", t.Ticket, accountID)
+		}
+
+		if u.Sending {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "ticket: %s is locked by other transaction", t.Ticket)
+		}
+
+		if currentHeight-u.Height <= MinConfirms {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "ticket: %s confirmations is not enough", t.Ticket)
+		}
+
+		ticketUTXO = append(ticketUTXO, u)
+		categories[u.Category] = true
+
+		outputAddrs = append(outputAddrs, Out_O{
+			Asset: Asset{
+				Tkt: &Ticket{
+					Category: u.Category,
+					Value:    u.Ticket,
+				},
+			},
+			Addr: t.Address,
+			Memo: t.Memo,
+		})
+		destinations = append(destinations, fmt.Sprintf("%s(%s %s)", t.Address, u.Category, u.Ticket))
+		if len(t.Memo) > 0 {
+			memos[t.Address] = t.Memo
+		}
+		txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, u.Ticket))
+		txTo = append(txTo, fmt.Sprintf("%s:%s", t.Address, u.Ticket))
+	}
+
+	//查找账户的主币utxo支付手续费
+	mainUnspents, err := decoder.wm.ListUnspent(accountID, decoder.wm.Symbol(), 0, -1)
+	if err != nil {
+		return err
+	}
+
+	feesRate, _ := decimal.NewFromString(rawTx.FeeRate)
+
+	//输出为票据和找零，币种为票据类别和SERO
+	feeUTXO, fees, feesRate, gas, err := decoder.selectFeeUnspents(feesRate, len(ticketUTXO), len(transfers)+1, len(categories)+1, decoder.availableUnspents(currentHeight, mainUnspents))
+	if err != nil {
+		return err
+	}
+
+	usedUTXO := append(append([]*Unspent{}, ticketUTXO...), feeUTXO...)
+	for _, u := range feeUTXO {
+		ua, _ := decimal.NewFromString(u.Value)
+		txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, ua.Shift(-decoder.wm.Decimal()).String()))
+	}
+
+	rawTx.To = make(map[string]string)
+
+	//找零地址
+	changeAddress, err := decoder.getChangeAddress(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
+	rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("From Account: %s", accountID)
+	decoder.wm.Log.Std.Notice("To Tickets: %s", strings.Join(destinations, ", "))
+	decoder.wm.Log.Std.Notice("Fees: %v", fees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Gas: %v", gas)
+	decoder.wm.Log.Std.Notice("Change Address: %v", changeAddress)
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	txStruct, err := decoder.wm.GenTxParam(changeAddress, accountID, decoder.wm.Decimal(), feesRate, gas, usedUTXO, outputAddrs)
+	if err != nil {
+		return err
+	}
+
+	rawTx.RawHex = txStruct.Raw
+
+	//记录交易结构概要，用于验证签名后的交易
+	err = decoder.setRawTransactionOutline(rawTx, txStruct, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	//装配签名
+	err = decoder.setRawTransactionSignatures(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	decoder.setRawTransactionMemos(rawTx, memos)
+
+	//记录找零输出
+	decoder.setRawTransactionChange(rawTx, changeAddress)
+
+	rawTx.IsBuilt = true
+	rawTx.TxAmount = decimal.Zero.Sub(fees).StringFixed(decoder.wm.Decimal())
+	rawTx.TxFrom = txFrom
+	rawTx.TxTo = txTo
+
+	return nil
+}
@@ -0,0 +1,283 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/sero-cash/go-sero/common/hexutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TokenSourceConfig = "config" //配置文件登记的代币
+	TokenSourceNode   = "node"   //节点查询的代币
+)
+
+//TokenInfo 代币登记信息，SERO的代币以币种名称作为合约地址
+type TokenInfo struct {
+	Currency string `json:"currency" storm:"id"` //币种名称
+	ID       string `json:"id"`                  //币种Id
+	Name     string `json:"name"`                //显示名称
+	Decimals int32  `json:"decimals"`            //精度
+	Source   string `json:"source"`              //来源：config，node
+	UpdateAt int64  `json:"updateAt"`
+}
+
+//parseTokensConfig 解析配置的代币列表，格式为：币种:精度[:名称]，多个代币用逗号分隔
+func parseTokensConfig(value string) ([]*TokenInfo, error) {
+
+	tokens := make([]*TokenInfo, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		fields := strings.SplitN(item, ":", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("token config: %s is invalid, format is currency:decimals[:name]", item)
+		}
+		currency := strings.TrimSpace(fields[0])
+		decimals, err := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 32)
+		if len(currency) == 0 || err != nil || decimals < 0 {
+			return nil, fmt.Errorf("token config: %s is invalid, format is currency:decimals[:name]", item)
+		}
+		name := currency
+		if len(fields) == 3 && len(strings.TrimSpace(fields[2])) > 0 {
+			name = strings.TrimSpace(fields[2])
+		}
+		tokens = append(tokens, &TokenInfo{
+			Currency: currency,
+			Name:     name,
+			Decimals: int32(decimals),
+			Source:   TokenSourceConfig,
+		})
+	}
+
+	return tokens, nil
+}
+
+//loadTokenRegistry 登记配置文件中的代币，配置的精度和名称覆盖已有记录
+func (wm *WalletManager) loadTokenRegistry() error {
+
+	tokens, err := parseTokensConfig(wm.Config.Tokens)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if exist, findErr := wm.findTokenInfo(token.Currency); findErr == nil {
+			token.ID = exist.ID
+		}
+		if err := wm.SaveTokenInfo(token); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//findTokenInfo 查询本地登记的代币
+func (wm *WalletManager) findTokenInfo(currency string) (*TokenInfo, error) {
+	var token TokenInfo
+	err := wm.unspentDB.One("Currency", currency, &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+//SaveTokenInfo 登记代币信息
+func (wm *WalletManager) SaveTokenInfo(token *TokenInfo) error {
+	if len(token.Currency) == 0 {
+		return fmt.Errorf("token currency is empty")
+	}
+	if len(token.Name) == 0 {
+		token.Name = token.Currency
+	}
+	token.UpdateAt = time.Now().Unix()
+	return wm.unspentDB.Save(token)
+}
+
+//ListTokenInfo 查询所有登记的代币
+func (wm *WalletManager) ListTokenInfo() ([]*TokenInfo, error) {
+	var list []*TokenInfo
+	err := wm.unspentDB.All(&list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return list, nil
+}
+
+//GetTokenInfo 查询代币信息，本地没有登记时从节点查询并登记，缺少币种Id时补充
+func (wm *WalletManager) GetTokenInfo(currency string) (*TokenInfo, error) {
+
+	token, err := wm.findTokenInfo(currency)
+	if err != nil {
+		return wm.RefreshTokenInfo(currency)
+	}
+
+	if len(token.ID) == 0 {
+		id, idErr := wm.LocalCurrencyToId(currency)
+		if idErr == nil {
+			token.ID = id
+			wm.SaveTokenInfo(token)
+		}
+	}
+
+	return token, nil
+}
+
+//RefreshTokenInfo 从节点查询代币的精度和币种Id，保留已登记的名称
+func (wm *WalletManager) RefreshTokenInfo(currency string) (*TokenInfo, error) {
+
+	decimals, err := wm.GetCurrencyDecimals(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := wm.LocalCurrencyToId(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &TokenInfo{
+		Currency: currency,
+		Name:     currency,
+	}
+	if exist, findErr := wm.findTokenInfo(currency); findErr == nil {
+		token = exist
+	}
+	token.ID = id
+	token.Decimals = decimals
+	token.Source = TokenSourceNode
+
+	err = wm.SaveTokenInfo(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+//RefreshTokenRegistry 从节点刷新已登记的代币，以及账户utxo中出现过的币种
+func (wm *WalletManager) RefreshTokenRegistry() ([]*TokenInfo, error) {
+
+	currencies := make(map[string]bool)
+
+	tokens, err := wm.ListTokenInfo()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		currencies[t.Currency] = true
+	}
+
+	var unspents []*Unspent
+	err = wm.unspentDB.All(&unspents)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	for _, u := range unspents {
+		if len(u.Currency) > 0 && u.Currency != wm.Symbol() {
+			currencies[u.Currency] = true
+		}
+	}
+
+	refreshed := make([]*TokenInfo, 0)
+	for currency := range currencies {
+		token, refreshErr := wm.RefreshTokenInfo(currency)
+		if refreshErr != nil {
+			wm.Log.Warningf("refresh token: %s failed, unexpected error: %v", currency, refreshErr)
+			continue
+		}
+		refreshed = append(refreshed, token)
+	}
+
+	return refreshed, nil
+}
+
+//GetCurrencyDecimals 节点查询币种的精度
+func (wm *WalletManager) GetCurrencyDecimals(currency string) (int32, error) {
+
+	request := []interface{}{
+		currency,
+	}
+
+	result, err := wm.WalletClient.Call("sero_getDecimal", request)
+	if err != nil {
+		return 0, err
+	}
+
+	decimals, err := hexutil.DecodeUint64(result.String())
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(decimals), nil
+}
+
+//CoinDecimals 币种的精度，代币优先使用Coin中的精度，没有设置时使用登记的精度
+func (wm *WalletManager) CoinDecimals(coin openwallet.Coin) int32 {
+
+	if !coin.IsContract {
+		return wm.Decimal()
+	}
+
+	if coin.Contract.Decimals > 0 {
+		return int32(coin.Contract.Decimals)
+	}
+
+	token, err := wm.GetTokenInfo(coin.Contract.Address)
+	if err != nil {
+		wm.Log.Warningf("token: %s is not registered, use the minimum unit", coin.Contract.Address)
+		return 0
+	}
+
+	return token.Decimals
+}
+
+//TokenCoin 按登记信息创建代币的币种，没有登记时精度为0，即按最小单位计算
+func (wm *WalletManager) TokenCoin(currency string) openwallet.Coin {
+
+	contractID := openwallet.GenContractID(wm.Symbol(), currency)
+	coin := openwallet.Coin{
+		Symbol:     wm.Symbol(),
+		IsContract: true,
+		ContractID: contractID,
+		Contract: openwallet.SmartContract{
+			ContractID: contractID,
+			Symbol:     wm.Symbol(),
+			Address:    currency,
+			Token:      currency,
+			Name:       currency,
+		},
+	}
+
+	token, err := wm.GetTokenInfo(currency)
+	if err != nil {
+		wm.Log.Warningf("token: %s is not registered, use the minimum unit", currency)
+		return coin
+	}
+
+	coin.Contract.Name = token.Name
+	coin.Contract.Decimals = uint64(token.Decimals)
+
+	return coin
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"testing"
+)
+
+func TestParseTokensConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []TokenInfo
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: []TokenInfo{}},
+		{name: "only separators", value: " , ,", want: []TokenInfo{}},
+		{name: "without name", value: "ABC:6", want: []TokenInfo{{Currency: "ABC", Name: "ABC", Decimals: 6}}},
+		{name: "with name", value: "AIPP:18:AIPP Token", want: []TokenInfo{{Currency: "AIPP", Name: "AIPP Token", Decimals: 18}}},
+		{name: "name with colon", value: "AIPP:18:AIPP:Token", want: []TokenInfo{{Currency: "AIPP", Name: "AIPP:Token", Decimals: 18}}},
+		{name: "empty name", value: "ABC:6: ", want: []TokenInfo{{Currency: "ABC", Name: "ABC", Decimals: 6}}},
+		{name: "zero decimals", value: "NFT:0", want: []TokenInfo{{Currency: "NFT", Name: "NFT", Decimals: 0}}},
+		{
+			name:  "multiple with spaces",
+			value: " AIPP : 18 : AIPP Token , ABC:6 ",
+			want: []TokenInfo{
+				{Currency: "AIPP", Name: "AIPP Token", Decimals: 18},
+				{Currency: "ABC", Name: "ABC", Decimals: 6},
+			},
+		},
+		{name: "missing decimals", value: "ABC", wantErr: true},
+		{name: "empty currency", value: ":6", wantErr: true},
+		{name: "invalid decimals", value: "ABC:six", wantErr: true},
+		{name: "negative decimals", value: "ABC:-1", wantErr: true},
+		{name: "one invalid item", value: "ABC:6,DEF", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTokensConfig(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTokensConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseTokensConfig() = %d tokens, want %d", len(got), len(tt.want))
+			}
+			for i, token := range got {
+				want := tt.want[i]
+				want.Source = TokenSourceConfig
+				if *token != want {
+					t.Errorf("parseTokensConfig() token %d = %+v, want %+v", i, token, want)
+				}
+			}
+		})
+	}
+}
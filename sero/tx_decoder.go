@@ -35,9 +35,64 @@ func NewTransactionDecoder(wm *WalletManager) *TransactionDecoder {
 	return &decoder
 }
 
-//CreateRawTransaction 创建交易单
+//CreateRawTransaction 创建交易单，同一Sid重复创建时返回原交易单
 func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
 
+	restored, err := decoder.restoreBuiltTx(rawTx)
+	if err != nil || restored {
+		return err
+	}
+
+	//收款地址为PK时派生一次性收款码
+	pkDestinations, err := decoder.resolvePKDestinations(rawTx)
+	if err != nil {
+		return err
+	}
+
+	//收款地址无效时不查询utxo，直接拒绝
+	err = decoder.validateRawTransactionTo(rawTx)
+	if err != nil {
+		return err
+	}
+
+	err = decoder.createRawTransaction(wrapper, rawTx)
+	if err != nil {
+		return err
+	}
+
+	err = decoder.savePKDestinations(pkDestinations)
+	if err != nil {
+		return err
+	}
+
+	//记录Sid创建的交易单，预留输入utxo
+	if len(rawTx.Sid) > 0 {
+		_, err = decoder.wm.SaveBuiltTx(rawTx)
+		if err == ErrBuiltTxExists {
+			//并发创建相同Sid，返回先保存的交易单
+			_, err = decoder.restoreBuiltTx(rawTx)
+			return err
+		}
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "save transaction of sid: %s failed, %v", rawTx.Sid, err)
+		}
+	}
+
+	return decoder.saveRawTxExt(rawTx)
+}
+
+//saveRawTxExt 在服务端记录交易单的ExtParam，验证openw-sdk提交的交易单时使用
+func (decoder *TransactionDecoder) saveRawTxExt(rawTx *openwallet.RawTransaction) error {
+	err := decoder.wm.SaveRawTxExt(rawTx)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "save transaction ext param failed, %v", err)
+	}
+	return nil
+}
+
+//createRawTransaction 创建交易单
+func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
 	var (
 		usedUTXO         = make([]*Unspent, 0)
 		outputAddrs      = make([]Out_O, 0)
@@ -56,12 +111,48 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 
 	if rawTx.Coin.IsContract {
 		currency = rawTx.Coin.Contract.Address
-		coinDecimals = int32(rawTx.Coin.Contract.Decimals)
+		coinDecimals = decoder.wm.CoinDecimals(rawTx.Coin)
 	} else {
 		currency = rawTx.Coin.Symbol
 		coinDecimals = decoder.wm.Decimal()
 	}
 
+	//指定了多币种输出
+	outputs, err := decoder.getRawTransactionOutputs(rawTx)
+	if err != nil {
+		return err
+	}
+	if len(outputs) > 0 {
+		return decoder.createMultiCurrencyRawTransaction(wrapper, rawTx, outputs)
+	}
+
+	//指定了质押指令
+	stake, err := decoder.getRawTransactionStake(rawTx)
+	if err != nil {
+		return err
+	}
+	if stake != nil {
+		return decoder.createStakeRawTransaction(wrapper, rawTx, stake)
+	}
+
+	//指定了合约调用
+	call, err := decoder.getRawTransactionContractCall(rawTx)
+	if err != nil {
+		return err
+	}
+	if call != nil {
+		return decoder.createContractCallRawTransaction(wrapper, rawTx, call)
+	}
+
+	//指定了票据转账
+	tickets, err := decoder.getRawTransactionTickets(rawTx)
+	if err != nil {
+		return err
+	}
+	if len(tickets) > 0 {
+		return decoder.createTicketRawTransaction(wrapper, rawTx, tickets)
+	}
+
 	//查找账户的代币utxo
 	unspents, err := decoder.wm.ListUnspent(accountID, currency, 0, MaxTxInputs)
 	if err != nil {
@@ -76,6 +167,11 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 		return fmt.Errorf("Receiver addresses is empty!")
 	}
 
+	memos, err := decoder.getRawTransactionMemos(rawTx)
+	if err != nil {
+		return err
+	}
+
 	//计算总发送金额
 	for addr, amount := range rawTx.To {
 		deamount, _ := decimal.NewFromString(amount)
@@ -90,6 +186,7 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 				},
 			},
 			Addr: addr,
+			Memo: memos[addr],
 		}
 
 		outputAddrs = append(outputAddrs, output)
@@ -108,8 +205,8 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 	}
 
 	feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
-	fees, feesRate, err := decoder.wm.EstimateFee(feesRate)
 	receive = totalSend
+	sendToOthers := accountTotalSent
 
 	//获取当前最大高度
 	currentHeight, err := decoder.wm.GetBlockHeight()
@@ -117,9 +214,18 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 		return err
 	}
 
+	var (
+		fees         = decimal.Zero
+		gas          = int64(0)
+		mainUnspents []*Unspent
+		ins          = 1
+		outs         = len(rawTx.To) + 1 //找零输出
+		currencies   = 1
+	)
+
 	if rawTx.Coin.IsContract {
 		//查找账户的主币的utxo
-		mainUnspents, err := decoder.wm.ListUnspent(accountID, rawTx.Coin.Symbol, 0, 50)
+		mainUnspents, err = decoder.wm.ListUnspent(accountID, rawTx.Coin.Symbol, 0, 50)
 		if err != nil {
 			return err
 		}
@@ -128,70 +234,105 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 			return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "[%s] %s balance is not enough(utxo meet 12 confirmations)", accountID, currency)
 		}
 
-		//计算SERO手续费是否足够
-		seroBalance := decimal.Zero
-		for _, u := range mainUnspents {
+		//代币交易还需要主币输入和主币找零
+		ins = ins + 1
+		outs = outs + 1
+		currencies = currencies + 1
+	}
+
+	//按输入数量预估手续费，选择的utxo超过预估的输入数量时，重新预估并选择utxo
+	for {
+
+		fees, feesRate, gas, err = decoder.wm.EstimateTxFee(feesRate, NewTxGasParam(ins, outs, currencies))
+		if err != nil {
+			return err
+		}
+
+		usedUTXO = make([]*Unspent, 0)
+		txFrom = make([]string, 0)
+		balance = decimal.Zero
+		totalSend = receive
+		accountTotalSent = sendToOthers
+
+		if rawTx.Coin.IsContract {
+
+			//计算SERO手续费是否足够
+			seroBalance := decimal.Zero
+			for _, u := range mainUnspents {
+
+				//utxo确认书必须大于6个才使用
+				if currentHeight-u.Height <= MinConfirms {
+					continue
+				}
+
+				if u.Sending == false && len(u.Ticket) == 0 {
+					ua, _ := decimal.NewFromString(u.Value)
+					ua = ua.Shift(-decoder.wm.Decimal())
+					seroBalance = seroBalance.Add(ua)
+					usedUTXO = append(usedUTXO, u)
+					if seroBalance.GreaterThanOrEqual(fees) {
+						break
+					}
+
+					//UTXO如果大于设定限制，则分拆成多笔交易单发送
+					if len(usedUTXO) > MaxTxInputs {
+						errStr := fmt.Sprintf("The transaction is use max inputs over: %d", MaxTxInputs)
+						return fmt.Errorf(errStr)
+					}
+				}
+			}
+
+			if seroBalance.LessThan(fees) {
+				return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The %s balance: %s is not enough(utxo meet 12 confirmations)", currency, balance.String())
+			}
+		} else {
+			totalSend = totalSend.Add(fees)
+			accountTotalSent = accountTotalSent.Add(fees)
+		}
 
+		//计算一个可用于支付的余额
+		for _, u := range unspents {
 			//utxo确认书必须大于6个才使用
 			if currentHeight-u.Height <= MinConfirms {
 				continue
 			}
 
-			if u.Sending == false {
+			if u.Sending == false && len(u.Ticket) == 0 {
 				ua, _ := decimal.NewFromString(u.Value)
-				ua = ua.Shift(-decoder.wm.Decimal())
-				seroBalance = seroBalance.Add(ua)
+				ua = ua.Shift(-coinDecimals)
+				balance = balance.Add(ua)
 				usedUTXO = append(usedUTXO, u)
-				if seroBalance.GreaterThanOrEqual(fees) {
-					break
+				if rawTx.Coin.IsContract {
+					txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, ua.Shift(coinDecimals).String()))
+				} else {
+					txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, ua.String()))
 				}
-
-				//UTXO如果大于设定限制，则分拆成多笔交易单发送
-				if len(usedUTXO) > MaxTxInputs {
-					errStr := fmt.Sprintf("The transaction is use max inputs over: %d", MaxTxInputs)
-					return fmt.Errorf(errStr)
+				if balance.GreaterThanOrEqual(totalSend) {
+					break
 				}
 			}
 		}
 
-		if seroBalance.LessThan(fees) {
+		if balance.LessThan(totalSend) {
+			//UTXO如果大于设定限制，则需要分拆成多笔交易单发送
+			if len(unspents) >= MaxTxInputs {
+				return decoder.createAutoSplitRawTransaction(wrapper, rawTx)
+			}
 			return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The %s balance: %s is not enough(utxo meet 12 confirmations)", currency, balance.String())
 		}
-	} else {
-		totalSend = totalSend.Add(fees)
-		accountTotalSent = accountTotalSent.Add(fees)
-	}
 
-	//计算一个可用于支付的余额
-	for _, u := range unspents {
-		//utxo确认书必须大于6个才使用
-		if currentHeight-u.Height <= MinConfirms {
-			continue
-		}
-
-		if u.Sending == false {
-			ua, _ := decimal.NewFromString(u.Value)
-			ua = ua.Shift(-coinDecimals)
-			balance = balance.Add(ua)
-			usedUTXO = append(usedUTXO, u)
-			if rawTx.Coin.IsContract {
-				txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, ua.Shift(coinDecimals).String()))
-			} else {
-				txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, ua.String()))
-			}
-			if balance.GreaterThanOrEqual(totalSend) {
-				break
-			}
+		if len(usedUTXO) <= ins {
+			break
 		}
+		ins = len(usedUTXO)
 	}
 
-	if balance.LessThan(totalSend) {
-		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The %s balance: %s is not enough(utxo meet 12 confirmations)", currency, balance.String())
+	//找零地址
+	changeAddress, err := decoder.getChangeAddress(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return err
 	}
 
-	//取账户最后一个地址
-	changeAddress := usedUTXO[0].Address
-
 	changeAmount := balance.Sub(totalSend)
 	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
 	rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
@@ -199,43 +340,34 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 	decoder.wm.Log.Std.Notice("-----------------------------------------------")
 	decoder.wm.Log.Std.Notice("From Account: %s", accountID)
 	decoder.wm.Log.Std.Notice("To Address: %s", strings.Join(destinations, ", "))
+	for pkr, pk := range decoder.getRawTransactionPKDestinations(rawTx) {
+		decoder.wm.Log.Std.Notice("  PK: %s -> PKr: %s", pk, pkr)
+	}
 	decoder.wm.Log.Std.Notice("Use: %v", balance.StringFixed(decoder.wm.Decimal()))
 	decoder.wm.Log.Std.Notice("Fees: %v", fees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Gas: %v", gas)
 	decoder.wm.Log.Std.Notice("Receive: %v", receive.StringFixed(decoder.wm.Decimal()))
 	decoder.wm.Log.Std.Notice("Change: %v", changeAmount.StringFixed(decoder.wm.Decimal()))
 	decoder.wm.Log.Std.Notice("Change Address: %v", changeAddress)
 	decoder.wm.Log.Std.Notice("-----------------------------------------------")
 
-	txStruct, err := decoder.wm.GenTxParam(changeAddress, accountID, coinDecimals, feesRate, usedUTXO, outputAddrs)
+	txStruct, err := decoder.wm.GenTxParam(changeAddress, accountID, coinDecimals, feesRate, gas, usedUTXO, outputAddrs)
 	if err != nil {
 		return err
 	}
 
 	rawTx.RawHex = txStruct.Raw
 
-	if rawTx.Signatures == nil {
-		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	//记录交易结构概要，用于验证签名后的交易
+	err = decoder.setRawTransactionOutline(rawTx, txStruct, usedUTXO)
+	if err != nil {
+		return err
 	}
 
 	//装配签名
-	keySigs := make([]*openwallet.KeySignature, 0)
-
-	for _, u := range usedUTXO {
-
-		addr, err := wrapper.GetAddress(u.Address)
-		if err != nil {
-			return err
-		}
-
-		signature := openwallet.KeySignature{
-			EccType: decoder.wm.Config.CurveType,
-			Nonce:   "",
-			Address: addr,
-			Message: u.Root,
-		}
-
-		keySigs = append(keySigs, &signature)
-
+	err = decoder.setRawTransactionSignatures(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return err
 	}
 
 	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
@@ -244,7 +376,16 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 		accountTotalSent = accountTotalSent.Shift(coinDecimals)
 	}
 
-	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
+	//记录找零输出
+	decoder.setRawTransactionChange(rawTx, changeAddress)
+	if changeAmount.GreaterThan(decimal.Zero) {
+		if rawTx.Coin.IsContract {
+			txTo = append(txTo, fmt.Sprintf("%s:%s", changeAddress, changeAmount.Shift(coinDecimals)))
+		} else {
+			txTo = append(txTo, fmt.Sprintf("%s:%s", changeAddress, changeAmount.String()))
+		}
+	}
+
 	rawTx.IsBuilt = true
 	rawTx.TxAmount = accountTotalSent.StringFixed(decoder.wm.Decimal())
 	rawTx.TxFrom = txFrom
@@ -256,6 +397,11 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 //SignRawTransaction 签名交易单
 func (decoder *TransactionDecoder) SignRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
 
+	//观察账户没有私钥
+	if err := decoder.wm.checkCanSign(rawTx.Account.AccountID); err != nil {
+		return err
+	}
+
 	account, err := wrapper.GetAssetsAccountInfo(rawTx.Account.AccountID)
 	if err != nil {
 		return err
@@ -286,8 +432,26 @@ func (decoder *TransactionDecoder) SignRawTransaction(wrapper openwallet.WalletD
 	return nil
 }
 
-//VerifyRawTransaction 验证交易单，验证交易单并返回加入签名后的交易单
+//VerifyRawTransaction 验证交易单，验证签名后的交易的输入、输出、手续费和签名是否与交易单一致
 func (decoder *TransactionDecoder) VerifyRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
+	if rawTx.Account == nil {
+		return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "verify transaction failed, transaction account is empty")
+	}
+
+	//openw-sdk提交的交易单没有ExtParam，从服务端记录恢复
+	err := decoder.wm.restoreRawTxExt(rawTx)
+	if err != nil {
+		decoder.wm.Log.Errorf("[Sid: %s] restore transaction ext param failed, %v", rawTx.Sid, err)
+		return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "verify transaction failed, %v", err)
+	}
+
+	err = decoder.verifySignedTransaction(rawTx)
+	if err != nil {
+		decoder.wm.Log.Errorf("[Sid: %s] verify transaction failed, %v", rawTx.Sid, err)
+		return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "verify transaction failed, %v", err)
+	}
+
 	rawTx.IsCompleted = true
 	return nil
 }
@@ -314,6 +478,23 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransaction(wrapper openwalle
 
 // CreateSummaryRawTransactionWithError 创建汇总交易，返回能原始交易单数组（包含带错误的原始交易单）
 func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
+	rawTxArray, err := decoder.createSummaryRawTransactionWithError(wrapper, sumRawTx)
+	if err != nil {
+		return nil, err
+	}
+	for _, rawTxWithErr := range rawTxArray {
+		if rawTxWithErr.Error != nil || rawTxWithErr.RawTx == nil {
+			continue
+		}
+		if saveErr := decoder.wm.SaveRawTxExt(rawTxWithErr.RawTx); saveErr != nil {
+			rawTxWithErr.Error = openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "save transaction ext param failed, %v", saveErr)
+		}
+	}
+	return rawTxArray, nil
+}
+
+//createSummaryRawTransactionWithError 创建汇总交易
+func (decoder *TransactionDecoder) createSummaryRawTransactionWithError(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
 
 	var (
 		outputAddrs      = make([]Out_O, 0)
@@ -333,7 +514,7 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper
 
 	if sumRawTx.Coin.IsContract {
 		currency = sumRawTx.Coin.Contract.Address
-		coinDecimals = int32(sumRawTx.Coin.Contract.Decimals)
+		coinDecimals = decoder.wm.CoinDecimals(sumRawTx.Coin)
 	} else {
 		currency = sumRawTx.Coin.Symbol
 		coinDecimals = decoder.wm.Decimal()
@@ -343,6 +524,15 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper
 		return nil, fmt.Errorf("summary address is empty!")
 	}
 
+	if err := validatePKr(sumRawTx.SummaryAddress); err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrAdressDecodeFailed, "summary address: %s is invalid, %v", sumRawTx.SummaryAddress, err)
+	}
+
+	//汇总账户所有币种
+	if isSummaryAllCurrencies(sumRawTx) {
+		return decoder.createSummaryAllCurrencies(wrapper, sumRawTx)
+	}
+
 	//查找账户的代币utxo
 	tokenUnspents, err := decoder.wm.ListUnspent(accountID, currency, sumRawTx.AddressStartIndex, sumRawTx.AddressLimit)
 	if err != nil {
@@ -369,7 +559,7 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper
 			continue
 		}
 
-		if u.Sending == false {
+		if u.Sending == false && len(u.Ticket) == 0 {
 			ua, _ := decimal.NewFromString(u.Value)
 			ua = ua.Shift(-coinDecimals)
 			balance = balance.Add(ua)
@@ -384,30 +574,51 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper
 		}
 	}
 
+	var (
+		fees = decimal.Zero
+		gas  = int64(0)
+	)
+
 	feesRate, _ = decimal.NewFromString(sumRawTx.FeeRate)
-	fees, feesRate, err := decoder.wm.EstimateFee(feesRate)
 
 	if sumRawTx.Coin.IsContract {
 
 		sumAmount = balance
 
-		//查找账户的代币utxo
-		symbolUnspents, err := decoder.wm.ListUnspent(accountID, decoder.wm.Symbol(), sumRawTx.AddressStartIndex, sumRawTx.AddressLimit)
+		//超过最低转账额才发送
+		if balance.LessThan(minTransfer) || len(usedUTXO) == 0 {
+			return rawTxArray, nil
+		}
+
+		//查找账户的主币utxo
+		symbolUnspents, err := decoder.wm.ListUnspent(accountID, decoder.wm.Symbol(), 0, -1)
 		if err != nil {
 			return nil, err
 		}
 
-		//查找足够付费的utxo
-		supportUnspent, supportErr := decoder.getUTXOSatisfyAmount(currentHeight, symbolUnspents, fees)
-		if supportErr != nil {
-			return nil, supportErr
+		//查找足够付费的utxo，代币汇总还需要主币输入和主币找零
+		feeUTXO, feeEstimate, rate, feeGas, feeErr := decoder.selectFeeUnspents(feesRate, len(usedUTXO), 2, 2, decoder.availableUnspents(currentHeight, symbolUnspents))
+		if feeErr != nil {
+			//有手续费账户支持，创建手续费支持交易单，到账确认后再汇总
+			if sumRawTx.FeesSupportAccount != nil && isInsufficientFees(feeErr) {
+				if decoder.waitingFeesSupport(accountID, currentHeight, feeEstimate) {
+					return rawTxArray, nil
+				}
+				supportTx, supportErr := decoder.createFeesSupportRawTransaction(wrapper, sumRawTx, usedUTXO[0].Address, feeEstimate)
+				if supportErr != nil {
+					return nil, supportErr
+				}
+				return append(rawTxArray, supportTx), nil
+			}
+			return nil, feeErr
 		}
 
+		fees, feesRate, gas = feeEstimate, rate, feeGas
+
 		//手续费地址utxo作为输入
-		usedUTXO = append(usedUTXO, supportUnspent)
+		usedUTXO = append(usedUTXO, feeUTXO...)
 
-		supportAmount, _ := decimal.NewFromString(supportUnspent.Value)
-		supportAmount = supportAmount.Shift(-decoder.wm.Decimal())
+		supportAmount := summaryBalance(feeUTXO, decoder.wm.Decimal())
 
 		//多余的主币找零到汇总地址
 		if supportAmount.GreaterThan(fees) {
@@ -425,6 +636,10 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper
 		}
 
 	} else {
+		fees, feesRate, gas, err = decoder.wm.EstimateTxFee(feesRate, NewTxGasParam(len(usedUTXO), 1, 1))
+		if err != nil {
+			return nil, err
+		}
 		sumAmount = balance.Sub(fees)
 	}
 
@@ -466,15 +681,9 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper
 	decoder.wm.Log.Std.Notice("Summary Address: %s", sumRawTx.SummaryAddress)
 	decoder.wm.Log.Std.Notice("Summary Amount: %v", sumAmount.String())
 	decoder.wm.Log.Std.Notice("Fees: %v", fees.String())
+	decoder.wm.Log.Std.Notice("Gas: %v", gas)
 	decoder.wm.Log.Std.Notice("-----------------------------------------------")
 
-	changeAddress := usedUTXO[0].Address
-
-	txStruct, err := decoder.wm.GenTxParam(changeAddress, accountID, coinDecimals, feesRate, usedUTXO, outputAddrs)
-	if err != nil {
-		return nil, err
-	}
-
 	//创建一笔交易单
 	rawTx := &openwallet.RawTransaction{
 		Coin:     sumRawTx.Coin,
@@ -485,31 +694,30 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper
 		Required: 1,
 	}
 
-	rawTx.RawHex = txStruct.Raw
-
-	if rawTx.Signatures == nil {
-		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	//找零地址
+	changeAddress, err := decoder.getChangeAddress(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return nil, err
 	}
 
-	//装配签名
-	keySigs := make([]*openwallet.KeySignature, 0)
-
-	for _, u := range usedUTXO {
-
-		addr, err := wrapper.GetAddress(u.Address)
-		if err != nil {
-			return nil, err
-		}
+	txStruct, err := decoder.wm.GenTxParam(changeAddress, accountID, coinDecimals, feesRate, gas, usedUTXO, outputAddrs)
+	if err != nil {
+		return nil, err
+	}
 
-		signature := openwallet.KeySignature{
-			EccType: decoder.wm.Config.CurveType,
-			Nonce:   "",
-			Address: addr,
-			Message: u.Root,
-		}
+	rawTx.RawHex = txStruct.Raw
+	decoder.setRawTransactionChange(rawTx, changeAddress)
 
-		keySigs = append(keySigs, &signature)
+	//记录交易结构概要，用于验证签名后的交易
+	err = decoder.setRawTransactionOutline(rawTx, txStruct, usedUTXO)
+	if err != nil {
+		return nil, err
+	}
 
+	//装配签名
+	err = decoder.setRawTransactionSignatures(wrapper, rawTx, usedUTXO)
+	if err != nil {
+		return nil, err
 	}
 
 	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
@@ -518,7 +726,6 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper
 		accountTotalSent = accountTotalSent.Shift(coinDecimals)
 	}
 
-	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
 	rawTx.IsBuilt = true
 	rawTx.TxAmount = accountTotalSent.String()
 	rawTx.TxFrom = txFrom
@@ -546,9 +753,35 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 		return nil, fmt.Errorf("transaction is not completed validation")
 	}
 
+	//广播前记录交易，锁定输入utxo
+	pending, err := decoder.wm.AddPendingTx(rawTx)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "lock transaction inputs failed, %v", err)
+	}
+
+	//Sid创建的交易单记录为已广播
+	err = decoder.wm.SubmitBuiltTx(rawTx, pending.TxID)
+	if err != nil {
+		decoder.wm.Log.Warningf("[Sid: %s] record submitted transaction: %s failed, unexpected error: %v", rawTx.Sid, pending.TxID, err)
+	}
+
 	txid, err := decoder.wm.CommitTx(rawTx.RawHex)
+	if IsKnownTxError(err) {
+		//交易已被提交过，保持等待上链
+		decoder.wm.Log.Warningf("[Sid: %s] transaction: %s is already known by node: %v", rawTx.Sid, pending.TxID, err)
+		txid, err = pending.TxID, nil
+	}
 	if err != nil {
 		decoder.wm.Log.Warningf("[Sid: %s] submit raw hex: %s", rawTx.Sid, rawTx.RawHex)
+		if IsRejectedError(err) {
+			//节点拒绝了交易，释放输入utxo
+			releaseErr := decoder.wm.ReleasePendingTx(pending.TxID, err.Error())
+			if releaseErr != nil {
+				decoder.wm.Log.Errorf("ReleasePendingTx failed, error: %v", releaseErr)
+			}
+		} else {
+			decoder.wm.Log.Warningf("[Sid: %s] transaction: %s submit result is unknown, inputs stay locked until it is included or rejected", rawTx.Sid, pending.TxID)
+		}
 		return nil, err
 	}
 
@@ -558,7 +791,7 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 	decimals := int32(0)
 	fees := "0"
 	if rawTx.Coin.IsContract {
-		decimals = int32(rawTx.Coin.Contract.Decimals)
+		decimals = decoder.wm.CoinDecimals(rawTx.Coin)
 		fees = "0"
 	} else {
 		decimals = int32(decoder.wm.Decimal())
@@ -580,56 +813,118 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 
 	tx.WxID = openwallet.GenTransactionWxID(tx)
 
-	//广播成功后标记utxo已发送
-	keySignatures := rawTx.Signatures[rawTx.Account.AccountID]
-	if keySignatures != nil {
-		for _, keySignature := range keySignatures {
-			lockErr := decoder.wm.LockUnspent(keySignature.Message)
-			if lockErr != nil {
-				decoder.wm.Log.Errorf("LockUnspent failed, error: %v", lockErr)
-			}
-		}
-	}
-
 	return tx, nil
 }
 
-//GetRawTransactionFeeRate 获取交易单的费率
+//GetRawTransactionFeeRate 获取一笔普通交易（一个输入，收款和找零两个输出）按gasEstimateMode预估的手续费，单位为TX。
+//交易单的FeeRate仍为汽油价格，为空时使用节点的汽油价格
 func (decoder *TransactionDecoder) GetRawTransactionFeeRate() (string, string, error) {
-	_, feeRate, err := decoder.wm.EstimateFee(decimal.Zero)
+	fees, _, err := decoder.wm.EstimateFee(decimal.Zero)
 	if err != nil {
 		return "", "", err
 	}
 
-	return feeRate.String(), "Gas", nil
+	return fees.String(), "TX", nil
 }
 
-// getAssetsAccountUnspentSatisfyAmount
-func (decoder *TransactionDecoder) getUTXOSatisfyAmount(currentHeight uint64, unspents []*Unspent, amount decimal.Decimal) (*Unspent, *openwallet.Error) {
+//EstimateRawTransactionFee 按交易单的收款数量和需要选择的utxo数量预估手续费，记录到交易单的Fees和FeeRate，
+//汽油按配置的gasEstimateMode计算
+func (decoder *TransactionDecoder) EstimateRawTransactionFee(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
 
-	var utxo *Unspent
+	var (
+		accountID    = rawTx.Account.AccountID
+		currency     = rawTx.Coin.Symbol
+		coinDecimals = decoder.wm.Decimal()
+		totalSend    = decimal.Zero
+		fees         = decimal.Zero
+	)
 
-	if unspents != nil {
-		for _, u := range unspents {
+	if len(rawTx.To) == 0 {
+		return fmt.Errorf("Receiver addresses is empty!")
+	}
 
-			//utxo确认书必须大于6个才使用
-			if currentHeight-u.Height <= MinConfirms {
-				continue
-			}
+	if rawTx.Coin.IsContract {
+		currency = rawTx.Coin.Contract.Address
+		coinDecimals = decoder.wm.CoinDecimals(rawTx.Coin)
+	}
 
-			if !u.Sending {
-				ua, _ := decimal.NewFromString(u.Value)
-				if ua.GreaterThanOrEqual(amount) {
-					utxo = u
-					break
-				}
-			}
+	for _, amount := range rawTx.To {
+		a, _ := decimal.NewFromString(amount)
+		totalSend = totalSend.Add(a)
+	}
+
+	feesRate, _ := decimal.NewFromString(rawTx.FeeRate)
+	feesRate, err := decoder.wm.EstimateFeeRate(feesRate)
+	if err != nil {
+		return err
+	}
+	estimate := decoder.splitFeeEstimator(rawTx.Coin.IsContract, feesRate)
+
+	currentHeight, err := decoder.wm.GetBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	unspents, err := decoder.wm.ListUnspent(accountID, currency, 0, -1)
+	if err != nil {
+		return err
+	}
+	available := decoder.availableUnspents(currentHeight, unspents)
+
+	//按输入数量预估手续费，选择的utxo超过预估的输入数量时重新预估，代币另有一个SERO手续费输入
+	for ins := 1; ; {
+		fees, _, err = estimate(ins, len(rawTx.To))
+		if err != nil {
+			return err
+		}
+		need := totalSend
+		if !rawTx.Coin.IsContract {
+			need = need.Add(fees)
+		}
+		used, _, _ := takeUnspents(available, need, coinDecimals)
+		count := len(used)
+		if rawTx.Coin.IsContract {
+			count++
 		}
+		if count <= ins {
+			break
+		}
+		ins = count
+	}
+
+	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
+	rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
+
+	return nil
+}
+
+//setRawTransactionSignatures 装配交易单待签名的utxo
+func (decoder *TransactionDecoder) setRawTransactionSignatures(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, usedUTXO []*Unspent) error {
+
+	if rawTx.Signatures == nil {
+		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
 	}
 
-	if utxo == nil {
-		return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "account have not available utxo")
+	keySigs := make([]*openwallet.KeySignature, 0)
+
+	for _, u := range usedUTXO {
+
+		addr, err := decoder.getSignAddress(wrapper, rawTx.Account, u.Address)
+		if err != nil {
+			return err
+		}
+
+		signature := openwallet.KeySignature{
+			EccType: decoder.wm.Config.CurveType,
+			Nonce:   "",
+			Address: addr,
+			Message: u.Root,
+		}
+
+		keySigs = append(keySigs, &signature)
 	}
 
-	return utxo, nil
+	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
+
+	return nil
 }
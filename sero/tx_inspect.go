@@ -0,0 +1,249 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/mr-tron/base58"
+	"github.com/sero-cash/go-sero/common/hexutil"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+	"strings"
+)
+
+//DecodedTransaction 解析后的交易，flight_genTxParam的结果或签名后的交易
+type DecodedTransaction struct {
+	Signed   bool               `json:"signed"`   //是否已签名
+	Hash     string             `json:"hash"`     //交易hash，签名后才有
+	From     string             `json:"from"`     //找零收款码PKr
+	Gas      uint64             `json:"gas"`      //汽油
+	GasPrice string             `json:"gasPrice"` //汽油价格
+	Fees     string             `json:"fees"`     //手续费
+	Ins      []*DecodedTxInput  `json:"ins"`
+	Outs     []*DecodedTxOutput `json:"outs"`
+}
+
+//DecodedTxInput 解析后的交易输入，RawHex不含输入金额，
+//金额取自交易单ExtParam记录的交易概要，没有概要时查询本地未花记录
+type DecodedTxInput struct {
+	Root     string `json:"root"`
+	Address  string `json:"address"`
+	Currency string `json:"currency"`
+	Value    string `json:"value"` //最小单位
+	Found    bool   `json:"found"` //输入金额是否已知
+}
+
+//DecodedTxOutput 解析后的交易输出，签名后的匿名输出没有币种和金额
+type DecodedTxOutput struct {
+	Address  string `json:"address"`
+	Currency string `json:"currency"`
+	Value    string `json:"value"` //最小单位
+	Category string `json:"category,omitempty"`
+	Ticket   string `json:"ticket,omitempty"`
+	Memo     string `json:"memo"`
+	IsPublic bool   `json:"isPublic"`
+	PK       string `json:"pk,omitempty"` //收款码由PK派生时的PK
+}
+
+//DecodeRawTransactionJSON 解析交易单json，可以是完整的openwallet.RawTransaction，也可以只是RawHex。
+//完整交易单的输入金额取自ExtParam中的交易概要，不依赖本地数据库
+func (wm *WalletManager) DecodeRawTransactionJSON(data string) (*DecodedTransaction, error) {
+
+	if !gjson.Valid(data) {
+		return nil, fmt.Errorf("raw transaction is not valid json")
+	}
+
+	result := gjson.Parse(data)
+	if !result.Get("rawHex").Exists() {
+		return wm.DecodeRawTransaction(data)
+	}
+
+	var rawTx openwallet.RawTransaction
+	err := json.Unmarshal([]byte(data), &rawTx)
+	if err != nil {
+		return nil, fmt.Errorf("raw transaction is invalid, %v", err)
+	}
+	return wm.DecodeOpenwRawTransaction(&rawTx)
+}
+
+//DecodeOpenwRawTransaction 解析交易单，输入金额取自ExtParam中的交易概要
+func (wm *WalletManager) DecodeOpenwRawTransaction(rawTx *openwallet.RawTransaction) (*DecodedTransaction, error) {
+	var inputs []*TxInputBrief
+	decoder := NewTransactionDecoder(wm)
+	if outline, err := decoder.getRawTransactionOutline(rawTx); err == nil {
+		inputs = outline.Inputs
+	}
+	return wm.decodeRawHex(rawTx.RawHex, inputs)
+}
+
+//DecodeRawTransaction 解析交易单的RawHex，得到可读的交易概要，输入金额查询本地未花记录
+func (wm *WalletManager) DecodeRawTransaction(rawHex string) (*DecodedTransaction, error) {
+	return wm.decodeRawHex(rawHex, nil)
+}
+
+//decodeRawHex 解析RawHex，inputs为交易概要记录的输入，找不到的输入再查询本地未花记录
+func (wm *WalletManager) decodeRawHex(rawHex string, inputs []*TxInputBrief) (*DecodedTransaction, error) {
+
+	if !gjson.Valid(rawHex) {
+		return nil, fmt.Errorf("raw transaction is not valid json")
+	}
+
+	result := gjson.Parse(rawHex)
+	if !result.IsObject() {
+		return nil, fmt.Errorf("raw transaction is not json object")
+	}
+
+	var (
+		decoded = &DecodedTransaction{
+			Ins:  make([]*DecodedTxInput, 0),
+			Outs: make([]*DecodedTxOutput, 0),
+		}
+		roots      = make([]string, 0)
+		currencies = make(map[string]string)
+		fees       = decimal.Zero
+	)
+
+	//币种Id转为币种名称
+	currencyName := func(id string) string {
+		if len(id) == 0 {
+			return ""
+		}
+		if name, exist := currencies[id]; exist {
+			return name
+		}
+		name, err := wm.LocalIdToCurrency(id)
+		if err != nil || len(name) == 0 {
+			name = id
+		}
+		currencies[id] = name
+		return name
+	}
+
+	gas, err := parseHexOrNumber(result.Get("Gas"))
+	if err != nil {
+		return nil, fmt.Errorf("raw transaction gas is invalid, %v", err)
+	}
+	decoded.Gas = uint64(gas.IntPart())
+
+	gasPrice, err := parseHexOrNumber(result.Get("GasPrice"))
+	if err != nil {
+		return nil, fmt.Errorf("raw transaction gas price is invalid, %v", err)
+	}
+	decoded.GasPrice = gasPrice.Shift(-wm.Decimal()).String()
+
+	tx := result.Get("Tx")
+	if tx.IsObject() {
+		//签名后的交易
+		decoded.Signed = true
+		decoded.Hash = result.Get("Hash").String()
+		decoded.From = hexToBase58(tx.Get("From").String())
+
+		fees, err = parseHexOrNumber(tx.Get("Fee.Value"))
+		if err != nil {
+			return nil, fmt.Errorf("raw transaction fee is invalid, %v", err)
+		}
+
+		roots = signedTxRoots(&result)
+
+		outputs, err := signedTxOutputs(tx)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range outputs {
+			decoded.Outs = append(decoded.Outs, &DecodedTxOutput{
+				Address:  o.Address,
+				Currency: currencyName(o.Currency),
+				Value:    o.Value,
+				Ticket:   o.Ticket,
+				IsPublic: o.IsPublic,
+			})
+		}
+	} else {
+		//flight_genTxParam的结果
+		from := result.Get("From")
+		if from.IsObject() {
+			decoded.From = hexToBase58(from.Get("PKr").String())
+		} else {
+			decoded.From = hexToBase58(from.String())
+		}
+
+		fees = gas.Mul(gasPrice)
+
+		outline, err := NewTxOutline(&result)
+		if err != nil {
+			return nil, err
+		}
+		roots = outline.Ins
+
+		for i, o := range outline.Outs {
+			decoded.Outs = append(decoded.Outs, &DecodedTxOutput{
+				Address:  o.Address,
+				Currency: currencyName(o.Currency),
+				Value:    o.Value,
+				Category: currencyName(o.Category),
+				Ticket:   o.Ticket,
+				Memo:     DecodeMemo(result.Get(fmt.Sprintf("Outs.%d.Memo", i)).String()),
+				IsPublic: false,
+			})
+		}
+	}
+
+	decoded.Fees = fees.Shift(-wm.Decimal()).String()
+
+	//本地派生的收款码显示对应的PK
+	for _, out := range decoded.Outs {
+		if dest, _ := wm.GetPKDestination(out.Address); dest != nil {
+			out.PK = dest.PK
+		}
+	}
+
+	briefs := make(map[string]*TxInputBrief)
+	for _, in := range inputs {
+		briefs[strings.ToLower(in.Root)] = in
+	}
+
+	for _, root := range roots {
+		input := &DecodedTxInput{Root: root}
+		if brief, exist := briefs[strings.ToLower(root)]; exist {
+			input.Address = brief.Address
+			input.Currency = brief.Currency
+			input.Value = brief.Value
+			input.Found = true
+		} else if utxo, findErr := wm.GetUnspent(root); findErr == nil {
+			input.Address = utxo.Address
+			input.Currency = utxo.Currency
+			input.Value = utxo.Value
+			input.Found = true
+		}
+		decoded.Ins = append(decoded.Ins, input)
+	}
+
+	return decoded, nil
+}
+
+//hexToBase58 hex编码转为base58编码，用于收款码的显示
+func hexToBase58(str string) string {
+	if len(str) == 0 {
+		return ""
+	}
+	data, err := hexutil.Decode(str)
+	if err != nil {
+		return str
+	}
+	return base58.Encode(data)
+}
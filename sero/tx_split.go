@@ -0,0 +1,911 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/blocktree/openwallet/common"
+	"github.com/blocktree/openwallet/crypto"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"sort"
+	"time"
+)
+
+const (
+	SplitModeParallel    = "parallel"    //拆分成多笔并行支付的交易单
+	SplitModeConsolidate = "consolidate" //先合并utxo，再发起支付
+
+	splitPlanExtKey    = "splitPlan"    //交易单ExtParam中记录拆分计划的字段
+	splitOutputsExtKey = "splitOutputs" //consolidate模式最后一步支付记录合并输出的字段
+)
+
+//SplitPlanStep 拆分计划中的一个步骤
+type SplitPlanStep struct {
+	PlanID    string `json:"planID"`    //计划ID
+	Mode      string `json:"mode"`      //拆分模式
+	Step      int    `json:"step"`      //步骤序号，从0开始
+	Total     int    `json:"total"`     //计划总步骤数
+	DependsOn []int  `json:"dependsOn"` //依赖的步骤，这些步骤的交易确认后才能执行本步骤
+}
+
+//splitPayment 拆分后的一笔支付
+type splitPayment struct {
+	Address string
+	Amount  decimal.Decimal
+	Memo    string
+}
+
+//SplitOutput consolidate模式中合并交易的输出，最后一步支付使用它们作为输入
+type SplitOutput struct {
+	Address string `json:"address"`
+	Value   string `json:"value"` //最小单位
+}
+
+//splitChunk 拆分计划中的一笔交易单
+type splitChunk struct {
+	Inputs    []*Unspent      //支付币种的输入
+	FeeInputs []*Unspent      //代币交易单的SERO手续费输入
+	Payments  []*splitPayment //输出
+	Fees      decimal.Decimal
+	Gas       int64
+}
+
+//splitFeeFunc 按输入数量和支付输出数量预估手续费
+type splitFeeFunc func(ins, outs int) (decimal.Decimal, int64, error)
+
+//CreateSplitRawTransaction 创建拆分交易单，当所需utxo超过MaxTxInputs时，按计划拆分成多笔交易单。
+//返回的交易单按依赖顺序排列，依赖的步骤记录在ExtParam的splitPlan中。每笔交易单按实际的输入输出数量预估手续费。
+//consolidate模式下，最后一步支付依赖合并交易的输出，返回时未构建（IsBuilt = false），合并交易的输出记录在ExtParam的splitOutputs中，
+//需要等合并交易确认后，调用CreateSplitFinalRawTransaction使用这些输出构建。未构建的交易单没有RawHex和待签名消息，不能签名和广播。
+func (decoder *TransactionDecoder) CreateSplitRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, mode string) ([]*openwallet.RawTransaction, error) {
+
+	var (
+		accountID    = rawTx.Account.AccountID
+		totalSend    = decimal.Zero
+		feeUnspents  = make([]*Unspent, 0)
+		currency     = ""
+		coinDecimals = int32(0)
+	)
+
+	if mode != SplitModeParallel && mode != SplitModeConsolidate {
+		return nil, fmt.Errorf("unknown split mode: %s", mode)
+	}
+
+	if len(rawTx.To) == 0 {
+		return nil, fmt.Errorf("Receiver addresses is empty!")
+	}
+
+	if rawTx.Coin.IsContract {
+		currency = rawTx.Coin.Contract.Address
+		coinDecimals = decoder.wm.CoinDecimals(rawTx.Coin)
+	} else {
+		currency = rawTx.Coin.Symbol
+		coinDecimals = decoder.wm.Decimal()
+	}
+
+	payments, err := decoder.getSplitPayments(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range payments {
+		totalSend = totalSend.Add(p.Amount)
+	}
+
+	feesRate, _ := decimal.NewFromString(rawTx.FeeRate)
+	feesRate, err = decoder.wm.EstimateFeeRate(feesRate)
+	if err != nil {
+		return nil, err
+	}
+	estimate := decoder.splitFeeEstimator(rawTx.Coin.IsContract, feesRate)
+
+	//获取当前最大高度
+	currentHeight, err := decoder.wm.GetBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	unspents, err := decoder.wm.ListUnspent(accountID, currency, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	available := decoder.availableUnspents(currentHeight, unspents)
+	if len(available) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "[%s] %s balance is not enough", accountID, currency)
+	}
+
+	if rawTx.Coin.IsContract {
+		mainUnspents, err := decoder.wm.ListUnspent(accountID, rawTx.Coin.Symbol, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		feeUnspents = decoder.availableUnspents(currentHeight, mainUnspents)
+	}
+
+	planID := rawTx.Sid
+	if len(planID) == 0 {
+		planID = common.Bytes2Hex(crypto.SHA256([]byte(fmt.Sprintf("%s_%s_%d", accountID, currency, time.Now().UnixNano()))))
+	}
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("Split Plan: %s", planID)
+	decoder.wm.Log.Std.Notice("Split Mode: %s", mode)
+	decoder.wm.Log.Std.Notice("From Account: %s", accountID)
+	decoder.wm.Log.Std.Notice("Total Send: %v", totalSend.String())
+	decoder.wm.Log.Std.Notice("Available UTXO: %d", len(available))
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	//可用utxo不超过限制，无需合并，直接按并行模式支付
+	if mode == SplitModeConsolidate && len(available) < MaxTxInputs {
+		mode = SplitModeParallel
+	}
+
+	var rawTxArray []*openwallet.RawTransaction
+	if mode == SplitModeParallel {
+		chunks, planErr := planSplitPayouts(available, feeUnspents, payments, currency, coinDecimals, decoder.wm.Decimal(), rawTx.Coin.IsContract, estimate)
+		if planErr != nil {
+			return nil, planErr
+		}
+		rawTxArray, err = decoder.buildPlanChunks(wrapper, rawTx, currency, coinDecimals, feesRate, chunks)
+	} else {
+		rawTxArray, err = decoder.planConsolidation(wrapper, rawTx, currency, coinDecimals, feesRate, available, feeUnspents, totalSend, estimate)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//记录计划步骤
+	for i, planTx := range rawTxArray {
+		step := SplitPlanStep{
+			PlanID:    planID,
+			Mode:      mode,
+			Step:      i,
+			Total:     len(rawTxArray),
+			DependsOn: []int{},
+		}
+		//consolidate模式的最后一步依赖前面所有合并交易
+		if mode == SplitModeConsolidate && i == len(rawTxArray)-1 {
+			for j := 0; j < i; j++ {
+				step.DependsOn = append(step.DependsOn, j)
+			}
+		}
+		planTx.Sid = fmt.Sprintf("%s_%d", planID, i)
+		err = planTx.SetExtParam(splitPlanExtKey, step)
+		if err != nil {
+			return nil, err
+		}
+		err = decoder.saveRawTxExt(planTx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rawTxArray, nil
+}
+
+//createAutoSplitRawTransaction 所需utxo超过MaxTxInputs时，CreateRawTransaction按parallel模式拆分交易单。
+//只需一笔交易单时直接构建到rawTx；需要多笔时必须指定Sid，rawTx为计划的第0步，
+//其余步骤按Sid为“Sid_步骤序号”记录并预留输入utxo，使用这些Sid调用CreateRawTransaction即可取得，计划总步骤数记录在ExtParam的splitPlan中。
+func (decoder *TransactionDecoder) createAutoSplitRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
+	sid := rawTx.Sid
+	planTx := *rawTx
+	rawTxArray, err := decoder.CreateSplitRawTransaction(wrapper, &planTx, SplitModeParallel)
+	if err != nil {
+		return err
+	}
+
+	if len(rawTxArray) > 1 {
+		if len(sid) == 0 {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "The transaction is use max inputs over: %d, it needs %d transactions, set Sid to create the split plan", MaxTxInputs, len(rawTxArray))
+		}
+
+		//记录其余步骤，预留输入utxo
+		saved := make([]string, 0, len(rawTxArray)-1)
+		for _, stepTx := range rawTxArray[1:] {
+			_, err = decoder.wm.SaveBuiltTx(stepTx)
+			if err != nil {
+				for _, s := range saved {
+					decoder.wm.ReleaseBuiltTx(s)
+				}
+				return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "save split transaction of sid: %s failed, %v", stepTx.Sid, err)
+			}
+			saved = append(saved, stepTx.Sid)
+		}
+	}
+
+	//第0步使用原Sid返回，由CreateRawTransaction记录
+	*rawTx = *rawTxArray[0]
+	rawTx.Sid = sid
+	return nil
+}
+
+//getSplitPayments 按地址排序读取交易单的支付，保证计划的确定性
+func (decoder *TransactionDecoder) getSplitPayments(rawTx *openwallet.RawTransaction) ([]*splitPayment, error) {
+
+	destinations := make([]string, 0, len(rawTx.To))
+	for addr := range rawTx.To {
+		destinations = append(destinations, addr)
+	}
+	sort.Strings(destinations)
+
+	memos, err := decoder.getRawTransactionMemos(rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := make([]*splitPayment, 0, len(destinations))
+	for _, addr := range destinations {
+		if err := validatePKr(addr); err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrAdressDecodeFailed, "receiver address: %s is invalid, %v", addr, err)
+		}
+		amount, err := decimal.NewFromString(rawTx.To[addr])
+		if err != nil || amount.LessThanOrEqual(decimal.Zero) {
+			return nil, fmt.Errorf("invalid amount: %s to address: %s", rawTx.To[addr], addr)
+		}
+		payments = append(payments, &splitPayment{Address: addr, Amount: amount, Memo: memos[addr]})
+	}
+
+	return payments, nil
+}
+
+//splitFeeEstimator 按交易单的实际结构预估手续费，支付输出之外还有找零输出，代币交易单还有SERO找零输出
+func (decoder *TransactionDecoder) splitFeeEstimator(isContract bool, feesRate decimal.Decimal) splitFeeFunc {
+	return func(ins, outs int) (decimal.Decimal, int64, error) {
+		param := NewTxGasParam(ins, outs+1, 1)
+		if isContract {
+			param = NewTxGasParam(ins, outs+2, 2)
+		}
+		fees, _, gas, err := decoder.wm.EstimateTxFee(feesRate, param)
+		return fees, gas, err
+	}
+}
+
+//takeUnspents 从按金额排序的utxo列表开头取出满足金额的utxo，返回取出的utxo和剩余的utxo
+func takeUnspents(unspents []*Unspent, amount decimal.Decimal, decimals int32) ([]*Unspent, []*Unspent, bool) {
+
+	var (
+		used  = make([]*Unspent, 0)
+		total = decimal.Zero
+	)
+
+	for len(unspents) > 0 && total.LessThan(amount) {
+		u := unspents[0]
+		unspents = unspents[1:]
+		ua, _ := decimal.NewFromString(u.Value)
+		total = total.Add(ua.Shift(-decimals))
+		used = append(used, u)
+		if len(used) >= MaxTxInputs {
+			break
+		}
+	}
+
+	return used, unspents, total.GreaterThanOrEqual(amount)
+}
+
+//takeChunkFeeInputs 代币交易单选取SERO手续费输入，先按最多输入数量预留，返回预留的数量，用于限制代币输入数量
+func takeChunkFeeInputs(feeUnspents []*Unspent, outs int, feeDecimals int32, estimate splitFeeFunc) (int, error) {
+
+	boundFees, _, err := estimate(MaxTxInputs, outs)
+	if err != nil {
+		return 0, err
+	}
+
+	bound, _, ok := takeUnspents(feeUnspents, boundFees, feeDecimals)
+	if !ok {
+		return 0, openwallet.Errorf(openwallet.ErrInsufficientFees, "The balance is not enough to pay fees: %s", boundFees.String())
+	}
+
+	return len(bound), nil
+}
+
+//finishChunkFees 按本笔交易单实际的输入和输出数量计算手续费，代币交易单同时选出SERO手续费输入，返回剩余的SERO utxo
+func finishChunkFees(chunk *splitChunk, feeUnspents []*Unspent, outs, feeReserved int, feeDecimals int32, isContract bool, estimate splitFeeFunc) ([]*Unspent, error) {
+
+	fees, gas, err := estimate(len(chunk.Inputs)+feeReserved, outs)
+	if err != nil {
+		return nil, err
+	}
+
+	if isContract {
+		//手续费不超过预留时的估计，选出的utxo是预留utxo的前缀，数量不会更多
+		feeInputs, rest, ok := takeUnspents(feeUnspents, fees, feeDecimals)
+		if !ok {
+			return nil, openwallet.Errorf(openwallet.ErrInsufficientFees, "The balance is not enough to pay fees: %s", fees.String())
+		}
+		chunk.FeeInputs = feeInputs
+		feeUnspents = rest
+
+		fees, gas, err = estimate(len(chunk.Inputs)+len(feeInputs), outs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chunk.Fees = fees
+	chunk.Gas = gas
+
+	return feeUnspents, nil
+}
+
+//planSplitPayouts 每笔交易单使用不超过MaxTxInputs的utxo，按顺序分配支付，分多笔交易单并行支付
+func planSplitPayouts(
+	available, feeUnspents []*Unspent,
+	payments []*splitPayment,
+	currency string,
+	coinDecimals, feeDecimals int32,
+	isContract bool,
+	estimate splitFeeFunc) ([]*splitChunk, error) {
+
+	chunks := make([]*splitChunk, 0)
+
+	for len(payments) > 0 {
+
+		var (
+			chunk       = &splitChunk{Inputs: make([]*Unspent, 0), FeeInputs: make([]*Unspent, 0)}
+			chunkValue  = decimal.Zero
+			needed      = decimal.Zero
+			limit       = MaxTxInputs
+			feeReserved = 0
+			err         error
+		)
+
+		if len(available) == 0 {
+			return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The %s balance is not enough(utxo meet 12 confirmations)", currency)
+		}
+
+		if isContract {
+			//代币交易单需要独立的SERO手续费输入
+			feeReserved, err = takeChunkFeeInputs(feeUnspents, len(payments), feeDecimals, estimate)
+			if err != nil {
+				return nil, err
+			}
+			limit = MaxTxInputs - feeReserved
+		}
+
+		for _, p := range payments {
+			needed = needed.Add(p.Amount)
+		}
+
+		for len(available) > 0 && len(chunk.Inputs) < limit {
+			target := needed
+			if !isContract {
+				//主链币按当前输入数量计算手续费
+				fees, _, estErr := estimate(len(chunk.Inputs), len(payments))
+				if estErr != nil {
+					return nil, estErr
+				}
+				target = target.Add(fees)
+			}
+			if len(chunk.Inputs) > 0 && chunkValue.GreaterThanOrEqual(target) {
+				break
+			}
+			u := available[0]
+			available = available[1:]
+			ua, _ := decimal.NewFromString(u.Value)
+			chunkValue = chunkValue.Add(ua.Shift(-coinDecimals))
+			chunk.Inputs = append(chunk.Inputs, u)
+		}
+
+		//按剩余支付数量预估，实际输出不会更多
+		fees, _, err := estimate(len(chunk.Inputs)+feeReserved, len(payments))
+		if err != nil {
+			return nil, err
+		}
+
+		spendable := chunkValue
+		if !isContract {
+			spendable = chunkValue.Sub(fees)
+		}
+
+		if spendable.LessThanOrEqual(decimal.Zero) {
+			return nil, openwallet.Errorf(openwallet.ErrInsufficientFees, "The %s utxo is too small to pay fees: %s", currency, fees.String())
+		}
+
+		//按顺序分配本笔交易单可支付的金额
+		for len(payments) > 0 && spendable.GreaterThan(decimal.Zero) {
+			p := payments[0]
+			if p.Amount.LessThanOrEqual(spendable) {
+				chunk.Payments = append(chunk.Payments, &splitPayment{Address: p.Address, Amount: p.Amount, Memo: p.Memo})
+				spendable = spendable.Sub(p.Amount)
+				payments = payments[1:]
+			} else {
+				chunk.Payments = append(chunk.Payments, &splitPayment{Address: p.Address, Amount: spendable, Memo: p.Memo})
+				payments = append([]*splitPayment{{Address: p.Address, Amount: p.Amount.Sub(spendable), Memo: p.Memo}}, payments[1:]...)
+				spendable = decimal.Zero
+			}
+		}
+
+		feeUnspents, err = finishChunkFees(chunk, feeUnspents, len(chunk.Payments), feeReserved, feeDecimals, isContract, estimate)
+		if err != nil {
+			return nil, err
+		}
+
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+//planSplitConsolidation 计划把utxo合并到账户自己的地址，直到合并的金额足够最后一笔支付，返回合并交易和最后一笔支付的手续费
+func planSplitConsolidation(
+	available, feeUnspents []*Unspent,
+	totalSend decimal.Decimal,
+	payOuts int,
+	currency string,
+	coinDecimals, feeDecimals int32,
+	isContract bool,
+	estimate splitFeeFunc) ([]*splitChunk, decimal.Decimal, int64, error) {
+
+	var (
+		chunks       = make([]*splitChunk, 0)
+		consolidated = decimal.Zero
+	)
+
+	for {
+
+		//最后一笔支付以每笔合并交易的输出为输入，代币交易单另有一个SERO手续费输入
+		finalIns := len(chunks)
+		if isContract {
+			finalIns++
+		}
+		finalFees, finalGas, err := estimate(finalIns, payOuts)
+		if err != nil {
+			return nil, decimal.Zero, 0, err
+		}
+
+		needed := totalSend
+		if !isContract {
+			needed = needed.Add(finalFees)
+		}
+
+		if len(chunks) > 0 && consolidated.GreaterThanOrEqual(needed) {
+			if isContract {
+				//最后一笔支付也需要SERO手续费
+				if _, _, ok := takeUnspents(feeUnspents, finalFees, feeDecimals); !ok {
+					return nil, decimal.Zero, 0, openwallet.Errorf(openwallet.ErrInsufficientFees, "The balance is not enough to pay fees: %s", finalFees.String())
+				}
+			}
+			return chunks, finalFees, finalGas, nil
+		}
+
+		if len(chunks) >= MaxTxInputs {
+			return nil, decimal.Zero, 0, fmt.Errorf("The consolidated outputs is over max inputs: %d", MaxTxInputs)
+		}
+
+		if len(available) == 0 {
+			return nil, decimal.Zero, 0, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The %s balance is not enough(utxo meet 12 confirmations)", currency)
+		}
+
+		var (
+			chunk       = &splitChunk{Inputs: make([]*Unspent, 0), FeeInputs: make([]*Unspent, 0)}
+			chunkValue  = decimal.Zero
+			limit       = MaxTxInputs
+			feeReserved = 0
+		)
+
+		if isContract {
+			feeReserved, err = takeChunkFeeInputs(feeUnspents, 1, feeDecimals, estimate)
+			if err != nil {
+				return nil, decimal.Zero, 0, err
+			}
+			limit = MaxTxInputs - feeReserved
+		}
+
+		for len(available) > 0 && len(chunk.Inputs) < limit {
+			u := available[0]
+			available = available[1:]
+			ua, _ := decimal.NewFromString(u.Value)
+			chunkValue = chunkValue.Add(ua.Shift(-coinDecimals))
+			chunk.Inputs = append(chunk.Inputs, u)
+		}
+
+		feeUnspents, err = finishChunkFees(chunk, feeUnspents, 1, feeReserved, feeDecimals, isContract, estimate)
+		if err != nil {
+			return nil, decimal.Zero, 0, err
+		}
+
+		if !isContract {
+			chunkValue = chunkValue.Sub(chunk.Fees)
+		}
+
+		if chunkValue.LessThanOrEqual(decimal.Zero) {
+			return nil, decimal.Zero, 0, openwallet.Errorf(openwallet.ErrInsufficientFees, "The %s utxo is too small to pay fees: %s", currency, chunk.Fees.String())
+		}
+
+		//合并到本组第一个utxo的地址
+		chunk.Payments = []*splitPayment{{Address: chunk.Inputs[0].Address, Amount: chunkValue}}
+
+		chunks = append(chunks, chunk)
+		consolidated = consolidated.Add(chunkValue)
+	}
+}
+
+//buildPlanChunks 构建计划中的每笔交易单
+func (decoder *TransactionDecoder) buildPlanChunks(
+	wrapper openwallet.WalletDAI,
+	rawTx *openwallet.RawTransaction,
+	currency string,
+	coinDecimals int32,
+	feesRate decimal.Decimal,
+	chunks []*splitChunk) ([]*openwallet.RawTransaction, error) {
+
+	rawTxArray := make([]*openwallet.RawTransaction, 0, len(chunks))
+	for _, chunk := range chunks {
+		planTx, err := decoder.buildPlanRawTransaction(wrapper, rawTx, currency, coinDecimals, feesRate, chunk.Fees, chunk.Gas, chunk.Inputs, chunk.FeeInputs, chunk.Payments)
+		if err != nil {
+			return nil, err
+		}
+		rawTxArray = append(rawTxArray, planTx)
+	}
+
+	return rawTxArray, nil
+}
+
+//planConsolidation 先把utxo合并到账户自己的地址，合并交易确认后再发起支付
+func (decoder *TransactionDecoder) planConsolidation(
+	wrapper openwallet.WalletDAI,
+	rawTx *openwallet.RawTransaction,
+	currency string,
+	coinDecimals int32,
+	feesRate decimal.Decimal,
+	available, feeUnspents []*Unspent,
+	totalSend decimal.Decimal,
+	estimate splitFeeFunc) ([]*openwallet.RawTransaction, error) {
+
+	chunks, finalFees, _, err := planSplitConsolidation(available, feeUnspents, totalSend, len(rawTx.To), currency, coinDecimals, decoder.wm.Decimal(), rawTx.Coin.IsContract, estimate)
+	if err != nil {
+		return nil, err
+	}
+
+	rawTxArray, err := decoder.buildPlanChunks(wrapper, rawTx, currency, coinDecimals, feesRate, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	//合并交易的输出，最后一步支付只使用它们
+	outputs := make([]*SplitOutput, 0, len(chunks))
+	for _, chunk := range chunks {
+		outputs = append(outputs, &SplitOutput{
+			Address: chunk.Payments[0].Address,
+			Value:   chunk.Payments[0].Amount.Shift(coinDecimals).String(),
+		})
+	}
+
+	//最后一步支付，等待合并交易确认后再构建
+	to := make(map[string]string)
+	for addr, amount := range rawTx.To {
+		to[addr] = amount
+	}
+	payTx := &openwallet.RawTransaction{
+		Coin:     rawTx.Coin,
+		Account:  rawTx.Account,
+		FeeRate:  feesRate.StringFixed(decoder.wm.Decimal()),
+		To:       to,
+		Fees:     finalFees.StringFixed(decoder.wm.Decimal()),
+		Required: 1,
+		Change:   rawTx.Change,
+		IsBuilt:  false,
+	}
+
+	memos, err := decoder.getRawTransactionMemos(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	decoder.setRawTransactionMemos(payTx, memos)
+
+	err = payTx.SetExtParam(splitOutputsExtKey, outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	rawTxArray = append(rawTxArray, payTx)
+
+	return rawTxArray, nil
+}
+
+//CreateSplitFinalRawTransaction 构建consolidate模式的最后一步支付。
+//合并交易确认后调用，只使用ExtParam的splitOutputs记录的合并输出作为输入，输出未全部确认时返回错误，可稍后重试
+func (decoder *TransactionDecoder) CreateSplitFinalRawTransaction(wrapper openwallet.WalletDAI, payTx *openwallet.RawTransaction) error {
+
+	var (
+		accountID    = payTx.Account.AccountID
+		currency     = ""
+		coinDecimals = int32(0)
+		outputs      = make([]*SplitOutput, 0)
+		inputs       = make([]*Unspent, 0)
+		feeInputs    = make([]*Unspent, 0)
+		inputValue   = decimal.Zero
+		totalSend    = decimal.Zero
+	)
+
+	if len(payTx.ExtParam) > 0 {
+		for _, o := range payTx.GetExtParam().Get(splitOutputsExtKey).Array() {
+			outputs = append(outputs, &SplitOutput{Address: o.Get("address").String(), Value: o.Get("value").String()})
+		}
+	}
+	if len(outputs) == 0 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction is not the final step of a consolidate split plan")
+	}
+
+	if payTx.Coin.IsContract {
+		currency = payTx.Coin.Contract.Address
+		coinDecimals = decoder.wm.CoinDecimals(payTx.Coin)
+	} else {
+		currency = payTx.Coin.Symbol
+		coinDecimals = decoder.wm.Decimal()
+	}
+
+	payments, err := decoder.getSplitPayments(payTx)
+	if err != nil {
+		return err
+	}
+	for _, p := range payments {
+		totalSend = totalSend.Add(p.Amount)
+	}
+
+	currentHeight, err := decoder.wm.GetBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	unspents, err := decoder.wm.ListUnspent(accountID, currency, 0, -1)
+	if err != nil {
+		return err
+	}
+	available := decoder.availableUnspents(currentHeight, unspents)
+
+	//按地址和金额找出每笔合并输出
+	used := make(map[string]bool)
+	for _, o := range outputs {
+		var found *Unspent
+		for _, u := range available {
+			if !used[u.Root] && u.Address == o.Address && u.Value == o.Value {
+				found = u
+				break
+			}
+		}
+		if found == nil {
+			return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "consolidated output: %s:%s is not confirmed yet", o.Address, o.Value)
+		}
+		used[found.Root] = true
+		inputs = append(inputs, found)
+		ua, _ := decimal.NewFromString(found.Value)
+		inputValue = inputValue.Add(ua.Shift(-coinDecimals))
+	}
+
+	feesRate, _ := decimal.NewFromString(payTx.FeeRate)
+	feesRate, err = decoder.wm.EstimateFeeRate(feesRate)
+	if err != nil {
+		return err
+	}
+	estimate := decoder.splitFeeEstimator(payTx.Coin.IsContract, feesRate)
+
+	chunk := &splitChunk{Inputs: inputs, FeeInputs: feeInputs, Payments: payments}
+	feeReserved := 0
+	if payTx.Coin.IsContract {
+		mainUnspents, listErr := decoder.wm.ListUnspent(accountID, payTx.Coin.Symbol, 0, -1)
+		if listErr != nil {
+			return listErr
+		}
+		feeUnspents := decoder.availableUnspents(currentHeight, mainUnspents)
+		feeReserved, err = takeChunkFeeInputs(feeUnspents, len(payments), decoder.wm.Decimal(), estimate)
+		if err != nil {
+			return err
+		}
+		if _, err = finishChunkFees(chunk, feeUnspents, len(payments), feeReserved, decoder.wm.Decimal(), true, estimate); err != nil {
+			return err
+		}
+	} else {
+		if _, err = finishChunkFees(chunk, nil, len(payments), 0, decoder.wm.Decimal(), false, estimate); err != nil {
+			return err
+		}
+		if inputValue.LessThan(totalSend.Add(chunk.Fees)) {
+			return openwallet.Errorf(openwallet.ErrInsufficientFees, "consolidated outputs: %s can not pay %s and fees: %s", inputValue.String(), totalSend.String(), chunk.Fees.String())
+		}
+	}
+
+	if payTx.Coin.IsContract && inputValue.LessThan(totalSend) {
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "consolidated outputs: %s can not pay %s", inputValue.String(), totalSend.String())
+	}
+
+	built, err := decoder.buildPlanRawTransaction(wrapper, payTx, currency, coinDecimals, feesRate, chunk.Fees, chunk.Gas, chunk.Inputs, chunk.FeeInputs, chunk.Payments)
+	if err != nil {
+		return err
+	}
+
+	//保留计划步骤和合并输出
+	built.Sid = payTx.Sid
+	if step := payTx.GetExtParam().Get(splitPlanExtKey); step.Exists() {
+		if err = built.SetExtParam(splitPlanExtKey, step.Value()); err != nil {
+			return err
+		}
+	}
+	if err = built.SetExtParam(splitOutputsExtKey, outputs); err != nil {
+		return err
+	}
+
+	*payTx = *built
+
+	return decoder.saveRawTxExt(payTx)
+}
+
+//buildPlanRawTransaction 根据指定的utxo和支付构建计划中的一笔交易单
+func (decoder *TransactionDecoder) buildPlanRawTransaction(
+	wrapper openwallet.WalletDAI,
+	rawTx *openwallet.RawTransaction,
+	currency string,
+	coinDecimals int32,
+	feesRate, fees decimal.Decimal,
+	gas int64,
+	usedUTXO, feeUTXO []*Unspent,
+	payments []*splitPayment) (*openwallet.RawTransaction, error) {
+
+	var (
+		accountID        = rawTx.Account.AccountID
+		outputAddrs      = make([]Out_O, 0)
+		to               = make(map[string]string)
+		memos            = make(map[string]string)
+		addrMemos        = make(map[string]string)
+		txFrom           = make([]string, 0)
+		txTo             = make([]string, 0)
+		accountTotalSent = decimal.Zero
+	)
+
+	for _, u := range usedUTXO {
+		ua, _ := decimal.NewFromString(u.Value)
+		if rawTx.Coin.IsContract {
+			txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, ua.String()))
+		} else {
+			txFrom = append(txFrom, fmt.Sprintf("%s:%s", u.Address, ua.Shift(-coinDecimals).String()))
+		}
+	}
+
+	for _, p := range payments {
+
+		output := Out_O{
+			Asset: Asset{
+				Tkn: &Token{
+					Currency: currency,
+					Value:    p.Amount.Shift(coinDecimals).String(),
+				},
+			},
+			Addr: p.Address,
+			Memo: p.Memo,
+		}
+		outputAddrs = append(outputAddrs, output)
+
+		//同一地址的多笔支付合计，备注必须一致
+		sent, exist := to[p.Address]
+		if exist && addrMemos[p.Address] != p.Memo {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "payments to address: %s have different memos", p.Address)
+		}
+		addrMemos[p.Address] = p.Memo
+		sentAmount, _ := decimal.NewFromString(sent)
+		to[p.Address] = sentAmount.Add(p.Amount).String()
+		if len(p.Memo) > 0 {
+			memos[p.Address] = p.Memo
+		}
+
+		//计算账户的实际转账amount
+		addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", accountID, "Address", p.Address)
+		if findErr != nil || len(addresses) == 0 {
+			accountTotalSent = accountTotalSent.Add(p.Amount)
+		}
+
+		if rawTx.Coin.IsContract {
+			txTo = append(txTo, fmt.Sprintf("%s:%s", p.Address, p.Amount.Shift(coinDecimals)))
+		} else {
+			txTo = append(txTo, fmt.Sprintf("%s:%s", p.Address, p.Amount.String()))
+		}
+	}
+
+	if !rawTx.Coin.IsContract {
+		accountTotalSent = accountTotalSent.Add(fees)
+	}
+
+	inputs := append(append([]*Unspent{}, feeUTXO...), usedUTXO...)
+
+	planTx := &openwallet.RawTransaction{
+		Coin:     rawTx.Coin,
+		Account:  rawTx.Account,
+		FeeRate:  feesRate.StringFixed(decoder.wm.Decimal()),
+		To:       to,
+		Fees:     fees.StringFixed(decoder.wm.Decimal()),
+		Required: 1,
+		Change:   rawTx.Change,
+	}
+
+	//找零地址
+	changeAddress, err := decoder.getChangeAddress(wrapper, planTx, usedUTXO)
+	if err != nil {
+		return nil, err
+	}
+
+	txStruct, err := decoder.wm.GenTxParam(changeAddress, accountID, coinDecimals, feesRate, gas, inputs, outputAddrs)
+	if err != nil {
+		return nil, err
+	}
+
+	planTx.RawHex = txStruct.Raw
+
+	//记录交易结构概要，用于验证签名后的交易
+	err = decoder.setRawTransactionOutline(planTx, txStruct, inputs)
+	if err != nil {
+		return nil, err
+	}
+	decoder.setRawTransactionMemos(planTx, memos)
+	decoder.setRawTransactionChange(planTx, changeAddress)
+
+	//装配签名
+	err = decoder.setRawTransactionSignatures(wrapper, planTx, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
+
+	if rawTx.Coin.IsContract {
+		accountTotalSent = accountTotalSent.Shift(coinDecimals)
+	}
+
+	planTx.IsBuilt = true
+	planTx.TxAmount = accountTotalSent.StringFixed(decoder.wm.Decimal())
+	planTx.TxFrom = txFrom
+	planTx.TxTo = txTo
+
+	return planTx, nil
+}
+
+//availableUnspents 筛选确认数足够且未发送的utxo，不包括票据utxo，按金额从大到小排序
+func (decoder *TransactionDecoder) availableUnspents(currentHeight uint64, unspents []*Unspent) []*Unspent {
+
+	available := make([]*Unspent, 0)
+	for _, u := range unspents {
+		//utxo确认书必须大于6个才使用
+		if currentHeight-u.Height <= MinConfirms {
+			continue
+		}
+		if u.Sending {
+			continue
+		}
+		//票据utxo只在指定票据转账时使用
+		if len(u.Ticket) > 0 {
+			continue
+		}
+		available = append(available, u)
+	}
+
+	sort.SliceStable(available, func(i, j int) bool {
+		vi, _ := decimal.NewFromString(available[i].Value)
+		vj, _ := decimal.NewFromString(available[j].Value)
+		return vi.GreaterThan(vj)
+	})
+
+	return available
+}
+
+//takeUnspentsSatisfyAmount 从utxo列表中取出满足金额的utxo，返回取出的utxo和剩余的utxo
+func (decoder *TransactionDecoder) takeUnspentsSatisfyAmount(unspents []*Unspent, amount decimal.Decimal, decimals int32) ([]*Unspent, []*Unspent, error) {
+
+	used, rest, ok := takeUnspents(unspents, amount, decimals)
+	if !ok {
+		return nil, rest, openwallet.Errorf(openwallet.ErrInsufficientFees, "The %s balance is not enough to pay fees: %s", decoder.wm.Symbol(), amount.String())
+	}
+
+	return used, rest, nil
+}
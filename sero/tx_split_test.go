@@ -0,0 +1,194 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+//testSplitUnspents 生成count个金额为value的utxo，value为最小单位
+func testSplitUnspents(prefix string, count int, value string) []*Unspent {
+	list := make([]*Unspent, 0, count)
+	for i := 0; i < count; i++ {
+		list = append(list, &Unspent{
+			Root:    fmt.Sprintf("%s_%d", prefix, i),
+			Value:   value,
+			Address: fmt.Sprintf("%s_addr_%d", prefix, i%3),
+		})
+	}
+	return list
+}
+
+//testSplitFee 每个输入0.001，每个输出0.01
+func testSplitFee(ins, outs int) (decimal.Decimal, int64, error) {
+	gas := int64(ins*1000 + outs*10000)
+	return decimal.New(gas, -6), gas, nil
+}
+
+func TestPlanSplitPayouts(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		available  int
+		feeInputs  int
+		isContract bool
+		payments   []string
+		wantChunks int
+		wantErr    bool
+	}{
+		{name: "single chunk", available: 50, payments: []string{"10", "20"}, wantChunks: 1},
+		{name: "over max inputs", available: 450, payments: []string{"150", "150", "100"}, wantChunks: 3},
+		{name: "insufficient", available: 10, payments: []string{"20"}, wantErr: true},
+		{name: "token over max inputs", available: 450, feeInputs: 5, isContract: true, payments: []string{"300", "100"}, wantChunks: 3},
+		{name: "token without fee", available: 50, isContract: true, payments: []string{"10"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			available := testSplitUnspents("coin", tt.available, "1000")
+			feeUnspents := testSplitUnspents("fee", tt.feeInputs, "1000")
+			payments := make([]*splitPayment, 0)
+			want := make(map[string]decimal.Decimal)
+			for i, amount := range tt.payments {
+				addr := fmt.Sprintf("to_%d", i)
+				value, _ := decimal.NewFromString(amount)
+				payments = append(payments, &splitPayment{Address: addr, Amount: value})
+				want[addr] = value
+			}
+
+			chunks, err := planSplitPayouts(available, feeUnspents, payments, "SERO", 3, 3, tt.isContract, testSplitFee)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("planSplitPayouts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(chunks) != tt.wantChunks {
+				t.Errorf("planSplitPayouts() chunks = %d, want %d", len(chunks), tt.wantChunks)
+			}
+
+			paid := make(map[string]decimal.Decimal)
+			for i, chunk := range chunks {
+				ins := len(chunk.Inputs) + len(chunk.FeeInputs)
+				if ins > MaxTxInputs {
+					t.Errorf("chunk %d inputs = %d, over max inputs", i, ins)
+				}
+
+				//手续费按实际的输入输出数量预估
+				fees, _, _ := testSplitFee(ins, len(chunk.Payments))
+				if !chunk.Fees.Equal(fees) {
+					t.Errorf("chunk %d fees = %s, want %s", i, chunk.Fees, fees)
+				}
+
+				inputValue := decimal.Zero
+				for _, u := range chunk.Inputs {
+					v, _ := decimal.NewFromString(u.Value)
+					inputValue = inputValue.Add(v.Shift(-3))
+				}
+				sent := decimal.Zero
+				for _, p := range chunk.Payments {
+					sent = sent.Add(p.Amount)
+					paid[p.Address] = paid[p.Address].Add(p.Amount)
+				}
+				if !tt.isContract {
+					sent = sent.Add(chunk.Fees)
+				}
+				if inputValue.LessThan(sent) {
+					t.Errorf("chunk %d inputs = %s, can not pay %s", i, inputValue, sent)
+				}
+			}
+
+			for addr, amount := range want {
+				if !paid[addr].Equal(amount) {
+					t.Errorf("paid to %s = %s, want %s", addr, paid[addr], amount)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanSplitConsolidation(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		available  int
+		feeInputs  int
+		isContract bool
+		totalSend  string
+		wantChunks int
+		wantErr    bool
+	}{
+		{name: "two merges", available: 450, totalSend: "300", wantChunks: 2},
+		{name: "three merges", available: 450, totalSend: "420", wantChunks: 3},
+		{name: "insufficient", available: 450, totalSend: "460", wantErr: true},
+		{name: "token", available: 450, feeInputs: 5, isContract: true, totalSend: "400", wantChunks: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			available := testSplitUnspents("coin", tt.available, "1000")
+			feeUnspents := testSplitUnspents("fee", tt.feeInputs, "1000")
+			totalSend, _ := decimal.NewFromString(tt.totalSend)
+
+			chunks, finalFees, _, err := planSplitConsolidation(available, feeUnspents, totalSend, 2, "SERO", 3, 3, tt.isContract, testSplitFee)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("planSplitConsolidation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(chunks) != tt.wantChunks {
+				t.Errorf("planSplitConsolidation() chunks = %d, want %d", len(chunks), tt.wantChunks)
+			}
+
+			//最后一笔支付的输入是每笔合并交易的输出
+			finalIns := len(chunks)
+			if tt.isContract {
+				finalIns++
+			}
+			wantFinalFees, _, _ := testSplitFee(finalIns, 2)
+			if !finalFees.Equal(wantFinalFees) {
+				t.Errorf("planSplitConsolidation() final fees = %s, want %s", finalFees, wantFinalFees)
+			}
+
+			consolidated := decimal.Zero
+			for i, chunk := range chunks {
+				if len(chunk.Payments) != 1 || chunk.Payments[0].Address != chunk.Inputs[0].Address {
+					t.Errorf("chunk %d does not merge to its own address", i)
+				}
+				fees, _, _ := testSplitFee(len(chunk.Inputs)+len(chunk.FeeInputs), 1)
+				if !chunk.Fees.Equal(fees) {
+					t.Errorf("chunk %d fees = %s, want %s", i, chunk.Fees, fees)
+				}
+				consolidated = consolidated.Add(chunk.Payments[0].Amount)
+			}
+
+			needed := totalSend
+			if !tt.isContract {
+				needed = needed.Add(finalFees)
+			}
+			if consolidated.LessThan(needed) {
+				t.Errorf("consolidated = %s, can not pay %s", consolidated, needed)
+			}
+		})
+	}
+}
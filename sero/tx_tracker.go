@@ -0,0 +1,312 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"sync"
+)
+
+//TxStatus 已提交交易的状态
+type TxStatus struct {
+	TxID           string `json:"txid"`
+	Sid            string `json:"sid"`
+	AccountID      string `json:"accountID"`
+	Status         string `json:"status"`
+	PreviousStatus string `json:"previousStatus"` //变化前的状态，首次提交为空
+	BlockHeight    uint64 `json:"blockHeight"`    //上链高度
+	Confirmations  uint64 `json:"confirmations"`  //确认数
+	Reason         string `json:"reason"`         //被拒绝或失败的原因
+}
+
+//TxStatusObserver 交易状态观测者
+type TxStatusObserver interface {
+	//TxStatusNotify 交易状态变化通知
+	TxStatusNotify(status *TxStatus)
+}
+
+//TxStatusTracker 交易状态跟踪器，跟踪已提交交易的上链、确认、丢弃和失败
+type TxStatusTracker struct {
+	wm        *WalletManager
+	observers map[TxStatusObserver]bool
+	mu        sync.RWMutex
+}
+
+//NewTxStatusTracker 创建交易状态跟踪器
+func NewTxStatusTracker(wm *WalletManager) *TxStatusTracker {
+	return &TxStatusTracker{
+		wm:        wm,
+		observers: make(map[TxStatusObserver]bool),
+	}
+}
+
+//AddObserver 添加观测者
+func (t *TxStatusTracker) AddObserver(obj TxStatusObserver) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if obj == nil {
+		return fmt.Errorf("observer is nil")
+	}
+	t.observers[obj] = true
+
+	return nil
+}
+
+//RemoveObserver 移除观测者
+func (t *TxStatusTracker) RemoveObserver(obj TxStatusObserver) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.observers, obj)
+
+	return nil
+}
+
+//notify 通知观测者交易状态变化
+func (t *TxStatusTracker) notify(pending *PendingTx, previous string, confirmations uint64) {
+
+	status := &TxStatus{
+		TxID:           pending.TxID,
+		Sid:            pending.Sid,
+		AccountID:      pending.AccountID,
+		Status:         pending.Status,
+		PreviousStatus: previous,
+		BlockHeight:    pending.IncludeHeight,
+		Confirmations:  confirmations,
+		Reason:         pending.Reason,
+	}
+
+	t.wm.Log.Infof("transaction: %s status changed: %s -> %s", status.TxID, previous, status.Status)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for o := range t.observers {
+		o.TxStatusNotify(status)
+	}
+}
+
+//GetTxStatus 查询交易的当前状态和确认数，没有跟踪记录的交易查询节点
+func (t *TxStatusTracker) GetTxStatus(txid string) (*TxStatus, error) {
+
+	pending, err := t.wm.GetPendingTx(txid)
+	if err != nil {
+		return t.getNodeTxStatus(txid)
+	}
+
+	status := &TxStatus{
+		TxID:        pending.TxID,
+		Sid:         pending.Sid,
+		AccountID:   pending.AccountID,
+		Status:      pending.Status,
+		BlockHeight: pending.IncludeHeight,
+		Reason:      pending.Reason,
+	}
+
+	if pending.IncludeHeight > 0 {
+		currentHeight, err := t.wm.GetBlockHeight()
+		if err != nil {
+			return nil, err
+		}
+		status.Confirmations = confirmations(currentHeight, pending.IncludeHeight)
+	}
+
+	return status, nil
+}
+
+//getNodeTxStatus 按节点的交易回执和交易池查询交易状态，用于不是本钱包提交的交易
+func (t *TxStatusTracker) getNodeTxStatus(txid string) (*TxStatus, error) {
+
+	wm := t.wm
+	status := &TxStatus{TxID: txid}
+
+	receipt, err := wm.GetTransactionReceipt(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	if receipt.IsObject() {
+		blockNumber, parseErr := parseHexOrNumber(receipt.Get("blockNumber"))
+		if parseErr == nil && blockNumber.IntPart() > 0 {
+			currentHeight, heightErr := wm.GetBlockHeight()
+			if heightErr != nil {
+				return nil, heightErr
+			}
+			status.BlockHeight = uint64(blockNumber.IntPart())
+			status.Confirmations = confirmations(currentHeight, status.BlockHeight)
+			status.Status = PendingTxStatusIncluded
+			if receipt.Get("status").Exists() && receipt.Get("status").String() == "0x0" {
+				status.Status = PendingTxStatusFailed
+				status.Reason = "transaction execution failed"
+			} else if status.Confirmations >= wm.Config.TxConfirms {
+				status.Status = PendingTxStatusConfirmed
+			}
+			return status, nil
+		}
+	}
+
+	//未上链，查询交易池
+	_, err = wm.GetTransactionByHash(txid)
+	if err != nil {
+		if IsTxNotExistError(err) {
+			return nil, fmt.Errorf("transaction: %s not found", txid)
+		}
+		return nil, err
+	}
+	status.Status = PendingTxStatusPending
+
+	return status, nil
+}
+
+//CheckTxs 查询等待上链和确认中的交易，更新状态并通知观测者
+func (t *TxStatusTracker) CheckTxs() error {
+
+	wm := t.wm
+
+	list := make([]*PendingTx, 0)
+	for _, status := range []string{PendingTxStatusPending, PendingTxStatusIncluded} {
+		records, err := wm.ListPendingTx(status)
+		if err != nil {
+			return err
+		}
+		list = append(list, records...)
+	}
+
+	currentHeight, err := wm.GetBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	//释放长时间未广播的交易单预留的utxo
+	if expireErr := wm.ExpireBuiltTxs(currentHeight); expireErr != nil {
+		wm.Log.Warningf("expire built transactions failed, unexpected error: %v", expireErr)
+	}
+
+	for _, pending := range list {
+		if checkErr := t.checkTx(pending, currentHeight); checkErr != nil {
+			wm.Log.Warningf("check transaction: %s failed, unexpected error: %v", pending.TxID, checkErr)
+		}
+	}
+
+	return nil
+}
+
+//checkTx 更新一笔交易的状态。
+//交易回执有区块高度说明已上链；未上链时用flight_getTx查询，交易池中的交易继续等待，
+//只有节点明确返回交易不存在，且超过等待区块数，才认为已被丢弃并释放输入
+func (t *TxStatusTracker) checkTx(pending *PendingTx, currentHeight uint64) error {
+
+	wm := t.wm
+	previous := pending.Status
+
+	receipt, err := wm.GetTransactionReceipt(pending.TxID)
+	if err != nil {
+		//网络错误，下次再查
+		return err
+	}
+
+	blockHeight := uint64(0)
+	if receipt.IsObject() {
+		blockNumber, parseErr := parseHexOrNumber(receipt.Get("blockNumber"))
+		if parseErr == nil {
+			blockHeight = uint64(blockNumber.IntPart())
+		}
+	}
+
+	//未上链
+	if blockHeight == 0 {
+
+		_, err = wm.GetTransactionByHash(pending.TxID)
+		if err != nil && !IsTxNotExistError(err) {
+			//网络错误或其他错误，无法确定交易是否存在，下次再查
+			return err
+		}
+		notExist := err != nil
+
+		if pending.Status == PendingTxStatusIncluded {
+			//分叉回滚，重新等待上链
+			pending.Status = PendingTxStatusPending
+			pending.IncludeHeight = 0
+			pending.SubmitHeight = currentHeight
+			return t.save(pending, previous, 0)
+		}
+
+		//交易在交易池中，继续等待上链
+		if !notExist {
+			return nil
+		}
+
+		//节点明确不存在此交易，超过等待区块数则认为已被丢弃
+		if currentHeight > pending.SubmitHeight+wm.Config.PendingTxTimeout {
+			reason := fmt.Sprintf("transaction not exist in node after %d blocks", wm.Config.PendingTxTimeout)
+			wm.Log.Warningf("pending transaction: %s is rejected, %s", pending.TxID, reason)
+			return wm.ReleasePendingTx(pending.TxID, reason)
+		}
+		return nil
+	}
+
+	confirms := confirmations(currentHeight, blockHeight)
+
+	if pending.Status == PendingTxStatusPending || pending.IncludeHeight != blockHeight {
+		pending.Status = PendingTxStatusIncluded
+		pending.IncludeHeight = blockHeight
+
+		//执行失败的交易，输入也已被消耗
+		if receipt.Get("status").Exists() && receipt.Get("status").String() == "0x0" {
+			pending.Status = PendingTxStatusFailed
+			pending.Reason = "transaction execution failed"
+		}
+
+		if err := t.save(pending, previous, confirms); err != nil {
+			return err
+		}
+		previous = pending.Status
+	}
+
+	if pending.Status == PendingTxStatusIncluded && confirms >= wm.Config.TxConfirms {
+		pending.Status = PendingTxStatusConfirmed
+		return t.save(pending, previous, confirms)
+	}
+
+	return nil
+}
+
+//save 保存交易状态，状态变化时通知观测者
+func (t *TxStatusTracker) save(pending *PendingTx, previous string, confirms uint64) error {
+	err := t.wm.unspentDB.Save(pending)
+	if err != nil {
+		return err
+	}
+	if pending.Status != previous {
+		//交易已上链完成，不再需要验证时使用的交易单ExtParam
+		if pending.Status == PendingTxStatusConfirmed || pending.Status == PendingTxStatusFailed {
+			if delErr := t.wm.DeleteRawTxExt(pending.Roots); delErr != nil {
+				t.wm.Log.Warningf("delete transaction: %s ext param failed, unexpected error: %v", pending.TxID, delErr)
+			}
+		}
+		t.notify(pending, previous, confirms)
+	}
+	return nil
+}
+
+//confirmations 计算确认数，上链的区块算1个确认
+func confirmations(currentHeight, blockHeight uint64) uint64 {
+	if blockHeight == 0 || currentHeight < blockHeight {
+		return 0
+	}
+	return currentHeight - blockHeight + 1
+}
@@ -0,0 +1,655 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/mr-tron/base58"
+	"github.com/sero-cash/go-sero/common/hexutil"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	txOutlineExtKey = "txOutline" //交易单ExtParam中记录交易结构概要的字段
+)
+
+//TxOutline 交易结构概要，创建交易单时从flight_genTxParam的结果中提取，用于验证签名后的交易
+type TxOutline struct {
+	Ins      []string         `json:"ins"`              //输入utxo的Root
+	Inputs   []*TxInputBrief  `json:"inputs,omitempty"` //输入utxo的地址和金额，匿名输入无法从交易中解出
+	Outs     []*TxOutputBrief `json:"outs"`             //输出，包括找零
+	Gas      uint64           `json:"gas"`              //汽油
+	GasPrice string           `json:"gasPrice"`         //汽油价格，最小单位
+}
+
+//TxInputBrief 交易输入概要，创建交易单时从选中的utxo记录
+type TxInputBrief struct {
+	Root     string `json:"root"`
+	Address  string `json:"address"`
+	Currency string `json:"currency"`         //币种名称
+	Value    string `json:"value"`            //金额，最小单位
+	Ticket   string `json:"ticket,omitempty"` //票据hash
+}
+
+//TxOutputBrief 交易输出概要
+type TxOutputBrief struct {
+	Address  string `json:"address"`  //收款码PKr，base58
+	Currency string `json:"currency"`           //币种Id
+	Value    string `json:"value"`              //金额，最小单位
+	Category string `json:"category,omitempty"` //票据类别Id
+	Ticket   string `json:"ticket,omitempty"`   //票据hash
+}
+
+//NewTxOutline 解析flight_genTxParam的结果
+func NewTxOutline(txParam *gjson.Result) (*TxOutline, error) {
+
+	if txParam == nil || !txParam.IsObject() {
+		return nil, fmt.Errorf("transaction param is not json object")
+	}
+
+	outline := &TxOutline{
+		Ins:  make([]string, 0),
+		Outs: make([]*TxOutputBrief, 0),
+	}
+
+	gas, err := parseHexOrNumber(txParam.Get("Gas"))
+	if err != nil {
+		return nil, fmt.Errorf("transaction param gas is invalid, %v", err)
+	}
+	outline.Gas = uint64(gas.IntPart())
+
+	gasPrice, err := parseHexOrNumber(txParam.Get("GasPrice"))
+	if err != nil {
+		return nil, fmt.Errorf("transaction param gas price is invalid, %v", err)
+	}
+	outline.GasPrice = gasPrice.String()
+
+	for _, in := range txParam.Get("Ins").Array() {
+		root := in.Get("Out.Root").String()
+		if len(root) == 0 {
+			root = in.Get("Root").String()
+		}
+		outline.Ins = append(outline.Ins, root)
+	}
+
+	for _, out := range txParam.Get("Outs").Array() {
+		pkr, err := hexutil.Decode(out.Get("PKr").String())
+		if err != nil {
+			return nil, fmt.Errorf("transaction param output PKr is invalid, %v", err)
+		}
+		brief := &TxOutputBrief{
+			Address: base58.Encode(pkr),
+			Value:   "0",
+		}
+		if out.Get("Asset.Tkn").IsObject() {
+			value, err := parseHexOrNumber(out.Get("Asset.Tkn.Value"))
+			if err != nil {
+				return nil, fmt.Errorf("transaction param output value is invalid, %v", err)
+			}
+			brief.Currency = out.Get("Asset.Tkn.Currency").String()
+			brief.Value = value.String()
+		}
+		if out.Get("Asset.Tkt").IsObject() {
+			brief.Category = out.Get("Asset.Tkt.Category").String()
+			brief.Ticket = strings.ToLower(out.Get("Asset.Tkt.Value").String())
+		}
+		outline.Outs = append(outline.Outs, brief)
+	}
+
+	return outline, nil
+}
+
+//parseHexOrNumber 解析0x开头的hex或十进制数字
+func parseHexOrNumber(result gjson.Result) (decimal.Decimal, error) {
+	if !result.Exists() {
+		return decimal.Zero, fmt.Errorf("value not exists")
+	}
+	str := result.String()
+	if strings.HasPrefix(str, "0x") || strings.HasPrefix(str, "0X") {
+		num, err := hexutil.DecodeBig(str)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		return decimal.NewFromBigInt(num, 0), nil
+	}
+	return decimal.NewFromString(str)
+}
+
+//setRawTransactionOutline 记录交易结构概要到交易单ExtParam中，同时记录输入utxo的金额
+func (decoder *TransactionDecoder) setRawTransactionOutline(rawTx *openwallet.RawTransaction, txParam *gjson.Result, usedUTXO []*Unspent) error {
+	outline, err := NewTxOutline(txParam)
+	if err != nil {
+		return err
+	}
+	outline.Inputs = make([]*TxInputBrief, 0, len(usedUTXO))
+	for _, u := range usedUTXO {
+		outline.Inputs = append(outline.Inputs, &TxInputBrief{
+			Root:     u.Root,
+			Address:  u.Address,
+			Currency: u.Currency,
+			Value:    u.Value,
+			Ticket:   u.Ticket,
+		})
+	}
+	return rawTx.SetExtParam(txOutlineExtKey, outline)
+}
+
+//getRawTransactionOutline 读取交易单ExtParam中的交易结构概要
+func (decoder *TransactionDecoder) getRawTransactionOutline(rawTx *openwallet.RawTransaction) (*TxOutline, error) {
+
+	if len(rawTx.ExtParam) == 0 {
+		return nil, fmt.Errorf("transaction outline is missing")
+	}
+
+	result := rawTx.GetExtParam().Get(txOutlineExtKey)
+	if !result.IsObject() {
+		return nil, fmt.Errorf("transaction outline is missing")
+	}
+
+	outline := &TxOutline{
+		Ins:      make([]string, 0),
+		Outs:     make([]*TxOutputBrief, 0),
+		Gas:      result.Get("gas").Uint(),
+		GasPrice: result.Get("gasPrice").String(),
+	}
+	for _, in := range result.Get("ins").Array() {
+		outline.Ins = append(outline.Ins, in.String())
+	}
+	for _, in := range result.Get("inputs").Array() {
+		outline.Inputs = append(outline.Inputs, &TxInputBrief{
+			Root:     in.Get("root").String(),
+			Address:  in.Get("address").String(),
+			Currency: in.Get("currency").String(),
+			Value:    in.Get("value").String(),
+			Ticket:   in.Get("ticket").String(),
+		})
+	}
+	for _, out := range result.Get("outs").Array() {
+		outline.Outs = append(outline.Outs, &TxOutputBrief{
+			Address:  out.Get("address").String(),
+			Currency: out.Get("currency").String(),
+			Value:    out.Get("value").String(),
+			Category: out.Get("category").String(),
+			Ticket:   out.Get("ticket").String(),
+		})
+	}
+
+	return outline, nil
+}
+
+//RawTxExt 服务端记录的交易单ExtParam。openw-sdk的交易单没有ExtParam，客户端签名后提交的交易单只带回输入utxo的签名，
+//验证和广播时按输入utxo恢复交易结构概要、备注、多币种输出、质押、合约调用和票据等扩展数据
+type RawTxExt struct {
+	Key       string `json:"key" storm:"id"` //输入utxo Root排序后的sha256
+	AccountID string `json:"accountID" storm:"index"`
+	ExtParam  string `json:"extParam"`
+	CreateAt  int64  `json:"createAt"`
+}
+
+//rawTxExtKey 输入utxo Root排序后的sha256
+func rawTxExtKey(roots []string) string {
+	sorted := append([]string{}, roots...)
+	sort.Strings(sorted)
+	hash := sha256.Sum256([]byte(strings.Join(sorted, ",")))
+	return hex.EncodeToString(hash[:])
+}
+
+//rawTransactionRoots 交易单待签名的输入utxo
+func rawTransactionRoots(rawTx *openwallet.RawTransaction) []string {
+	roots := make([]string, 0)
+	if rawTx.Account == nil {
+		return roots
+	}
+	for _, keySignature := range rawTx.Signatures[rawTx.Account.AccountID] {
+		roots = append(roots, keySignature.Message)
+	}
+	return roots
+}
+
+//SaveRawTxExt 记录创建的交易单的ExtParam
+func (wm *WalletManager) SaveRawTxExt(rawTx *openwallet.RawTransaction) error {
+	roots := rawTransactionRoots(rawTx)
+	if len(roots) == 0 || len(rawTx.ExtParam) == 0 {
+		return nil
+	}
+	record := &RawTxExt{
+		Key:       rawTxExtKey(roots),
+		AccountID: rawTx.Account.AccountID,
+		ExtParam:  rawTx.ExtParam,
+		CreateAt:  time.Now().Unix(),
+	}
+	return wm.unspentDB.Save(record)
+}
+
+//DeleteRawTxExt 删除交易单的ExtParam记录
+func (wm *WalletManager) DeleteRawTxExt(roots []string) error {
+	if len(roots) == 0 {
+		return nil
+	}
+	err := wm.unspentDB.DeleteStruct(&RawTxExt{Key: rawTxExtKey(roots)})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+//restoreRawTxExt 按输入utxo恢复服务端记录的ExtParam，没有记录时使用交易单自带的交易结构概要
+func (wm *WalletManager) restoreRawTxExt(rawTx *openwallet.RawTransaction) error {
+	roots := rawTransactionRoots(rawTx)
+	if len(roots) == 0 {
+		return fmt.Errorf("transaction signatures is empty")
+	}
+	var record RawTxExt
+	err := wm.unspentDB.One("Key", rawTxExtKey(roots), &record)
+	if err == storm.ErrNotFound {
+		if len(rawTx.ExtParam) > 0 && rawTx.GetExtParam().Get(txOutlineExtKey).IsObject() {
+			return nil
+		}
+		return fmt.Errorf("transaction outline is missing")
+	}
+	if err != nil {
+		return err
+	}
+	if record.AccountID != rawTx.Account.AccountID {
+		return fmt.Errorf("transaction account: %s is not the creator of the inputs", rawTx.Account.AccountID)
+	}
+	rawTx.ExtParam = record.ExtParam
+	return nil
+}
+
+//signedTxOutput 签名交易中的输出
+type signedTxOutput struct {
+	Address  string
+	Currency string //公开输出才有
+	Value    string //公开输出才有
+	Ticket   string //公开输出才有
+	IsPublic bool
+	Proof    string //匿名输出的证明
+}
+
+//signedTxOutputs 提取签名交易中的输出
+func signedTxOutputs(tx gjson.Result) ([]*signedTxOutput, error) {
+
+	outputs := make([]*signedTxOutput, 0)
+
+	appendOutput := func(pkrHex string, asset gjson.Result, isPublic bool, proof string) error {
+		pkr, err := hexutil.Decode(pkrHex)
+		if err != nil {
+			return fmt.Errorf("output PKr: %s is invalid", pkrHex)
+		}
+		o := &signedTxOutput{
+			Address:  base58.Encode(pkr),
+			IsPublic: isPublic,
+			Proof:    proof,
+		}
+		if isPublic {
+			o.Value = "0"
+			if asset.Get("Tkn").IsObject() {
+				value, err := parseHexOrNumber(asset.Get("Tkn.Value"))
+				if err != nil {
+					return fmt.Errorf("output value is invalid, %v", err)
+				}
+				o.Currency = asset.Get("Tkn.Currency").String()
+				o.Value = value.String()
+			}
+			if asset.Get("Tkt").IsObject() {
+				o.Ticket = strings.ToLower(asset.Get("Tkt.Value").String())
+			}
+		}
+		outputs = append(outputs, o)
+		return nil
+	}
+
+	for _, out := range tx.Get("Desc_O.Outs").Array() {
+		if err := appendOutput(out.Get("Addr").String(), out.Get("Asset"), true, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, out := range tx.Get("Desc_Z.Outs").Array() {
+		if err := appendOutput(out.Get("PKr").String(), out.Get("Asset"), false, out.Get("Proof").String()); err != nil {
+			return nil, err
+		}
+	}
+	for _, out := range tx.Get("Tx1.Outs_P").Array() {
+		if err := appendOutput(out.Get("PKr").String(), out.Get("Asset"), true, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, out := range tx.Get("Tx1.Outs_C").Array() {
+		if err := appendOutput(out.Get("PKr").String(), out.Get("Asset"), false, out.Get("Proof").String()); err != nil {
+			return nil, err
+		}
+	}
+
+	return outputs, nil
+}
+
+//signedTxRoots 提取签名交易中的输入Root
+func signedTxRoots(signedTx *gjson.Result) []string {
+	roots := make([]string, 0)
+	if signedTx.Get("Roots").Exists() {
+		for _, root := range signedTx.Get("Roots").Array() {
+			roots = append(roots, root.String())
+		}
+		return roots
+	}
+	for _, in := range signedTx.Get("Tx.Desc_O.Ins").Array() {
+		roots = append(roots, in.Get("Root").String())
+	}
+	for _, in := range signedTx.Get("Tx.Tx1.Ins_P").Array() {
+		roots = append(roots, in.Get("Root").String())
+	}
+	return roots
+}
+
+//equalStringSet 比较两组字符串是否一致，忽略顺序和大小写
+func equalStringSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x := make([]string, 0, len(a))
+	y := make([]string, 0, len(b))
+	for i := range a {
+		x = append(x, strings.ToLower(a[i]))
+		y = append(y, strings.ToLower(b[i]))
+	}
+	sort.Strings(x)
+	sort.Strings(y)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//verifySignedTransaction 验证签名后的交易与交易单是否一致
+func (decoder *TransactionDecoder) verifySignedTransaction(rawTx *openwallet.RawTransaction) error {
+
+	if len(rawTx.RawHex) == 0 {
+		return fmt.Errorf("transaction hex is empty")
+	}
+
+	if rawTx.Account == nil {
+		return fmt.Errorf("transaction account is empty")
+	}
+
+	signedTx := gjson.Parse(rawTx.RawHex)
+	tx := signedTx.Get("Tx")
+	if !tx.IsObject() {
+		return fmt.Errorf("transaction is not signed")
+	}
+
+	outline, err := decoder.getRawTransactionOutline(rawTx)
+	if err != nil {
+		return err
+	}
+
+	//1. 输入必须是待签名的utxo
+	sigRoots := make([]string, 0)
+	for _, keySignature := range rawTx.Signatures[rawTx.Account.AccountID] {
+		sigRoots = append(sigRoots, keySignature.Message)
+	}
+	if len(sigRoots) == 0 {
+		return fmt.Errorf("transaction signatures is empty")
+	}
+	if !equalStringSet(sigRoots, outline.Ins) {
+		return fmt.Errorf("transaction inputs are not the signatures roots")
+	}
+	if !equalStringSet(sigRoots, signedTxRoots(&signedTx)) {
+		return fmt.Errorf("signed transaction inputs are not the signatures roots")
+	}
+
+	//2. 输出必须与交易单的接收地址和金额一致
+	expected, err := decoder.expectedReceives(rawTx)
+	if err != nil {
+		return err
+	}
+
+	changeAddress := ""
+	if rawTx.Change != nil {
+		changeAddress = rawTx.Change.Address
+	}
+
+	expectedTickets, err := decoder.expectedTickets(rawTx)
+	if err != nil {
+		return err
+	}
+
+	received := make(map[string]decimal.Decimal)
+	for _, out := range outline.Outs {
+		//票据输出
+		if len(out.Ticket) > 0 {
+			receiver, isTicket := expectedTickets[out.Ticket]
+			if isTicket && receiver == out.Address {
+				delete(expectedTickets, out.Ticket)
+			} else if out.Address != changeAddress {
+				return fmt.Errorf("transaction ticket: %s output address: %s is neither receiver nor change", out.Ticket, out.Address)
+			}
+			if len(out.Currency) == 0 {
+				continue
+			}
+		}
+		key := receiveKey(out.Address, out.Currency)
+		_, isReceiver := expected[key]
+		if !isReceiver && out.Address != changeAddress {
+			return fmt.Errorf("transaction output address: %s is neither receiver nor change", out.Address)
+		}
+		if isReceiver {
+			value, _ := decimal.NewFromString(out.Value)
+			received[key] = received[key].Add(value)
+		}
+	}
+
+	for key, amount := range expected {
+		if !received[key].Equal(amount) {
+			return fmt.Errorf("receiver: %s amount: %s is not equal to output: %s", key, amount.String(), received[key].String())
+		}
+	}
+
+	for ticket, address := range expectedTickets {
+		return fmt.Errorf("ticket: %s to receiver: %s is not in outputs", ticket, address)
+	}
+
+	outputs, err := signedTxOutputs(tx)
+	if err != nil {
+		return err
+	}
+
+	if len(outputs) != len(outline.Outs) {
+		return fmt.Errorf("signed transaction outputs count: %d is not equal to transaction outputs count: %d", len(outputs), len(outline.Outs))
+	}
+
+	//匿名输出的币种和金额在承诺中，无法从签名交易中解出，只按地址和数量匹配，
+	//金额只能由创建交易单时生成的交易结构概要保证
+	unmatched := append([]*TxOutputBrief{}, outline.Outs...)
+	for _, o := range outputs {
+		found := -1
+		for i, b := range unmatched {
+			if b.Address != o.Address {
+				continue
+			}
+			if o.IsPublic && (!strings.EqualFold(b.Currency, o.Currency) || b.Value != o.Value || b.Ticket != o.Ticket) {
+				continue
+			}
+			found = i
+			break
+		}
+		if found < 0 {
+			return fmt.Errorf("signed transaction output address: %s is not expected", o.Address)
+		}
+		unmatched = append(unmatched[:found], unmatched[found+1:]...)
+	}
+
+	//质押指令和合约调用必须一致
+	if err := decoder.verifyStakeCmds(rawTx, &signedTx); err != nil {
+		return err
+	}
+	if err := decoder.verifyContractCall(rawTx, &signedTx); err != nil {
+		return err
+	}
+
+	//3. 手续费必须一致
+	fees, err := decimal.NewFromString(rawTx.Fees)
+	if err != nil {
+		return fmt.Errorf("transaction fees: %s is invalid", rawTx.Fees)
+	}
+	fees = fees.Shift(decoder.wm.Decimal())
+
+	gasPrice, _ := decimal.NewFromString(outline.GasPrice)
+	if !gasPrice.Mul(decimal.New(int64(outline.Gas), 0)).Equal(fees) {
+		return fmt.Errorf("transaction gas: %d * gas price: %s is not equal to fees: %s", outline.Gas, outline.GasPrice, fees.String())
+	}
+
+	txFee, err := parseHexOrNumber(tx.Get("Fee.Value"))
+	if err != nil {
+		return fmt.Errorf("signed transaction fee is invalid, %v", err)
+	}
+	if !txFee.Equal(fees) {
+		return fmt.Errorf("signed transaction fee: %s is not equal to fees: %s", txFee.String(), fees.String())
+	}
+
+	//4. 签名和零知识证明必须存在
+	if len(signedTx.Get("Hash").String()) == 0 {
+		return fmt.Errorf("signed transaction hash is empty")
+	}
+	if len(tx.Get("Sign").String()) == 0 {
+		return fmt.Errorf("signed transaction sign is empty")
+	}
+	for i, in := range tx.Get("Desc_O.Ins").Array() {
+		if len(in.Get("Sign").String()) == 0 {
+			return fmt.Errorf("signed transaction input: %d sign is empty", i)
+		}
+	}
+	for i, in := range tx.Get("Desc_Z.Ins").Array() {
+		if len(in.Get("Proof").String()) == 0 {
+			return fmt.Errorf("signed transaction input: %d proof is empty", i)
+		}
+	}
+	for i, in := range tx.Get("Tx1.Ins_C").Array() {
+		if len(in.Get("Proof").String()) == 0 {
+			return fmt.Errorf("signed transaction input: %d proof is empty", i)
+		}
+	}
+	for i, o := range outputs {
+		if !o.IsPublic && len(o.Proof) == 0 {
+			return fmt.Errorf("signed transaction output: %d proof is empty", i)
+		}
+	}
+
+	return nil
+}
+
+//receiveKey 接收地址和币种Id组成的键
+func receiveKey(address, currencyID string) string {
+	return address + "_" + strings.ToLower(currencyID)
+}
+
+//expectedReceives 交易单各接收地址应收的币种和金额（最小单位），多币种交易从ExtParam的输出列表读取
+func (decoder *TransactionDecoder) expectedReceives(rawTx *openwallet.RawTransaction) (map[string]decimal.Decimal, error) {
+
+	expected := make(map[string]decimal.Decimal)
+
+	outputs, err := decoder.getRawTransactionOutputs(rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	//票据转账没有同质化通证的接收
+	if tickets, ticketErr := decoder.getRawTransactionTickets(rawTx); ticketErr != nil {
+		return nil, ticketErr
+	} else if len(tickets) > 0 {
+		return expected, nil
+	}
+
+	//合约调用的资产随指令转给合约，没有接收输出
+	if call, callErr := decoder.getRawTransactionContractCall(rawTx); callErr != nil {
+		return nil, callErr
+	} else if call != nil {
+		return expected, nil
+	}
+
+	if len(outputs) > 0 {
+		currencyIDs := make(map[string]string)
+		for _, o := range outputs {
+			currencyID, exist := currencyIDs[o.Currency]
+			if !exist {
+				currencyID, err = decoder.wm.LocalCurrencyToId(o.Currency)
+				if err != nil {
+					return nil, err
+				}
+				currencyIDs[o.Currency] = currencyID
+			}
+			amount, _ := decimal.NewFromString(o.Amount)
+			key := receiveKey(o.Address, currencyID)
+			expected[key] = expected[key].Add(amount.Shift(o.Decimals))
+		}
+		return expected, nil
+	}
+
+	var (
+		currency     string
+		coinDecimals int32
+	)
+	if rawTx.Coin.IsContract {
+		currency = rawTx.Coin.Contract.Address
+		coinDecimals = decoder.wm.CoinDecimals(rawTx.Coin)
+	} else {
+		currency = rawTx.Coin.Symbol
+		coinDecimals = decoder.wm.Decimal()
+	}
+
+	currencyID, err := decoder.wm.LocalCurrencyToId(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	for addr, amount := range rawTx.To {
+		deamount, err := decimal.NewFromString(amount)
+		if err != nil {
+			return nil, fmt.Errorf("receiver: %s amount: %s is invalid", addr, amount)
+		}
+		key := receiveKey(addr, currencyID)
+		expected[key] = expected[key].Add(deamount.Shift(coinDecimals))
+	}
+
+	return expected, nil
+}
+
+//expectedTickets 交易单各票据的接收地址
+func (decoder *TransactionDecoder) expectedTickets(rawTx *openwallet.RawTransaction) (map[string]string, error) {
+
+	expected := make(map[string]string)
+
+	tickets, err := decoder.getRawTransactionTickets(rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tickets {
+		expected[t.Ticket] = t.Address
+	}
+
+	return expected, nil
+}
@@ -0,0 +1,301 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/mr-tron/base58"
+	"github.com/sero-cash/go-sero/common/hexutil"
+	"github.com/tidwall/gjson"
+	"testing"
+)
+
+//testPKrHex 测试收款码的hex
+func testPKrHex() string {
+	pkr, _ := base58.Decode(testPKr1)
+	return hexutil.Encode(pkr)
+}
+
+func TestParseHexOrNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    string
+		wantErr bool
+	}{
+		{name: "hex", json: `{"v":"0x61a8"}`, want: "25000"},
+		{name: "upper hex prefix", json: `{"v":"0X10"}`, want: "16"},
+		{name: "decimal string", json: `{"v":"1000000000"}`, want: "1000000000"},
+		{name: "number", json: `{"v":25000}`, want: "25000"},
+		{name: "missing", json: `{}`, wantErr: true},
+		{name: "invalid hex", json: `{"v":"0xzz"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHexOrNumber(gjson.Get(tt.json, "v"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHexOrNumber() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("parseHexOrNumber() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTxOutline(t *testing.T) {
+
+	pkrHex := testPKrHex()
+
+	tests := []struct {
+		name     string
+		txParam  string
+		wantIns  []string
+		wantOuts []*TxOutputBrief
+		wantGas  uint64
+		wantErr  bool
+	}{
+		{
+			name: "token and ticket outputs",
+			txParam: fmt.Sprintf(`{"Gas":"0x61a8","GasPrice":"0x3b9aca00",
+				"Ins":[{"Out":{"Root":"0xaa"}},{"Root":"0xbb"}],
+				"Outs":[{"PKr":"%s","Asset":{"Tkn":{"Currency":"0x01","Value":"0x64"}}},
+					{"PKr":"%s","Asset":{"Tkt":{"Category":"0x02","Value":"0xABCD"}}}]}`, pkrHex, pkrHex),
+			wantIns: []string{"0xaa", "0xbb"},
+			wantOuts: []*TxOutputBrief{
+				{Address: testPKr1, Currency: "0x01", Value: "100"},
+				{Address: testPKr1, Value: "0", Category: "0x02", Ticket: "0xabcd"},
+			},
+			wantGas: 25000,
+		},
+		{name: "not object", txParam: `[]`, wantErr: true},
+		{name: "missing gas", txParam: `{"GasPrice":"0x1"}`, wantErr: true},
+		{name: "invalid output PKr", txParam: `{"Gas":1,"GasPrice":1,"Outs":[{"PKr":"xyz"}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			param := gjson.Parse(tt.txParam)
+			got, err := NewTxOutline(&param)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTxOutline() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Gas != tt.wantGas || got.GasPrice != "1000000000" {
+				t.Errorf("NewTxOutline() gas = %d, gas price = %s", got.Gas, got.GasPrice)
+			}
+			if !equalStringSet(got.Ins, tt.wantIns) {
+				t.Errorf("NewTxOutline() ins = %v, want %v", got.Ins, tt.wantIns)
+			}
+			if len(got.Outs) != len(tt.wantOuts) {
+				t.Fatalf("NewTxOutline() outs = %d, want %d", len(got.Outs), len(tt.wantOuts))
+			}
+			for i, o := range got.Outs {
+				if *o != *tt.wantOuts[i] {
+					t.Errorf("NewTxOutline() out %d = %+v, want %+v", i, o, tt.wantOuts[i])
+				}
+			}
+
+			//记录到交易单后读取的概要一致
+			decoder := tw.TxDecoder.(*TransactionDecoder)
+			rawTx := &openwallet.RawTransaction{}
+			if err := decoder.setRawTransactionOutline(rawTx, &param, nil); err != nil {
+				t.Fatalf("setRawTransactionOutline() error = %v", err)
+			}
+			saved, err := decoder.getRawTransactionOutline(rawTx)
+			if err != nil {
+				t.Fatalf("getRawTransactionOutline() error = %v", err)
+			}
+			if saved.Gas != got.Gas || saved.GasPrice != got.GasPrice || !equalStringSet(saved.Ins, got.Ins) || len(saved.Outs) != len(got.Outs) {
+				t.Errorf("getRawTransactionOutline() = %+v, want %+v", saved, got)
+			}
+			for i, o := range saved.Outs {
+				if *o != *got.Outs[i] {
+					t.Errorf("getRawTransactionOutline() out %d = %+v, want %+v", i, o, got.Outs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionDecoder_getRawTransactionOutlineMissing(t *testing.T) {
+	decoder := tw.TxDecoder.(*TransactionDecoder)
+	tests := []struct {
+		name  string
+		rawTx *openwallet.RawTransaction
+	}{
+		{name: "no ext param", rawTx: &openwallet.RawTransaction{}},
+		{name: "no outline", rawTx: &openwallet.RawTransaction{ExtParam: `{"memo":"a"}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decoder.getRawTransactionOutline(tt.rawTx); err == nil {
+				t.Errorf("getRawTransactionOutline() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestWalletManager_restoreRawTxExt(t *testing.T) {
+
+	wm, cleanup := testUnspentDBWalletManager(t)
+	defer cleanup()
+
+	ext := `{"txOutline":{"ins":["r1","r2"],"outs":[],"gas":25000,"gasPrice":"1000000000"},"memo":{"a":"m"}}`
+	built := testBuiltRawTx("", "r1", "r2")
+	built.ExtParam = ext
+	if err := wm.SaveRawTxExt(built); err != nil {
+		t.Fatalf("SaveRawTxExt() error = %v", err)
+	}
+
+	otherAccount := testBuiltRawTx("", "r2", "r1")
+	otherAccount.Account = &openwallet.AssetsAccount{AccountID: "other"}
+	otherAccount.Signatures = map[string][]*openwallet.KeySignature{"other": built.Signatures["tk"]}
+
+	clientOutline := testBuiltRawTx("", "r3")
+	clientOutline.ExtParam = `{"txOutline":{"ins":["r3"]}}`
+
+	forged := testBuiltRawTx("", "r1", "r2")
+	forged.ExtParam = `{"txOutline":{"ins":["r1","r2"],"outs":[{"address":"x"}]}}`
+
+	tests := []struct {
+		name    string
+		rawTx   *openwallet.RawTransaction
+		want    string
+		wantErr bool
+	}{
+		{name: "sdk transaction without ext param", rawTx: testBuiltRawTx("", "r2", "r1"), want: ext},
+		{name: "server record overrides submitted ext param", rawTx: forged, want: ext},
+		{name: "other account", rawTx: otherAccount, wantErr: true},
+		{name: "no record", rawTx: testBuiltRawTx("", "r3"), wantErr: true},
+		{name: "no record with outline", rawTx: clientOutline, want: clientOutline.ExtParam},
+		{name: "no signatures", rawTx: testBuiltRawTx(""), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := wm.restoreRawTxExt(tt.rawTx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("restoreRawTxExt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tt.rawTx.ExtParam != tt.want {
+				t.Errorf("restoreRawTxExt() ext param = %s, want %s", tt.rawTx.ExtParam, tt.want)
+			}
+		})
+	}
+
+	//交易确认后删除记录
+	if err := wm.DeleteRawTxExt([]string{"r2", "r1"}); err != nil {
+		t.Fatalf("DeleteRawTxExt() error = %v", err)
+	}
+	if err := wm.restoreRawTxExt(testBuiltRawTx("", "r1", "r2")); err == nil {
+		t.Errorf("restoreRawTxExt() after delete error = nil, want error")
+	}
+}
+
+func TestSignedTxOutputs(t *testing.T) {
+
+	pkrHex := testPKrHex()
+
+	tests := []struct {
+		name       string
+		tx         string
+		wantPublic []bool
+		wantValues []string
+		wantErr    bool
+	}{
+		{
+			name: "desc outputs",
+			tx: fmt.Sprintf(`{"Desc_O":{"Outs":[{"Addr":"%s","Asset":{"Tkn":{"Currency":"0x01","Value":"0xa"}}}]},
+				"Desc_Z":{"Outs":[{"PKr":"%s","Proof":"0x01"}]}}`, pkrHex, pkrHex),
+			wantPublic: []bool{true, false},
+			wantValues: []string{"10", ""},
+		},
+		{
+			name: "tx1 outputs",
+			tx: fmt.Sprintf(`{"Tx1":{"Outs_P":[{"PKr":"%s","Asset":{"Tkt":{"Value":"0xAB"}}}],
+				"Outs_C":[{"PKr":"%s","Proof":"0x02"}]}}`, pkrHex, pkrHex),
+			wantPublic: []bool{true, false},
+			wantValues: []string{"0", ""},
+		},
+		{name: "invalid PKr", tx: `{"Desc_Z":{"Outs":[{"PKr":"bad"}]}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := signedTxOutputs(gjson.Parse(tt.tx))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("signedTxOutputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.wantPublic) {
+				t.Fatalf("signedTxOutputs() = %d outputs, want %d", len(got), len(tt.wantPublic))
+			}
+			for i, o := range got {
+				if o.Address != testPKr1 || o.IsPublic != tt.wantPublic[i] || o.Value != tt.wantValues[i] {
+					t.Errorf("signedTxOutputs() output %d = %+v", i, o)
+				}
+				if !o.IsPublic && len(o.Proof) == 0 {
+					t.Errorf("signedTxOutputs() output %d proof is empty", i)
+				}
+			}
+		})
+	}
+}
+
+func TestSignedTxRoots(t *testing.T) {
+	tests := []struct {
+		name string
+		tx   string
+		want []string
+	}{
+		{name: "roots", tx: `{"Roots":["0x01","0x02"],"Tx":{"Desc_O":{"Ins":[{"Root":"0x03"}]}}}`, want: []string{"0x01", "0x02"}},
+		{name: "desc inputs", tx: `{"Tx":{"Desc_O":{"Ins":[{"Root":"0x03"}]},"Tx1":{"Ins_P":[{"Root":"0x04"}]}}}`, want: []string{"0x03", "0x04"}},
+		{name: "empty", tx: `{}`, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signedTx := gjson.Parse(tt.tx)
+			if got := signedTxRoots(&signedTx); !equalStringSet(got, tt.want) {
+				t.Errorf("signedTxRoots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEqualStringSet(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []string
+		b    []string
+		want bool
+	}{
+		{name: "same order", a: []string{"0xA", "0xb"}, b: []string{"0xa", "0xB"}, want: true},
+		{name: "different order", a: []string{"0x1", "0x2"}, b: []string{"0x2", "0x1"}, want: true},
+		{name: "different length", a: []string{"0x1"}, b: []string{"0x1", "0x1"}, want: false},
+		{name: "different item", a: []string{"0x1", "0x2"}, b: []string{"0x1", "0x3"}, want: false},
+		{name: "duplicate item", a: []string{"0x1", "0x1"}, b: []string{"0x1", "0x2"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := equalStringSet(tt.a, tt.b); got != tt.want {
+				t.Errorf("equalStringSet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"fmt"
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/blocktree/sero-adapter/sero_addrdec"
+	"github.com/mr-tron/base58"
+	"time"
+)
+
+//WatchAccount 只有跟踪公钥TK的观察账户，可以扫描和查询余额，不能签名
+type WatchAccount struct {
+	AccountID    string `json:"accountID" storm:"id"` //跟踪公钥TK
+	PublicKey    string `json:"publicKey"`
+	Alias        string `json:"alias"`
+	DerivedIndex uint64 `json:"derivedIndex"` //已派生的收款和找零地址索引上限，不含
+	CreateAt     int64  `json:"createAt"`
+}
+
+//WatchAddress 观察账户按地址索引派生的收款和找零地址，扫描器按地址识别观察账户的输出
+type WatchAddress struct {
+	Address   string `json:"address" storm:"id"`
+	AccountID string `json:"accountID" storm:"index"`
+	Index     uint64 `json:"index"`
+	IsChange  bool   `json:"isChange"`
+}
+
+//NewWatchOnlyAccount 按跟踪公钥生成观察账户，不登记到本地数据库，返回的账户没有HDPath
+func (wm *WalletManager) NewWatchOnlyAccount(alias, tk string) (*openwallet.AssetsAccount, error) {
+
+	tkBytes, err := base58.Decode(tk)
+	if err != nil || len(tkBytes) != sero_addrdec.PKLength {
+		return nil, fmt.Errorf("tk: %s is invalid", tk)
+	}
+
+	pk, err := wm.LocalTk2Pk(tk)
+	if err != nil {
+		return nil, fmt.Errorf("tk: %s can not derive public key, %v", tk, err)
+	}
+
+	account := &openwallet.AssetsAccount{}
+	account.Alias = alias
+	account.Symbol = wm.Symbol()
+	account.Required = 1
+	account.AccountID = tk //跟踪公钥作为accountID
+	account.PublicKey = pk //sero公钥作为账户公钥
+	account.Index = 0
+	account.AddressIndex = -1
+
+	return account, nil
+}
+
+//CreateWatchOnlyAccount 按跟踪公钥登记观察账户，并派生前addressGap个收款和找零地址，
+//扫描器按派生的地址识别它的输出
+func (wm *WalletManager) CreateWatchOnlyAccount(alias, tk string) (*openwallet.AssetsAccount, error) {
+
+	account, err := wm.NewWatchOnlyAccount(alias, tk)
+	if err != nil {
+		return nil, err
+	}
+
+	watch, err := wm.GetWatchAccount(tk)
+	if err != nil {
+		return nil, err
+	}
+	if watch == nil {
+		watch = &WatchAccount{
+			AccountID: tk,
+			PublicKey: account.PublicKey,
+			Alias:     alias,
+			CreateAt:  time.Now().Unix(),
+		}
+	}
+
+	err = wm.deriveWatchAddresses(watch, wm.watchAddressGap())
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+//watchAddressGap 观察账户已使用的地址之后预先派生的地址数
+func (wm *WalletManager) watchAddressGap() uint64 {
+	if wm.Config.AddressGap == 0 {
+		return DefaultAddressGap
+	}
+	return wm.Config.AddressGap
+}
+
+//deriveWatchAddresses 派生观察账户的收款和找零地址到索引上限，已派生的地址不重复派生
+func (wm *WalletManager) deriveWatchAddresses(watch *WatchAccount, derivedIndex uint64) error {
+
+	account := &openwallet.AssetsAccount{
+		AccountID: watch.AccountID,
+		PublicKey: watch.PublicKey,
+		Symbol:    wm.Symbol(),
+	}
+
+	addresses := make([]*WatchAddress, 0)
+	for index := watch.DerivedIndex; index < derivedIndex; index++ {
+		for _, isChange := range []bool{false, true} {
+			addr, err := wm.Decoder.CreateDerivedAddress(account, index, isChange)
+			if err != nil {
+				return err
+			}
+			addresses = append(addresses, &WatchAddress{
+				Address:   addr.Address,
+				AccountID: watch.AccountID,
+				Index:     index,
+				IsChange:  isChange,
+			})
+		}
+	}
+
+	tx, err := wm.unspentDB.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, addr := range addresses {
+		err = tx.Save(addr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if derivedIndex > watch.DerivedIndex {
+		watch.DerivedIndex = derivedIndex
+	}
+	err = tx.Save(watch)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//registerWatchOnlyAccount openw-server为没有HDPath的账户创建地址时登记观察账户，已登记的不重复登记
+func (wm *WalletManager) registerWatchOnlyAccount(account *openwallet.AssetsAccount) error {
+
+	watch, err := wm.GetWatchAccount(account.AccountID)
+	if err != nil {
+		return err
+	}
+	if watch != nil {
+		return nil
+	}
+
+	_, err = wm.CreateWatchOnlyAccount(account.Alias, account.AccountID)
+	if err != nil {
+		return err
+	}
+
+	wm.Log.Infof("watch-only account: %s registered", account.AccountID)
+
+	return nil
+}
+
+//GetWatchAccount 查询观察账户，不是观察账户返回nil
+func (wm *WalletManager) GetWatchAccount(tk string) (*WatchAccount, error) {
+	var watch WatchAccount
+	err := wm.unspentDB.One("AccountID", tk, &watch)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &watch, nil
+}
+
+//ListWatchAccounts 查询所有观察账户
+func (wm *WalletManager) ListWatchAccounts() ([]*WatchAccount, error) {
+	var list []*WatchAccount
+	err := wm.unspentDB.All(&list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return list, nil
+}
+
+//IsWatchOnlyAccount 账户是否为观察账户
+func (wm *WalletManager) IsWatchOnlyAccount(tk string) bool {
+	watch, _ := wm.GetWatchAccount(tk)
+	return watch != nil
+}
+
+//checkCanSign 观察账户没有私钥，拒绝签名
+func (wm *WalletManager) checkCanSign(accountID string) error {
+	if wm.IsWatchOnlyAccount(accountID) {
+		return openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "account: %s is watch-only, it has no private key to sign transaction", accountID)
+	}
+	return nil
+}
+
+//scanWatchAccounts 地址不在扫描目标中时，按观察账户派生的地址判断归属，匿名和公开输出都适用。
+//命中的地址接近已派生的索引上限时继续派生，保持已使用地址之后有addressGap个地址
+func (bs *SEROBlockScanner) scanWatchAccounts(address string) (string, bool, bool) {
+
+	var addr WatchAddress
+	err := bs.wm.unspentDB.One("Address", address, &addr)
+	if err != nil {
+		return "", false, false
+	}
+
+	watch, err := bs.wm.GetWatchAccount(addr.AccountID)
+	if err != nil || watch == nil {
+		return "", false, false
+	}
+
+	gap := bs.wm.watchAddressGap()
+	if addr.Index+gap > watch.DerivedIndex {
+		err = bs.wm.deriveWatchAddresses(watch, addr.Index+gap)
+		if err != nil {
+			bs.wm.Log.Warningf("derive addresses of watch-only account: %s failed, unexpected error: %v", watch.AccountID, err)
+		}
+	}
+
+	return addr.AccountID, addr.IsChange, true
+}
+
+//scanWatchAccountsByTK 不是观察账户派生地址的匿名输出，逐个用观察账户的TK请求节点解密，
+//能通过承诺校验的输出属于该账户，例如随机rnd的收款码和付款方用PK派生的一次性收款码。
+//公开输出的解密不需要TK，节点也没有提供按TK判断收款码归属的接口，只能按派生的地址识别
+func (bs *SEROBlockScanner) scanWatchAccountsByTK(out Out) (string, bool) {
+
+	if out.State.OS.Out_Z == nil {
+		return "", false
+	}
+
+	watches, err := bs.wm.ListWatchAccounts()
+	if err != nil {
+		bs.wm.Log.Warningf("list watch-only accounts failed, unexpected error: %v", err)
+		return "", false
+	}
+
+	for _, watch := range watches {
+		tkBytes, err := base58.Decode(watch.AccountID)
+		if err != nil {
+			continue
+		}
+		decOuts, err := bs.wm.DecOut([]Out{out}, tkBytes)
+		if err != nil {
+			bs.wm.Log.Warningf("decode output by watch-only account: %s failed, unexpected error: %v", watch.AccountID, err)
+			continue
+		}
+		if len(decOuts) > 0 && len(decOuts[0].Nils) > 0 {
+			return watch.AccountID, true
+		}
+	}
+
+	return "", false
+}
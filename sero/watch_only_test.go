@@ -0,0 +1,185 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sero
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/blocktree/sero-adapter/client"
+	"github.com/mr-tron/base58"
+	"github.com/sero-cash/go-sero/common/hexutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSEROBlockScanner_scanWatchAccounts(t *testing.T) {
+
+	wm, cleanup := testUnspentDBWalletManager(t)
+	defer cleanup()
+	server := testPk2PkrServer(false)
+	defer server.Close()
+	wm.WalletClient = client.NewClient(server.URL, false)
+	wm.Decoder.Client = wm.WalletClient
+	wm.Config.AddressGap = 3
+	bs := &SEROBlockScanner{wm: wm}
+
+	//64字节的TK
+	tk := testPK()
+	if _, err := wm.CreateWatchOnlyAccount("watch", tk); err != nil {
+		t.Fatalf("CreateWatchOnlyAccount() error = %v", err)
+	}
+
+	account := &openwallet.AssetsAccount{AccountID: tk, PublicKey: testPK()}
+	derived := func(index uint64, isChange bool) string {
+		addr, err := wm.Decoder.CreateDerivedAddress(account, index, isChange)
+		if err != nil {
+			t.Fatalf("CreateDerivedAddress() error = %v", err)
+		}
+		return addr.Address
+	}
+
+	tests := []struct {
+		name        string
+		address     string
+		want        bool
+		wantChange  bool
+		wantDerived uint64
+	}{
+		{name: "receive", address: derived(0, false), want: true, wantDerived: 3},
+		{name: "change", address: derived(1, true), want: true, wantChange: true, wantDerived: 4},
+		{name: "not derived yet", address: derived(5, false), want: false, wantDerived: 4},
+		{name: "extend near the derived index", address: derived(3, false), want: true, wantDerived: 6},
+		{name: "extended", address: derived(5, false), want: true, wantDerived: 8},
+		{name: "not watch account", address: testPKr1, want: false, wantDerived: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, isChange, ok := bs.scanWatchAccounts(tt.address)
+			if ok != tt.want {
+				t.Fatalf("scanWatchAccounts() ok = %v, want %v", ok, tt.want)
+			}
+			if ok && (got != tk || isChange != tt.wantChange) {
+				t.Errorf("scanWatchAccounts() = %s, %v, want %s, %v", got, isChange, tk, tt.wantChange)
+			}
+			watch, err := wm.GetWatchAccount(tk)
+			if err != nil || watch == nil {
+				t.Fatalf("GetWatchAccount() = %v, error = %v", watch, err)
+			}
+			if watch.DerivedIndex != tt.wantDerived {
+				t.Errorf("watch account derived index = %d, want %d", watch.DerivedIndex, tt.wantDerived)
+			}
+		})
+	}
+
+	//重复登记不回退已派生的索引
+	if _, err := wm.CreateWatchOnlyAccount("watch", tk); err != nil {
+		t.Fatalf("CreateWatchOnlyAccount() error = %v", err)
+	}
+	if watch, _ := wm.GetWatchAccount(tk); watch == nil || watch.DerivedIndex != 8 {
+		t.Errorf("watch account after registering again = %+v, want derived index 8", watch)
+	}
+}
+
+func TestAddressDecoderV2_CustomCreateAddressRegisterWatchOnly(t *testing.T) {
+
+	wm, cleanup := testUnspentDBWalletManager(t)
+	defer cleanup()
+	server := testPk2PkrServer(false)
+	defer server.Close()
+	wm.WalletClient = client.NewClient(server.URL, false)
+	wm.Decoder.Client = wm.WalletClient
+	wm.Decoder.RegisterWatchOnly = wm.registerWatchOnlyAccount
+	wm.Config.AddressGap = 2
+
+	tests := []struct {
+		name      string
+		hdPath    string
+		wantWatch bool
+	}{
+		{name: "account with hd path", hdPath: "m/44'/88'/0'", wantWatch: false},
+		{name: "watch-only account", hdPath: "", wantWatch: true},
+		{name: "registered again", hdPath: "", wantWatch: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := &openwallet.AssetsAccount{AccountID: testPK(), PublicKey: testPK(), HDPath: tt.hdPath}
+			if _, err := wm.Decoder.CustomCreateAddress(account, 0); err != nil {
+				t.Fatalf("CustomCreateAddress() error = %v", err)
+			}
+			if got := wm.IsWatchOnlyAccount(testPK()); got != tt.wantWatch {
+				t.Errorf("IsWatchOnlyAccount() = %v, want %v", got, tt.wantWatch)
+			}
+		})
+	}
+}
+
+//testDecOutServer 模拟节点local_decOut，只有指定TK能解出匿名输出
+func testDecOutServer(ownerTK string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		var tk string
+		json.Unmarshal(body.Params[1], &tk)
+		tkBytes, _ := hexutil.Decode(tk)
+		if base58.Encode(tkBytes) == ownerTK {
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":"1","result":[{"Asset":{},"Memo":"","Nils":["0x01","0x02"]}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":"1","result":[{"Asset":{},"Memo":"","Nils":null}]}`)
+	}))
+}
+
+func TestSEROBlockScanner_scanWatchAccountsByTK(t *testing.T) {
+
+	wm, cleanup := testUnspentDBWalletManager(t)
+	defer cleanup()
+	bs := &SEROBlockScanner{wm: wm}
+
+	owner := testPK()
+	other := base58.Encode(make([]byte, 64))
+	for _, tk := range []string{other, owner} {
+		if err := wm.unspentDB.Save(&WatchAccount{AccountID: tk}); err != nil {
+			t.Fatalf("save watch account failed, unexpected error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		owner string
+		out   Out
+		want  string
+	}{
+		{name: "anonymous output of watch account", owner: owner, out: Out{Root: "r1", State: RootState{OS: OutState{Out_Z: &Out_Z{}}}}, want: owner},
+		{name: "anonymous output of others", owner: "", out: Out{Root: "r2", State: RootState{OS: OutState{Out_Z: &Out_Z{}}}}},
+		{name: "public output", owner: owner, out: Out{Root: "r3", State: RootState{OS: OutState{Out_O: &Out_O{}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testDecOutServer(tt.owner)
+			defer server.Close()
+			wm.WalletClient = client.NewClient(server.URL, false)
+
+			got, ok := bs.scanWatchAccountsByTK(tt.out)
+			if ok != (len(tt.want) > 0) || got != tt.want {
+				t.Errorf("scanWatchAccountsByTK() = %s, %v, want %s", got, ok, tt.want)
+			}
+		})
+	}
+}
@@ -1,90 +1,219 @@
 package sero_addrdec
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
-	"github.com/blocktree/openwallet/hdkeystore"
 	"github.com/blocktree/openwallet/openwallet"
 	"github.com/blocktree/sero-adapter/client"
 	"github.com/mr-tron/base58"
 	"github.com/sero-cash/go-sero/common/hexutil"
+	"strings"
 )
 
 var (
 	Default = &AddressDecoderV2{}
 )
 
+const (
+	PKrLength          = 96 //收款码PKr的字节长度
+	PKLength           = 64 //账户公钥PK的字节长度，合约地址的base58短格式也是64字节
+	ShortAddressLength = 20 //合约短地址的字节长度，hex编码
+)
+
+const (
+	AddressTypePKr      = "pkr"      //收款码
+	AddressTypePK       = "pk"       //账户公钥
+	AddressTypeContract = "contract" //合约地址，后32字节为0的96字节地址
+	AddressTypeShort    = "short"    //合约短地址
+)
+
+const (
+	addressRndDomain = "sero-adapter/pkr-rnd" //派生rnd的域名，防止与其他用途的HMAC混用
+	receiveBranch    = 0                      //收款地址分支
+	changeBranch     = 1                      //找零地址分支
+)
+
 //AddressDecoderV2
 type AddressDecoderV2 struct {
 	*openwallet.AddressDecoderV2Base
 	IsTestNet bool
 	Client *client.Client
+	//RegisterWatchOnly 为没有HDPath的观察账户创建地址时回调，由加载扫块数据库的适配器登记观察账户
+	RegisterWatchOnly func(account *openwallet.AssetsAccount) error
+}
+
+//AddressInfo 地址的类型和字节
+type AddressInfo struct {
+	Address string
+	Type    string
+	Bytes   []byte
+}
+
+//ParseAddress 解析并识别地址类型：96字节为收款码PKr（后32字节为0时为合约地址），
+//64字节为账户公钥PK，0x开头的20字节hex为合约短地址。
+//曲线点的有效性需要czero库，离线无法验证，由节点在生成收款码或交易时检查
+func ParseAddress(address string) (*AddressInfo, error) {
+
+	if len(address) == 0 {
+		return nil, fmt.Errorf("address is empty")
+	}
+
+	if strings.HasPrefix(address, "0x") || strings.HasPrefix(address, "0X") {
+		short, err := hexutil.Decode(strings.ToLower(address))
+		if err != nil || len(short) != ShortAddressLength {
+			return nil, fmt.Errorf("address: %s is not a valid short address", address)
+		}
+		return &AddressInfo{Address: address, Type: AddressTypeShort, Bytes: short}, nil
+	}
+
+	data, err := base58.Decode(address)
+	if err != nil {
+		return nil, fmt.Errorf("address: %s is not base58 encoded", address)
+	}
+
+	info := &AddressInfo{Address: address, Bytes: data}
+	switch len(data) {
+	case PKrLength:
+		info.Type = AddressTypePKr
+		if isZeroBytes(data[PKLength:]) {
+			info.Type = AddressTypeContract
+		}
+		if isZeroBytes(data[:PKLength]) {
+			return nil, fmt.Errorf("address: %s is empty", address)
+		}
+	case PKLength:
+		if isZeroBytes(data) {
+			return nil, fmt.Errorf("address: %s is empty", address)
+		}
+		info.Type = AddressTypePK
+	default:
+		return nil, fmt.Errorf("address: %s length: %d is invalid", address, len(data))
+	}
+
+	return info, nil
+}
+
+//IsValidAddress 地址是否为有效的PKr、PK、合约地址或合约短地址
+func IsValidAddress(address string) bool {
+	_, err := ParseAddress(address)
+	return err == nil
+}
+
+//IsValidPKr 地址是否为有效的收款码PKr
+func IsValidPKr(address string) bool {
+	info, err := ParseAddress(address)
+	return err == nil && info.Type == AddressTypePKr
+}
+
+//isZeroBytes 字节是否全为0
+func isZeroBytes(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
 }
 
 // AddressDecode decode address
 func (dec *AddressDecoderV2) AddressDecode(pubKey string, opts ...interface{}) ([]byte, error) {
 
-	return base58.Decode(pubKey)
+	info, err := ParseAddress(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return info.Bytes, nil
 }
 
 // AddressEncode encode address
 func (dec *AddressDecoderV2) AddressEncode(hash []byte, opts ...interface{}) (string, error) {
+	if len(hash) != PKrLength && len(hash) != PKLength {
+		return "", fmt.Errorf("address bytes length: %d is invalid", len(hash))
+	}
 	pkrAddress := base58.Encode(hash[:])
 	return pkrAddress, nil
 }
 
+//IsValidAddress 地址是否有效
+func (dec *AddressDecoderV2) IsValidAddress(address string) bool {
+	return IsValidAddress(address)
+}
+
+//DeriveAddressRnd 按账户跟踪公钥和地址索引派生PKr的rnd，
+//rnd = HMAC-SHA256(TK, domain || branch || index)，找零地址使用独立分支，地址可以在灾备时重新生成
+func DeriveAddressRnd(tk string, newIndex uint64, isChange bool) ([]byte, error) {
+
+	if len(tk) == 0 {
+		return nil, fmt.Errorf("account tk is empty")
+	}
+
+	branch := byte(receiveBranch)
+	if isChange {
+		branch = changeBranch
+	}
+
+	msg := make([]byte, 0, len(addressRndDomain)+9)
+	msg = append(msg, addressRndDomain...)
+	msg = append(msg, branch)
+	index := make([]byte, 8)
+	binary.BigEndian.PutUint64(index, newIndex)
+	msg = append(msg, index...)
+
+	mac := hmac.New(sha256.New, []byte(tk))
+	mac.Write(msg)
+	return mac.Sum(nil), nil
+}
+
+//CustomCreateAddress 按地址索引派生收款地址，账户AccountID为跟踪公钥
 func (dec *AddressDecoderV2) CustomCreateAddress(account *openwallet.AssetsAccount, newIndex uint64) (*openwallet.Address, error) {
 
-	if dec.Client == nil {
-		return nil, fmt.Errorf("sero client is nil")
+	//openw-server为观察账户创建地址时登记观察账户
+	if len(account.HDPath) == 0 && dec.RegisterWatchOnly != nil {
+		err := dec.RegisterWatchOnly(account)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	rnd, err := hdkeystore.GenerateSeed(32)
+	return dec.CreateDerivedAddress(account, newIndex, false)
+}
+
+//CreateDerivedAddress 按地址索引派生收款或找零地址
+func (dec *AddressDecoderV2) CreateDerivedAddress(account *openwallet.AssetsAccount, newIndex uint64, isChange bool) (*openwallet.Address, error) {
+
+	rnd, err := DeriveAddressRnd(account.AccountID, newIndex, isChange)
 	if err != nil {
 		return nil, err
 	}
 
-	address, err := dec.Client.LocalPk2Pkr(account.PublicKey, hexutil.Encode(rnd))
+	newAddr, err := dec.CreateFixAddress(account, rnd, newIndex)
 	if err != nil {
 		return nil, err
 	}
 
-	//publickey, err := base58.Decode(account.PublicKey)
-	//if err != nil {
-	//	result.Success = false
-	//	result.Err = err
-	//	return result
-	//}
-	//
-	//var pk keys.Uint512
-	//copy(pk[:], publickey[:])
-	//rnd := keys.RandUint256()
-	//pkr := keys.Addr2PKr(&pk, &rnd) //收款码
-	//address := base58.Encode(pkr[:])
-
-	newAddr := &openwallet.Address{
-		AccountID: account.AccountID,
-		Symbol:    account.Symbol,
-		Index:     newIndex,
-		Address:   address,
-		Balance:   "0",
-		WatchOnly: false,
-		PublicKey: account.PublicKey,
-		Alias:     "",
-		Tag:       "",
-		HDPath:    account.HDPath,
-		IsChange:  false,
-	}
+	newAddr.IsChange = isChange
 
 	return newAddr, nil
 }
 
-
+//CreateFixAddress 使用指定的rnd生成地址，rnd为空时按地址索引派生
 func (dec *AddressDecoderV2) CreateFixAddress(account *openwallet.AssetsAccount, rnd []byte, newIndex uint64) (*openwallet.Address, error) {
 
 	if dec.Client == nil {
 		return nil, fmt.Errorf("sero client is nil")
 	}
 
+	if len(rnd) == 0 {
+		derived, err := DeriveAddressRnd(account.AccountID, newIndex, false)
+		if err != nil {
+			return nil, err
+		}
+		rnd = derived
+	}
+
 	address, err := dec.Client.LocalPk2Pkr(account.PublicKey, hexutil.Encode(rnd))
 	if err != nil {
 		return nil, err
@@ -1,6 +1,7 @@
 package sero_addrdec
 
 import (
+	"github.com/mr-tron/base58"
 	"reflect"
 	"testing"
 )
@@ -24,6 +25,11 @@ func TestAddressDecoderV2_AddressDecode(t *testing.T) {
 		{
 			name: "abbc bech32", fields: fields{IsTestNet: false},
 			args:    args{addr: "ABBC51wiJaHZxebPu562Kh91ozaeamqVj9s9k5zNxYpxV22FyefT56"},
+			wantErr: true,
+		},
+		{
+			name: "sero pkr", fields: fields{IsTestNet: false},
+			args:    args{addr: "7EHTPNYhKNuULtwQEgFK3NuYbf3qAGNoowRHo5BHZij3mdB7WJxZ4oRJt91HbVL88pxDmBV159MsTjiwzRMD7FgqideToxcNK63VPU7LJ9ff37kJ38Yx41cSBXgdAhFRwJy"},
 			wantErr: false,
 		},
 	}
@@ -43,3 +49,74 @@ func TestAddressDecoderV2_AddressDecode(t *testing.T) {
 		})
 	}
 }
+
+func TestDeriveAddressRnd(t *testing.T) {
+	tk := "2dwVs2oBxCtSqGNHaPuwszBw5uokVbBxSGc2D9qhyVnXNcv5NnXfb4Py1JxWsUnUcPpAn1ctoH2ZmWW6GRcfKm5m"
+
+	rnd1, err := DeriveAddressRnd(tk, 1, false)
+	if err != nil {
+		t.Errorf("DeriveAddressRnd() error = %v", err)
+		return
+	}
+	if len(rnd1) != 32 {
+		t.Errorf("DeriveAddressRnd() length = %d, want 32", len(rnd1))
+	}
+
+	again, _ := DeriveAddressRnd(tk, 1, false)
+	if !reflect.DeepEqual(rnd1, again) {
+		t.Errorf("DeriveAddressRnd() is not deterministic")
+	}
+
+	rnd2, _ := DeriveAddressRnd(tk, 2, false)
+	change1, _ := DeriveAddressRnd(tk, 1, true)
+	if reflect.DeepEqual(rnd1, rnd2) || reflect.DeepEqual(rnd1, change1) {
+		t.Errorf("DeriveAddressRnd() should differ by index and branch")
+	}
+
+	if _, err := DeriveAddressRnd("", 1, false); err == nil {
+		t.Errorf("DeriveAddressRnd() with empty tk should fail")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	pkr := "7EHTPNYhKNuULtwQEgFK3NuYbf3qAGNoowRHo5BHZij3mdB7WJxZ4oRJt91HbVL88pxDmBV159MsTjiwzRMD7FgqideToxcNK63VPU7LJ9ff37kJ38Yx41cSBXgdAhFRwJy"
+	pkrBytes, _ := base58.Decode(pkr)
+	pk := base58.Encode(pkrBytes[:PKLength])
+	contract := base58.Encode(append(append([]byte{}, pkrBytes[:PKLength]...), make([]byte, PKrLength-PKLength)...))
+
+	tests := []struct {
+		name     string
+		addr     string
+		wantType string
+		wantErr  bool
+	}{
+		{name: "pkr", addr: pkr, wantType: AddressTypePKr},
+		{name: "pk", addr: pk, wantType: AddressTypePK},
+		{name: "contract", addr: contract, wantType: AddressTypeContract},
+		{name: "short address", addr: "0x5a9f1b2c3d4e5f60718293a4b5c6d7e8f9012345", wantType: AddressTypeShort},
+		{name: "empty", addr: "", wantErr: true},
+		{name: "not base58", addr: "0OIl" + pkr[4:], wantErr: true},
+		{name: "truncated pkr", addr: pkr[:len(pkr)-10], wantErr: true},
+		{name: "short address length", addr: "0x5a9f1b2c", wantErr: true},
+		{name: "zero pk", addr: base58.Encode(make([]byte, PKLength)), wantErr: true},
+		{name: "abbc address", addr: "ABBC51wiJaHZxebPu562Kh91ozaeamqVj9s9k5zNxYpxV22FyefT56", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ParseAddress(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseAddress() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got := IsValidAddress(tt.addr); got == tt.wantErr {
+				t.Errorf("IsValidAddress() = %v, want %v", got, !tt.wantErr)
+			}
+			if err == nil && info.Type != tt.wantType {
+				t.Errorf("ParseAddress() type = %s, want %s", info.Type, tt.wantType)
+			}
+			if IsValidPKr(tt.addr) != (tt.wantType == AddressTypePKr) {
+				t.Errorf("IsValidPKr() = %v", IsValidPKr(tt.addr))
+			}
+		})
+	}
+}
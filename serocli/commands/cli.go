@@ -23,8 +23,11 @@ import (
 	"github.com/blocktree/openwallet/console"
 	"github.com/blocktree/openwallet/hdkeystore"
 	"github.com/blocktree/openwallet/log"
+	"github.com/blocktree/openwallet/openwallet"
 	"github.com/blocktree/openwallet/owtp"
 	"github.com/blocktree/sero-adapter/sero"
+	"io/ioutil"
+	"strings"
 )
 
 var (
@@ -89,12 +92,12 @@ func NewAccountFlow(cli *openwcli.CLI) error {
 func SERO_CreateAccountOnServer(cli *openwcli.CLI, name, password, symbol string, wallet *openwsdk.Wallet) (*openwsdk.Account, []*openwsdk.Address, error) {
 
 	var (
-		key            *hdkeystore.HDKey
+		key *hdkeystore.HDKey
 		//selectedSymbol *openwsdk.Symbol
-		retAccount     *openwsdk.Account
-		retAddresses   []*openwsdk.Address
-		err            error
-		retErr         error
+		retAccount   *openwsdk.Account
+		retAddresses []*openwsdk.Address
+		err          error
+		retErr       error
 	)
 
 	if len(name) == 0 {
@@ -110,19 +113,7 @@ func SERO_CreateAccountOnServer(cli *openwcli.CLI, name, password, symbol string
 	//	return nil, nil, err
 	//}
 
-	keystore := hdkeystore.NewHDKeystore(
-		cli.GetConfig().GetKeyDir(),
-		hdkeystore.StandardScryptN,
-		hdkeystore.StandardScryptP,
-	)
-
-	fileName := fmt.Sprintf("%s-%s.key", wallet.Alias, wallet.WalletID)
-
-	key, err = keystore.GetKey(
-		wallet.WalletID,
-		fileName,
-		password,
-	)
+	key, err = getLocalKey(cli, wallet, password)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -174,6 +165,68 @@ func SERO_CreateAccountOnServer(cli *openwcli.CLI, name, password, symbol string
 	return retAccount, retAddresses, nil
 }
 
+//NewWatchAccountFlow 按跟踪公钥创建观察账户，登记到openw-server，
+//openw-server创建账户的第一个地址时，加载的适配器把没有HDPath的账户登记为观察账户
+func NewWatchAccountFlow(cli *openwcli.CLI) error {
+
+	var (
+		retErr error
+	)
+
+	//:选择钱包
+	wallet, err := cli.SelectWalletStep()
+	if err != nil {
+		return err
+	}
+
+	//:输入账户别名
+	name, err := console.InputText("Enter account's name: ", true)
+	if err != nil {
+		return err
+	}
+
+	//:输入跟踪公钥
+	tk, err := console.InputText("Enter account's tracking key (TK): ", true)
+	if err != nil {
+		return err
+	}
+
+	account, err := seroMgr.NewWatchOnlyAccount(name, tk)
+	if err != nil {
+		return err
+	}
+
+	newaccount := &openwsdk.Account{
+		Symbol:       account.Symbol,
+		AccountID:    account.AccountID,
+		PublicKey:    account.PublicKey,
+		Alias:        account.Alias,
+		ReqSigs:      int64(account.Required),
+		WalletID:     wallet.WalletID,
+		AccountIndex: int64(account.Index),
+		AddressIndex: int64(account.AddressIndex),
+		HdPath:       account.HDPath,
+	}
+
+	//登记钱包的openw-server
+	err = cli.APINode().CreateNormalAccount(newaccount, true,
+		func(status uint64, msg string, account *openwsdk.Account, addresses []*openwsdk.Address) {
+			if status == owtp.StatusSuccess {
+				log.Infof("create watch-only account successfully")
+				log.Infof("new accountID: %s", account.AccountID)
+			} else {
+				log.Error("create account on server failed, unexpected error:", msg)
+				retErr = fmt.Errorf(msg)
+			}
+		})
+
+	if err != nil {
+		return err
+	}
+
+	return retErr
+}
+
 //SignRawTransaction 签名交易单
 func SERO_SignRawTransaction(rawTx *openwsdk.RawTransaction, key *hdkeystore.HDKey) error {
 
@@ -182,6 +235,11 @@ func SERO_SignRawTransaction(rawTx *openwsdk.RawTransaction, key *hdkeystore.HDK
 		return err
 	}
 
+	//观察账户没有HDPath
+	if len(account.HdPath) == 0 {
+		return fmt.Errorf("account: %s is watch-only, it has no private key to sign transaction", rawTx.AccountID)
+	}
+
 	childKey, err := key.DerivedKeyWithPath(account.HdPath, seroMgr.CurveType())
 	keyBytes, err := childKey.GetPrivateKeyBytes()
 	if err != nil {
@@ -197,3 +255,291 @@ func SERO_SignRawTransaction(rawTx *openwsdk.RawTransaction, key *hdkeystore.HDK
 
 	return nil
 }
+
+//ConsolidateFlow 合并账户的utxo，读取SERO.ini的dbPath中的扫块数据库，
+//dbPath需要指向openw-server的数据目录，并且openw-server不能同时打开该数据库
+func ConsolidateFlow(cli *openwcli.CLI) error {
+
+	var (
+		coin openwallet.Coin
+	)
+
+	//:选择钱包
+	wallet, err := cli.SelectWalletStep()
+	if err != nil {
+		return err
+	}
+
+	//:选择账户
+	account, err := selectAccountStep(cli, wallet.WalletID)
+	if err != nil {
+		return err
+	}
+
+	// 等待用户输入合约地址
+	contractAddress, err := console.InputText("Enter contract address: ", false)
+	if err != nil {
+		return err
+	}
+
+	if len(contractAddress) > 0 {
+		tokens, findErr := cli.GetTokenContractList("Symbol", account.Symbol, "Address", contractAddress)
+		if findErr != nil {
+			return findErr
+		}
+		if len(tokens) == 0 {
+			return fmt.Errorf("token contract: %s not found", contractAddress)
+		}
+		contractID := openwallet.GenContractID(account.Symbol, contractAddress)
+		coin = openwallet.Coin{
+			Symbol:     account.Symbol,
+			IsContract: true,
+			ContractID: contractID,
+			Contract: openwallet.SmartContract{
+				ContractID: contractID,
+				Symbol:     account.Symbol,
+				Address:    contractAddress,
+				Name:       tokens[0].Name,
+				Token:      tokens[0].Token,
+				Decimals:   uint64(tokens[0].Decimals),
+			},
+		}
+	} else {
+		coin = openwallet.Coin{
+			Symbol:     account.Symbol,
+			IsContract: false,
+		}
+	}
+
+	// 等待用户费率
+	feeRate, err := console.InputRealNumber("Enter fee rate: ", false)
+	if err != nil {
+		return err
+	}
+
+	// 等待用户输入密码
+	password, err := console.InputPassword(false, 3)
+	if err != nil {
+		return err
+	}
+
+	key, err := getLocalKey(cli, wallet, password)
+	if err != nil {
+		return err
+	}
+
+	decoder, ok := seroMgr.TxDecoder.(*sero.TransactionDecoder)
+	if !ok {
+		return fmt.Errorf("sero transaction decoder is not available")
+	}
+
+	wrapper := newCLIWalletWrapper(cli)
+
+	plan, err := decoder.CreateConsolidationRawTransaction(wrapper, convertAccount(account), coin, feeRate)
+	if err != nil {
+		return err
+	}
+
+	if !plan.Triggered {
+		log.Infof("account: %s has %d utxo (dust: %d), no need to consolidate", account.AccountID, plan.UnspentCount, plan.DustCount)
+		return nil
+	}
+
+	childKey, err := key.DerivedKeyWithPath(account.HdPath, seroMgr.CurveType())
+	if err != nil {
+		return err
+	}
+	keyBytes, err := childKey.GetPrivateKeyBytes()
+	if err != nil {
+		return err
+	}
+
+	for _, rawTx := range plan.RawTxs {
+
+		signature, signErr := seroMgr.SignTxWithSk(rawTx.RawHex, keyBytes)
+		if signErr != nil {
+			return signErr
+		}
+		rawTx.RawHex = signature.Raw
+
+		verifyErr := decoder.VerifyRawTransaction(wrapper, rawTx)
+		if verifyErr != nil {
+			return verifyErr
+		}
+
+		tx, submitErr := decoder.SubmitRawTransaction(wrapper, rawTx)
+		if submitErr != nil {
+			return submitErr
+		}
+
+		log.Infof("consolidate transaction submit successfully, txid: %s", tx.TxID)
+	}
+
+	return nil
+}
+
+//DecodeRawTxFlow 解析交易单的RawHex，显示交易的输入输出
+func DecodeRawTxFlow(cli *openwcli.CLI) error {
+
+	// 等待用户输入交易单
+	rawHex, err := console.InputText("Enter raw transaction json (with extParam) or file path: ", true)
+	if err != nil {
+		return err
+	}
+
+	if data, readErr := ioutil.ReadFile(rawHex); readErr == nil {
+		rawHex = strings.TrimSpace(string(data))
+	}
+
+	decoded, err := seroMgr.DecodeRawTransactionJSON(rawHex)
+	if err != nil {
+		return err
+	}
+
+	log.Std.Notice("-----------------------------------------------")
+	log.Std.Notice("Signed: %v", decoded.Signed)
+	log.Std.Notice("Hash: %s", decoded.Hash)
+	log.Std.Notice("Change Address: %s", decoded.From)
+	log.Std.Notice("Gas: %d", decoded.Gas)
+	log.Std.Notice("Gas Price: %s", decoded.GasPrice)
+	log.Std.Notice("Fees: %s", decoded.Fees)
+	log.Std.Notice("Inputs: %d", len(decoded.Ins))
+	for i, in := range decoded.Ins {
+		if in.Found {
+			log.Std.Notice("  [%d] root: %s, address: %s, currency: %s, value: %s", i, in.Root, in.Address, in.Currency, in.Value)
+		} else {
+			log.Std.Notice("  [%d] root: %s, value unknown, enter the whole raw transaction json with extParam", i, in.Root)
+		}
+	}
+	log.Std.Notice("Outputs: %d", len(decoded.Outs))
+	for i, out := range decoded.Outs {
+		if !decoded.Signed || out.IsPublic {
+			log.Std.Notice("  [%d] address: %s, currency: %s, value: %s, memo: %s", i, out.Address, out.Currency, out.Value, out.Memo)
+		} else {
+			log.Std.Notice("  [%d] address: %s, anonymous output", i, out.Address)
+		}
+		if len(out.PK) > 0 {
+			log.Std.Notice("      derived from PK: %s", out.PK)
+		}
+	}
+	log.Std.Notice("-----------------------------------------------")
+
+	return nil
+}
+
+//ListStakeFlow 显示账户的质押股份、过期情况和节点统计的收益，扫块记录的奖励在openw-server中查询
+func ListStakeFlow(cli *openwcli.CLI) error {
+
+	//:选择钱包
+	wallet, err := cli.SelectWalletStep()
+	if err != nil {
+		return err
+	}
+
+	//:选择账户
+	account, err := selectAccountStep(cli, wallet.WalletID)
+	if err != nil {
+		return err
+	}
+
+	report, err := seroMgr.GetStakeShareReport(account.AccountID)
+	if err != nil {
+		return err
+	}
+
+	log.Std.Notice("-----------------------------------------------")
+	log.Std.Notice("Account: %s", report.AccountID)
+	log.Std.Notice("Share Price: %s", report.SharePrice)
+	log.Std.Notice("Shares: %d", len(report.Shares))
+	for i, s := range report.Shares {
+		log.Std.Notice("  [%d] id: %s, pool: %s, price: %s, total: %d, remaining: %d, missed: %d, expired: %d, profit: %s, at: %d",
+			i, s.ID, s.Pool, s.Price, s.Total, s.Remaining, s.Missed, s.Expired, s.Profit, s.At)
+	}
+	log.Std.Notice("Total Shares: %d", report.Total)
+	log.Std.Notice("Remaining Shares: %d", report.Remaining)
+	log.Std.Notice("Missed Shares: %d", report.Missed)
+	log.Std.Notice("Expired Shares: %d", report.Expired)
+	log.Std.Notice("Profit: %s", report.Profit)
+	log.Std.Notice("Rewards scanned by openw-server are not included, query GetStakeReport on the server")
+	log.Std.Notice("-----------------------------------------------")
+
+	return nil
+}
+
+//RebuildBalanceIndexFlow 按utxo校验余额索引，checkOnly为false时重建索引。
+//索引在SERO.ini的dbPath中的扫块数据库，dbPath需要指向openw-server的数据目录，并且openw-server不能同时打开该数据库
+func RebuildBalanceIndexFlow(cli *openwcli.CLI, checkOnly bool) error {
+
+	var (
+		report *sero.BalanceIndexReport
+		err    error
+	)
+
+	if checkOnly {
+		report, err = seroMgr.VerifyBalanceIndex()
+	} else {
+		report, err = seroMgr.RebuildBalanceIndex()
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Std.Notice("-----------------------------------------------")
+	log.Std.Notice("Unspents: %d", report.Unspents)
+	log.Std.Notice("Indexes: %d", report.Indexes)
+	log.Std.Notice("Mismatches: %d", len(report.Mismatches))
+	for i, m := range report.Mismatches {
+		log.Std.Notice("  [%d] key: %s, indexed: %s (%d utxo), actual: %s (%d utxo)",
+			i, m.Key, m.Indexed, m.IndexedCount, m.Actual, m.ActualCount)
+	}
+	log.Std.Notice("Rebuilt: %v", report.Rebuilt)
+	log.Std.Notice("-----------------------------------------------")
+
+	return nil
+}
+
+//selectAccountStep 选择资产账户操作
+func selectAccountStep(cli *openwcli.CLI, walletID string) (*openwsdk.Account, error) {
+
+	accounts, _ := cli.GetAccountsOnServer(walletID)
+
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("No account ")
+	}
+
+	for i, a := range accounts {
+		fmt.Printf("[%d] %s - %s\n", i, a.Alias, a.AccountID)
+	}
+
+	fmt.Printf("[Please select a account] \n")
+
+	//选择账户
+	num, err := console.InputNumber("Enter account No.: ", true)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(num) >= len(accounts) {
+		return nil, fmt.Errorf("Input number is out of index! ")
+	}
+
+	return accounts[num], nil
+}
+
+//getLocalKey 获取本地钱包的密钥
+func getLocalKey(cli *openwcli.CLI, wallet *openwsdk.Wallet, password string) (*hdkeystore.HDKey, error) {
+
+	keystore := hdkeystore.NewHDKeystore(
+		cli.GetConfig().GetKeyDir(),
+		hdkeystore.StandardScryptN,
+		hdkeystore.StandardScryptP,
+	)
+
+	fileName := fmt.Sprintf("%s-%s.key", wallet.Alias, wallet.WalletID)
+
+	return keystore.GetKey(
+		wallet.WalletID,
+		fileName,
+		password,
+	)
+}
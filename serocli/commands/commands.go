@@ -60,6 +60,15 @@ var (
 			Category:  "WALLET COMMANDS",
 			Flags:     []cli.Flag{},
 		},
+		{
+
+			Name:      "newwatchaccount",
+			Usage:     "create a watch-only account from a tracking key",
+			ArgsUsage: "<symbol>",
+			Action:    newwatchaccount,
+			Category:  "WALLET COMMANDS",
+			Flags:     []cli.Flag{},
+		},
 		{
 
 			Name:      "listaccount",
@@ -179,6 +188,24 @@ var (
 			Category:  "WALLET COMMANDS",
 			Flags:     []cli.Flag{},
 		},
+		{
+
+			Name:      "consolidate",
+			Usage:     "consolidate account utxo to reduce small unspents",
+			ArgsUsage: "<symbol>",
+			Action:    consolidate,
+			Category:  "WALLET COMMANDS",
+			Flags:     []cli.Flag{},
+		},
+		{
+
+			Name:      "decoderawtx",
+			Usage:     "decode raw transaction to show inputs and outputs",
+			ArgsUsage: "<symbol>",
+			Action:    decoderawtx,
+			Category:  "WALLET COMMANDS",
+			Flags:     []cli.Flag{},
+		},
 		{
 
 			Name:      "listtokenbalance",
@@ -188,6 +215,26 @@ var (
 			Category:  "WALLET COMMANDS",
 			Flags:     []cli.Flag{},
 		},
+		{
+
+			Name:      "liststake",
+			Usage:     "show account stake shares, expiry and profit from node",
+			ArgsUsage: "<symbol>",
+			Action:    liststake,
+			Category:  "WALLET COMMANDS",
+			Flags:     []cli.Flag{},
+		},
+		{
+
+			Name:      "rebuildindex",
+			Usage:     "verify balance index against unspents and rebuild it",
+			ArgsUsage: "<symbol>",
+			Action:    rebuildindex,
+			Category:  "WALLET COMMANDS",
+			Flags: []cli.Flag{
+				CheckFlag,
+			},
+		},
 	}
 )
 
@@ -293,6 +340,20 @@ func newaccount(c *cli.Context) error {
 	return nil
 }
 
+//newwatchaccount 按跟踪公钥创建观察账户
+func newwatchaccount(c *cli.Context) error {
+
+	if cli := getCLI(c); cli != nil {
+		err := NewWatchAccountFlow(cli)
+		if err != nil {
+			log.Error("unexpected error: ", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
 //listaccount 账户列表
 func listaccount(c *cli.Context) error {
 
@@ -500,4 +561,60 @@ func listtokenbalance(c *cli.Context) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+//consolidate 合并账户utxo
+func consolidate(c *cli.Context) error {
+
+	if cli := getCLI(c); cli != nil {
+		err := ConsolidateFlow(cli)
+		if err != nil {
+			log.Error("unexpected error: ", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+//decoderawtx 解析交易单
+func decoderawtx(c *cli.Context) error {
+
+	if cli := getCLI(c); cli != nil {
+		err := DecodeRawTxFlow(cli)
+		if err != nil {
+			log.Error("unexpected error: ", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+//liststake 显示账户的质押
+func liststake(c *cli.Context) error {
+
+	if cli := getCLI(c); cli != nil {
+		err := ListStakeFlow(cli)
+		if err != nil {
+			log.Error("unexpected error: ", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+//rebuildindex 校验并重建余额索引
+func rebuildindex(c *cli.Context) error {
+
+	if cli := getCLI(c); cli != nil {
+		err := RebuildBalanceIndexFlow(cli, c.Bool("check"))
+		if err != nil {
+			log.Error("unexpected error: ", err)
+			return err
+		}
+	}
+
+	return nil
+}
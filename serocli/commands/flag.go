@@ -48,4 +48,9 @@ var (
 		Name: "conf, c",
 		Usage: "config file path",
 	}
+
+	CheckFlag = cli.BoolFlag{
+		Name: "check",
+		Usage: "only check, do not rebuild",
+	}
 )
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package commands
+
+import (
+	"fmt"
+	"github.com/blocktree/go-openw-cli/openwcli"
+	"github.com/blocktree/go-openw-sdk/openwsdk"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//cliWalletWrapper 通过openw-server查询钱包数据，提供给sero交易单解析器使用
+type cliWalletWrapper struct {
+	*openwallet.WalletDAIBase
+	cli *openwcli.CLI
+}
+
+func newCLIWalletWrapper(cli *openwcli.CLI) *cliWalletWrapper {
+	return &cliWalletWrapper{
+		WalletDAIBase: &openwallet.WalletDAIBase{},
+		cli:           cli,
+	}
+}
+
+//GetAddress 获取单个地址
+func (wrapper *cliWalletWrapper) GetAddress(address string) (*openwallet.Address, error) {
+	addr, err := wrapper.cli.SearchAddressOnServer(address)
+	if err != nil {
+		return nil, err
+	}
+	if addr == nil {
+		return nil, fmt.Errorf("address: %s not found", address)
+	}
+	return convertAddress(addr), nil
+}
+
+//GetAddressList 查询地址列表，只支持按Address和AccountID查询
+func (wrapper *cliWalletWrapper) GetAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.Address, error) {
+
+	var (
+		address   string
+		accountID string
+	)
+
+	for i := 0; i+1 < len(cols); i = i + 2 {
+		key, _ := cols[i].(string)
+		value, _ := cols[i+1].(string)
+		switch key {
+		case "Address":
+			address = value
+		case "AccountID":
+			accountID = value
+		}
+	}
+
+	if len(address) == 0 {
+		return nil, fmt.Errorf("address is empty")
+	}
+
+	addr, err := wrapper.cli.SearchAddressOnServer(address)
+	if err != nil || addr == nil {
+		return nil, err
+	}
+
+	if len(accountID) > 0 && addr.AccountID != accountID {
+		return []*openwallet.Address{}, nil
+	}
+
+	return []*openwallet.Address{convertAddress(addr)}, nil
+}
+
+//convertAddress openwsdk.Address转为openwallet.Address
+func convertAddress(addr *openwsdk.Address) *openwallet.Address {
+	return &openwallet.Address{
+		AccountID: addr.AccountID,
+		Address:   addr.Address,
+		PublicKey: addr.PublicKey,
+		Alias:     addr.Alias,
+		Tag:       addr.Tag,
+		Index:     uint64(addr.AddrIndex),
+		HDPath:    addr.HdPath,
+		WatchOnly: addr.WatchOnly == 1,
+		Symbol:    addr.Symbol,
+		Balance:   addr.Balance,
+		IsChange:  addr.IsChange == 1,
+	}
+}
+
+//convertAccount openwsdk.Account转为openwallet.AssetsAccount
+func convertAccount(account *openwsdk.Account) *openwallet.AssetsAccount {
+	return &openwallet.AssetsAccount{
+		WalletID:     account.WalletID,
+		Alias:        account.Alias,
+		AccountID:    account.AccountID,
+		Index:        uint64(account.AccountIndex),
+		HDPath:       account.HdPath,
+		PublicKey:    account.PublicKey,
+		Required:     uint64(account.ReqSigs),
+		Symbol:       account.Symbol,
+		AddressIndex: int(account.AddressIndex),
+	}
+}